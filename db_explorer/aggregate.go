@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const aggregatePath = "_aggregate"
+
+// aggregateRe matches fn(column) and count(*)
+var aggregateRe = regexp.MustCompile(`^(\w+)\((\*|[^()]+)\)$`)
+
+// aggregate is a single aggregate of GET /$table/_aggregate, col is nil for count(*)
+type aggregate struct {
+	fn   string
+	col  *Col
+	expr string
+}
+
+// numericAggregates only make sense over numbers
+var numericAggregates = map[string]bool{"count": false, "min": false, "max": false, "sum": true, "avg": true}
+
+// isNumeric tells whether the type of the column holds numbers, decimals
+// included even though they travel as strings
+func (d *DbExplorer) isNumeric(c Col) bool {
+	t := d.types.lookup(c)
+	if _, ok := t.(decimalType); ok {
+		return true
+	}
+	return t.Kind() == "integer" || t.Kind() == "number"
+}
+
+func (d *DbExplorer) parseAggregates(tab Table, list string) (aggs []aggregate, err error) {
+	for _, raw := range strings.Split(list, ",") {
+		m := aggregateRe.FindStringSubmatch(strings.TrimSpace(raw))
+		if m == nil {
+			return nil, badRequest("invalid aggregate %s", raw)
+		}
+		fn := strings.ToLower(m[1])
+		numeric, ok := numericAggregates[fn]
+		if !ok {
+			return nil, badRequest("unknown aggregate function %s", m[1])
+		}
+		a := aggregate{fn: fn, expr: fn + "(" + m[2] + ")"}
+		if m[2] == "*" {
+			if fn != "count" {
+				return nil, badRequest("%s(*) is not supported", fn)
+			}
+			aggs = append(aggs, a)
+			continue
+		}
+		c, ok := tab.column(m[2])
+		if !ok {
+			return nil, badRequest("unknown column %s", m[2])
+		}
+		if numeric && !d.isNumeric(c) {
+			return nil, badRequest("%s requires a numeric column, %s is %s", fn, c.Name, c.Type)
+		}
+		a.col = &c
+		aggs = append(aggs, a)
+	}
+	return aggs, nil
+}
+
+// resultColumn describes the value of the aggregate so that scanRows can
+// decode it like a column of the table
+func (a aggregate) resultColumn() Col {
+	switch {
+	case a.fn == "count":
+		return Col{Name: a.expr, Type: "bigint"}
+	case a.fn == "avg" && a.col.Type != "decimal" && a.col.Type != "numeric":
+		return Col{Name: a.expr, Type: "double", Null: true}
+	}
+	c := *a.col
+	c.Name, c.Null = a.expr, true
+	return c
+}
+
+func (d *DbExplorer) aggregateSQL(a aggregate) string {
+	if a.col == nil {
+		return "COUNT(*)"
+	}
+	return fmt.Sprintf("%s(%s)", strings.ToUpper(a.fn), d.quote(a.col.Name))
+}
+
+// getAggregate handles GET /$table/_aggregate?group=a,b&agg=count(*),avg(c),
+// filters work as in lists and a limit is only applied when it is given or
+// set by WithMaxLimit
+func (d *DbExplorer) getAggregate(w http.ResponseWriter, r *http.Request, arr []string) (err error) {
+	table := arr[0]
+	if _, ok := d.columns[table]; !ok {
+		return writeUnknownTable(w)
+	}
+	p := principalFrom(r)
+	if !d.policy.canRead(p, table) {
+		return writeForbidden(w)
+	}
+	tab := d.visibleTable(p, table)
+	params := r.URL.Query()
+
+	lq, err := d.parseListQuery(r, tab)
+	if err != nil {
+		return err
+	}
+	if lq.cursor {
+		return badRequest("cursor pagination is not supported with aggregates")
+	}
+	agg := params.Get("agg")
+	if agg == "" {
+		return badRequest("agg is required")
+	}
+	aggs, err := d.parseAggregates(tab, agg)
+	if err != nil {
+		return err
+	}
+	var groups []Col
+	if group := params.Get("group"); group != "" {
+		for _, name := range strings.Split(group, ",") {
+			c, ok := tab.column(name)
+			if !ok {
+				return badRequest("unknown column %s", name)
+			}
+			groups = append(groups, c)
+		}
+	}
+	grouped := make(map[string]struct{}, len(groups))
+	for _, c := range groups {
+		grouped[c.Name] = struct{}{}
+	}
+	for _, o := range lq.order {
+		if _, ok := grouped[o.col.Name]; !ok {
+			return badRequest("order by %s requires grouping by it", o.col.Name)
+		}
+	}
+	if len(lq.order) == 0 {
+		for _, c := range groups {
+			lq.order = append(lq.order, orderBy{col: c})
+		}
+	}
+
+	columns := append([]Col{}, groups...)
+	exprs := make([]string, 0, len(groups)+len(aggs))
+	for _, c := range groups {
+		exprs = append(exprs, d.quote(c.Name))
+	}
+	for _, a := range aggs {
+		columns = append(columns, a.resultColumn())
+		exprs = append(exprs, d.aggregateSQL(a))
+	}
+	args := &sqlArgs{dialect: d.dialect}
+	q := fmt.Sprintf("SELECT %s FROM %s", strings.Join(exprs, ", "), d.quote(table))
+	q += d.whereSQL(lq.where, args)
+	if len(groups) > 0 {
+		q += " GROUP BY " + d.columnsSQL(groups)
+	}
+	q += d.orderSQL(lq.order)
+	if _, ok := params["limit"]; ok {
+		q += fmt.Sprintf(" LIMIT %s OFFSET %s", args.add(lq.limit), args.add(lq.offset))
+	} else if d.maxLimit > 0 {
+		// one more group tells that the result would be cut short
+		q += fmt.Sprintf(" LIMIT %s", args.add(d.maxLimit+1))
+	}
+
+	rows, err := d.reader().Query(q, args.args...)
+	if err != nil {
+		return errorInternal
+	}
+	defer rows.Close()
+	result, err := d.processSelectRows(columns, rows)
+	if err != nil {
+		return errorInternal
+	}
+	if _, ok := params["limit"]; !ok && d.maxLimit > 0 && len(result) > d.maxLimit {
+		return badRequest("more than %d groups, use limit and offset", d.maxLimit)
+	}
+	writeResponse(w, finalResponse{Response: map[string]interface{}{"groups": result}})
+	return
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, []string{
+			`CREATE TABLE people (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name varchar(255) NOT NULL,
+  gender varchar(1) NOT NULL,
+  age int DEFAULT NULL,
+  balance decimal(10,2) NOT NULL DEFAULT 0
+);`,
+			`INSERT INTO people (name, gender, age, balance) VALUES
+('ann', 'f', 30, 10.50),
+('bob', 'm', 20, 1.25),
+('eve', 'f', 40, 0),
+('max', 'm', NULL, 2.25);`,
+		})
+
+		s.run(t, []Case{
+			Case{
+				Path:  "/people/_aggregate",
+				Query: "group=gender&agg=count(*),avg(age),max(name)",
+				Result: CR{
+					"response": CR{
+						"groups": []CR{
+							CR{"gender": "f", "count(*)": 2, "avg(age)": 35, "max(name)": "eve"},
+							CR{"gender": "m", "count(*)": 2, "avg(age)": 20, "max(name)": "max"},
+						},
+					},
+				},
+			},
+			Case{
+				Path:  "/people/_aggregate",
+				Query: "agg=COUNT(age),sum(age),min(age)&where[gender]=f",
+				Result: CR{
+					"response": CR{
+						"groups": []CR{
+							CR{"count(age)": 2, "sum(age)": 70, "min(age)": 30},
+						},
+					},
+				},
+			},
+			Case{
+				Path:  "/people/_aggregate",
+				Query: "group=gender&agg=count(*)&order=-gender&limit=1",
+				Result: CR{
+					"response": CR{
+						"groups": []CR{
+							CR{"gender": "m", "count(*)": 2},
+						},
+					},
+				},
+			},
+			Case{
+				Path:   "/people/_aggregate",
+				Query:  "agg=avg(name)",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "avg requires a numeric column, name is varchar", "code": "bad_request"},
+			},
+			Case{
+				Path:   "/people/_aggregate",
+				Query:  "agg=median(age)",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "unknown aggregate function median", "code": "bad_request"},
+			},
+			Case{
+				Path:   "/people/_aggregate",
+				Query:  "agg=sum(*)",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "sum(*) is not supported", "code": "bad_request"},
+			},
+			Case{
+				Path:   "/people/_aggregate",
+				Query:  "group=unknown&agg=count(*)",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "unknown column unknown", "code": "bad_request"},
+			},
+			Case{
+				Path:   "/people/_aggregate",
+				Query:  "agg=count(*)&order=name",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "order by name requires grouping by it", "code": "bad_request"},
+			},
+			Case{
+				Path:   "/people/_aggregate",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "agg is required", "code": "bad_request"},
+			},
+		})
+
+		_, result := doWithHeaders(t, http.MethodGet, s.URL+"/people/_aggregate?agg=sum(balance),avg(balance)", nil, nil)
+		group := result["response"].(map[string]interface{})["groups"].([]interface{})[0].(map[string]interface{})
+		if group["sum(balance)"] != "14.00" || group["avg(balance)"] != "3.50" {
+			t.Errorf("decimal aggregates keep the scale of the column, got %v", group)
+		}
+	})
+}
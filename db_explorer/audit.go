@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const auditPath = "_audit"
+
+// Operations recorded in the audit log
+const (
+	AuditInsert = "insert"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+type (
+	// AuditEntry describes a single mutation. PK is the key as it appears in
+	// the path of the record, Before is nil for inserts and After for deletes.
+	AuditEntry struct {
+		Time      time.Time              `json:"time"`
+		Principal string                 `json:"principal"`
+		Op        string                 `json:"op"`
+		Table     string                 `json:"table"`
+		PK        string                 `json:"pk"`
+		Before    map[string]interface{} `json:"before"`
+		After     map[string]interface{} `json:"after"`
+	}
+
+	// auditSink stores audit entries, write is called with the transaction
+	// of the mutation
+	auditSink interface {
+		write(dialect Dialect, ex execer, e AuditEntry) error
+		// history returns matching entries, the newest first
+		history(dialect Dialect, q queryer, table, pk string, limit, offset int) ([]AuditEntry, error)
+	}
+
+	auditTable struct {
+		name string
+	}
+
+	auditFile struct {
+		path string
+		mu   sync.Mutex
+	}
+)
+
+// WithAuditTable writes the audit log to a table of the same database, in
+// the transaction of every mutation, so a mutation and its entry are
+// committed or rolled back together. The table must exist, in MySQL:
+//
+//	CREATE TABLE audit_log (
+//	  id int NOT NULL AUTO_INCREMENT PRIMARY KEY,
+//	  at varchar(64) NOT NULL,
+//	  principal varchar(255) NOT NULL,
+//	  op varchar(16) NOT NULL,
+//	  table_name varchar(255) NOT NULL,
+//	  pk varchar(255) NOT NULL,
+//	  before_image longtext,
+//	  after_image longtext
+//	);
+//
+// Postgres needs id SERIAL PRIMARY KEY and text images instead. Entries are
+// read back newest first by id, so it has to grow with every entry.
+// The table itself is not served by the explorer.
+func WithAuditTable(name string) Option {
+	return func(d *DbExplorer) {
+		d.audit = &auditTable{name: name}
+	}
+}
+
+// WithAuditFile appends the audit log to a file as JSON lines. Entries of a
+// transaction are appended once it is committed, if that fails the request
+// fails although the mutation stays.
+func WithAuditFile(path string) Option {
+	return func(d *DbExplorer) {
+		d.audit = &auditFile{path: path}
+	}
+}
+
+// hidesTable tells whether the table holds the audit log
+func (d *DbExplorer) hidesTable(table string) bool {
+	at, ok := d.audit.(*auditTable)
+	return ok && at.name == table
+}
+
+// keyString formats a key the way it appears in the path of a record
+func keyString(key []interface{}) string {
+	parts := make([]string, len(key))
+	for i, v := range key {
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// auditImage returns the record with the key as the transaction sees it, it
+// is only read when there is an audit log
+func (d *DbExplorer) auditImage(q queryer, table string, key []interface{}) (map[string]interface{}, error) {
+	if d.audit == nil {
+		return nil, nil
+	}
+	rows, err := d.selectRow(q, table, key)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return rows[0], nil
+}
+
+func (d *DbExplorer) writeAudit(ex execer, op, table string, key []interface{}, before, after map[string]interface{}) error {
+	if d.audit == nil {
+		return nil
+	}
+	return d.audit.write(d.dialect, ex, AuditEntry{
+		Time:      time.Now().UTC(),
+		Principal: d.actor,
+		Op:        op,
+		Table:     table,
+		PK:        keyString(key),
+		Before:    before,
+		After:     after,
+	})
+}
+
+func (t *auditTable) write(dialect Dialect, ex execer, e AuditEntry) error {
+	images := make([]interface{}, 2)
+	for i, image := range []map[string]interface{}{e.Before, e.After} {
+		if image == nil {
+			continue
+		}
+		bs, err := json.Marshal(image)
+		if err != nil {
+			return err
+		}
+		images[i] = string(bs)
+	}
+	args := &sqlArgs{dialect: dialect}
+	q := fmt.Sprintf("INSERT INTO %s (at, principal, op, table_name, pk, before_image, after_image) VALUES (%s, %s, %s, %s, %s, %s, %s)",
+		dialect.Quote(t.name), args.add(e.Time.Format(time.RFC3339Nano)), args.add(e.Principal), args.add(e.Op),
+		args.add(e.Table), args.add(e.PK), args.add(images[0]), args.add(images[1]))
+	_, err := ex.Exec(q, args.args...)
+	return err
+}
+
+func (t *auditTable) history(dialect Dialect, q queryer, table, pk string, limit, offset int) (entries []AuditEntry, err error) {
+	args := &sqlArgs{dialect: dialect}
+	where := make([]string, 0, 2)
+	if table != "" {
+		where = append(where, "table_name = "+args.add(table))
+	}
+	if pk != "" {
+		where = append(where, "pk = "+args.add(pk))
+	}
+	query := fmt.Sprintf("SELECT at, principal, op, table_name, pk, before_image, after_image FROM %s", dialect.Quote(t.name))
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT %s OFFSET %s", args.add(limit), args.add(offset))
+	rows, err := q.Query(query, args.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries = make([]AuditEntry, 0)
+	for rows.Next() {
+		var e AuditEntry
+		var at string
+		var before, after sql.NullString
+		if err = rows.Scan(&at, &e.Principal, &e.Op, &e.Table, &e.PK, &before, &after); err != nil {
+			return nil, err
+		}
+		if e.Time, err = time.Parse(time.RFC3339Nano, at); err != nil {
+			return nil, err
+		}
+		for _, image := range []struct {
+			raw sql.NullString
+			v   *map[string]interface{}
+		}{{before, &e.Before}, {after, &e.After}} {
+			if !image.raw.Valid {
+				continue
+			}
+			if err = decodeJSON([]byte(image.raw.String), image.v); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (f *auditFile) write(_ Dialect, ex execer, e AuditEntry) error {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	// entries of a transaction wait for the commit, a rollback drops them
+	if t, ok := ex.(*txn); ok {
+		t.auditFile = f
+		t.audited = append(t.audited, bs)
+		return nil
+	}
+	return f.append(bs)
+}
+
+func (f *auditFile) append(entries ...[]byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	for _, bs := range entries {
+		if _, err = file.Write(append(bs, '\n')); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// history reads the whole file, it is meant for logs small enough to be
+// browsed this way
+func (f *auditFile) history(_ Dialect, _ queryer, table, pk string, limit, offset int) ([]AuditEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.Open(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []AuditEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	matched := make([]AuditEntry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), importMaxLine)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err = decodeJSON(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		if (table == "" || e.Table == table) && (pk == "" || e.PK == pk) {
+			matched = append(matched, e)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := make([]AuditEntry, 0, limit)
+	for i := len(matched) - 1 - offset; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, matched[i])
+	}
+	return entries, nil
+}
+
+// getAudit handles GET /_audit?table=&pk=, the log keeps images of hidden
+// columns so it is only shown to admins
+func (d *DbExplorer) getAudit(w http.ResponseWriter, r *http.Request) (err error) {
+	if d.audit == nil {
+		return errorUnknownPath
+	}
+	if !d.policy.canAdmin(principalFrom(r)) {
+		return writeForbidden(w)
+	}
+	params := r.URL.Query()
+	table, pk := params.Get("table"), params.Get("pk")
+	if pk != "" && table == "" {
+		return badRequest("pk requires table")
+	}
+	limit, err := d.readLimit(r, 5)
+	if err != nil {
+		return err
+	}
+	entries, err := d.audit.history(d.dialect, d.primary(), table, pk, limit, readParam(r, "offset", 0))
+	if err != nil {
+		return errorInternal
+	}
+	writeResponse(w, finalResponse{Response: map[string]interface{}{"entries": entries}})
+	return
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		sinks := map[string]Option{
+			"table": WithAuditTable("audit_log"),
+			"file":  WithAuditFile(filepath.Join(t.TempDir(), "audit.jsonl")),
+		}
+		for name, sink := range sinks {
+			t.Run(name, func(t *testing.T) {
+				testAuditSink(t, b, name, sink)
+			})
+		}
+	})
+}
+
+func testAuditSink(t *testing.T, b testBackend, name string, sink Option) {
+	ts := b.serve(t, []string{`CREATE TABLE audit_log (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  at varchar(64) NOT NULL,
+  principal varchar(255) NOT NULL,
+  op varchar(16) NOT NULL,
+  table_name varchar(255) NOT NULL,
+  pk varchar(255) NOT NULL,
+  before_image text,
+  after_image text
+);`}, sink, WithAuthenticator(APIKeyAuthenticator{
+		"admin-key": Principal{Name: "root", Roles: []string{"admin"}},
+	}))
+
+	admin := map[string]string{"X-API-Key": "admin-key"}
+	steps := []struct {
+		method string
+		path   string
+		body   interface{}
+	}{
+		{http.MethodPut, "/items/", CR{"title": "audit", "description": ""}},
+		{http.MethodPost, "/items/3", CR{"title": "audited"}},
+		{http.MethodPatch, "/items/2", CR{"updated": "root"}},
+		{http.MethodDelete, "/items/3", nil},
+	}
+	for _, s := range steps {
+		resp, result := doWithHeaders(t, s.method, ts.URL+s.path, admin, s.body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: %d %v", s.method, s.path, resp.StatusCode, result)
+		}
+	}
+
+	resp, result := doWithHeaders(t, http.MethodGet, ts.URL+"/_audit?table=items&pk=3&limit=10", admin, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /_audit: %d %v", resp.StatusCode, result)
+	}
+	entries := result["response"].(map[string]interface{})["entries"].([]interface{})
+	for _, e := range entries {
+		delete(e.(map[string]interface{}), "time")
+	}
+	expected := []CR{
+		CR{"principal": "root", "op": "delete", "table": "items", "pk": "3",
+			"before": CR{"id": 3, "title": "audited", "description": "", "updated": nil}, "after": nil},
+		CR{"principal": "root", "op": "update", "table": "items", "pk": "3",
+			"before": CR{"id": 3, "title": "audit", "description": "", "updated": nil},
+			"after":  CR{"id": 3, "title": "audited", "description": "", "updated": nil}},
+		CR{"principal": "root", "op": "insert", "table": "items", "pk": "3",
+			"before": nil, "after": CR{"id": 3, "title": "audit", "description": "", "updated": nil}},
+	}
+	if !reflect.DeepEqual(jsonRoundTrip(entries), jsonRoundTrip(expected)) {
+		t.Errorf("expected %v, got %v", expected, entries)
+	}
+
+	_, result = doWithHeaders(t, http.MethodGet, ts.URL+"/_audit?table=items&limit=1", admin, nil)
+	entries = result["response"].(map[string]interface{})["entries"].([]interface{})
+	if len(entries) != 1 || entries[0].(map[string]interface{})["op"] != "delete" {
+		t.Errorf("expected the latest entry, got %v", entries)
+	}
+	resp, result = doWithHeaders(t, http.MethodGet, ts.URL+"/_audit?pk=3", admin, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("pk without table: expected 400, got %d %v", resp.StatusCode, result)
+	}
+	resp, _ = doWithHeaders(t, http.MethodGet, ts.URL+"/_audit", nil, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("anonymous is an admin without a policy: expected 200, got %d", resp.StatusCode)
+	}
+
+	// the audit table is not served
+	resp, _ = doWithHeaders(t, http.MethodGet, ts.URL+"/audit_log", admin, nil)
+	if name == "table" && resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /audit_log: expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestAuditFileRollback(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		path := filepath.Join(t.TempDir(), "audit.jsonl")
+		ts := b.serve(t, []string{`CREATE UNIQUE INDEX users_login ON users (login);`}, WithAuditFile(path))
+
+		resp, result := doWithHeaders(t, http.MethodPost, ts.URL+"/_batch", nil, []CR{
+			CR{"op": "create", "table": "items", "record": CR{"title": "rolled back", "description": ""}},
+			CR{"op": "create", "table": "users", "record": CR{"login": "rvasily", "password": "", "email": "", "info": ""}},
+		})
+		if resp.StatusCode != http.StatusConflict {
+			t.Fatalf("expected the batch to fail, got %d %v", resp.StatusCode, result)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			bs, _ := ioutil.ReadFile(path)
+			t.Errorf("a rolled back batch must not be audited, got %s", bs)
+		}
+
+		doWithHeaders(t, http.MethodPost, ts.URL+"/_batch", nil, []CR{
+			CR{"op": "update", "table": "items", "key": 1, "record": CR{"title": "committed"}},
+		})
+		_, result = doWithHeaders(t, http.MethodGet, ts.URL+"/_audit", nil, nil)
+		entries := result["response"].(map[string]interface{})["entries"].([]interface{})
+		if len(entries) != 1 || entries[0].(map[string]interface{})["op"] != AuditUpdate {
+			t.Errorf("expected the committed update, got %v", entries)
+		}
+	})
+}
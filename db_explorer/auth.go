@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	errorUnauthorized = errors.New("unauthorized")
+	errorForbidden    = errors.New("forbidden")
+)
+
+// anyone matches every principal in policy rules, including anonymous
+const anyone = "*"
+
+type (
+	Principal struct {
+		Name  string   `json:"sub"`
+		Roles []string `json:"roles,omitempty"`
+	}
+	// Authenticator extracts the principal from a request. ok is false when
+	// the request carries no credentials the authenticator understands, an
+	// error means they are present but invalid.
+	Authenticator interface {
+		Authenticate(r *http.Request) (p Principal, ok bool, err error)
+	}
+	// APIKeyAuthenticator accepts static keys sent in the X-API-Key header
+	APIKeyAuthenticator map[string]Principal
+	// HMACAuthenticator accepts "Authorization: Bearer <token>" tokens made by NewHMACToken
+	HMACAuthenticator struct {
+		Secret []byte
+		// Now is used to check expiry, time.Now if nil
+		Now func() time.Time
+	}
+	hmacClaims struct {
+		Principal
+		Expires int64 `json:"exp"`
+	}
+
+	// Policy describes who may read and write which tables. A table without
+	// a rule falls back to the "*" rule, if there is none it is not accessible.
+	Policy struct {
+		Tables map[string]TableRule `json:"tables"`
+		// Admin lists who may use the admin endpoints such as POST /_schema
+		Admin []string `json:"admin"`
+	}
+	// TableRule lists principal names or roles, "*" stands for anyone
+	TableRule struct {
+		Read  []string `json:"read"`
+		Write []string `json:"write"`
+		// Columns narrows access to single columns: an empty read list hides
+		// the column, an empty write list makes it read-only
+		Columns map[string]ColumnRule `json:"columns"`
+	}
+	ColumnRule struct {
+		Read  []string `json:"read"`
+		Write []string `json:"write"`
+	}
+
+	principalKey struct{}
+)
+
+func (p Principal) matches(list []string) bool {
+	for _, s := range list {
+		if s == anyone || s == p.Name {
+			return true
+		}
+		for _, role := range p.Roles {
+			if s == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (a APIKeyAuthenticator) Authenticate(r *http.Request) (Principal, bool, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		return Principal{}, false, nil
+	}
+	for k, p := range a {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return p, true, nil
+		}
+	}
+	return Principal{}, false, errorUnauthorized
+}
+
+func hmacSign(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewHMACToken issues a token for p valid until expires
+func NewHMACToken(secret []byte, p Principal, expires time.Time) string {
+	bs, _ := json.Marshal(hmacClaims{Principal: p, Expires: expires.Unix()})
+	payload := base64.RawURLEncoding.EncodeToString(bs)
+	return payload + "." + hmacSign(secret, payload)
+}
+
+func (a HMACAuthenticator) Authenticate(r *http.Request) (Principal, bool, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return Principal{}, false, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(auth, "Bearer "), ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(hmacSign(a.Secret, parts[0]))) {
+		return Principal{}, false, errorUnauthorized
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Principal{}, false, errorUnauthorized
+	}
+	var claims hmacClaims
+	if err = json.Unmarshal(bs, &claims); err != nil {
+		return Principal{}, false, errorUnauthorized
+	}
+	now := time.Now
+	if a.Now != nil {
+		now = a.Now
+	}
+	if now().Unix() >= claims.Expires {
+		return Principal{}, false, errorUnauthorized
+	}
+	return claims.Principal, true, nil
+}
+
+func (pol *Policy) rule(table string) (TableRule, bool) {
+	if rule, ok := pol.Tables[table]; ok {
+		return rule, true
+	}
+	rule, ok := pol.Tables[anyone]
+	return rule, ok
+}
+
+func (pol *Policy) canRead(p Principal, table string) bool {
+	if pol == nil {
+		return true
+	}
+	rule, ok := pol.rule(table)
+	return ok && p.matches(rule.Read)
+}
+
+func (pol *Policy) canWrite(p Principal, table string) bool {
+	if pol == nil {
+		return true
+	}
+	rule, ok := pol.rule(table)
+	return ok && p.matches(rule.Write)
+}
+
+func (pol *Policy) canAdmin(p Principal) bool {
+	return pol == nil || p.matches(pol.Admin)
+}
+
+func (pol *Policy) columnReadable(p Principal, table, col string) bool {
+	if pol == nil {
+		return true
+	}
+	rule, _ := pol.rule(table)
+	cr, ok := rule.Columns[col]
+	return !ok || p.matches(cr.Read)
+}
+
+func (pol *Policy) columnWritable(p Principal, table, col string) bool {
+	if pol == nil {
+		return true
+	}
+	rule, _ := pol.rule(table)
+	cr, ok := rule.Columns[col]
+	return !ok || p.matches(cr.Write)
+}
+
+// WithAuthenticator adds a way to authenticate requests, they are tried in
+// the order they were added
+func WithAuthenticator(a Authenticator) Option {
+	return func(d *DbExplorer) {
+		d.authenticators = append(d.authenticators, a)
+	}
+}
+
+// WithPolicy enables authorization, without it everything is allowed
+func WithPolicy(p Policy) Option {
+	return func(d *DbExplorer) {
+		d.policy = &p
+	}
+}
+
+// authenticate puts the principal into the request context, requests
+// without credentials are anonymous
+func (d *DbExplorer) authenticate(r *http.Request) (*http.Request, error) {
+	p := Principal{Name: "anonymous"}
+	for _, a := range d.authenticators {
+		found, ok, err := a.Authenticate(r)
+		if err != nil {
+			return r, err
+		}
+		if ok {
+			p = found
+			break
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p)), nil
+}
+
+func principalFrom(r *http.Request) Principal {
+	p, _ := r.Context().Value(principalKey{}).(Principal)
+	return p
+}
+
+// visibleTable returns the table as the principal sees it, without hidden columns
+func (d *DbExplorer) visibleTable(p Principal, table string) Table {
+	tab := d.columns[table]
+	if d.policy == nil {
+		return tab
+	}
+	cols := make([]Col, 0, len(tab.Columns))
+	for _, c := range tab.Columns {
+		if d.policy.columnReadable(p, table, c.Name) {
+			cols = append(cols, c)
+		}
+	}
+	tab.Columns = cols
+	return tab
+}
+
+func (d *DbExplorer) hideColumns(p Principal, table string, records []map[string]interface{}) {
+	if d.policy == nil {
+		return
+	}
+	for _, c := range d.columns[table].Columns {
+		if d.policy.columnReadable(p, table, c.Name) {
+			continue
+		}
+		for _, rec := range records {
+			delete(rec, c.Name)
+		}
+	}
+}
+
+// checkWritable rejects read-only columns and silently drops hidden ones
+// the same way unknown fields are ignored
+func (d *DbExplorer) checkWritable(p Principal, table string, rawRecord map[string]interface{}) error {
+	if d.policy == nil {
+		return nil
+	}
+	for k := range rawRecord {
+		if _, ok := d.columns[table].column(k); !ok {
+			continue
+		}
+		if !d.policy.columnReadable(p, table, k) {
+			delete(rawRecord, k)
+			continue
+		}
+		if !d.policy.columnWritable(p, table, k) {
+			return forbiddenError(k, "field "+k+" is read-only")
+		}
+	}
+	return nil
+}
+
+func writeForbidden(w http.ResponseWriter) error {
+	return writeError(w, errorForbidden)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// withHeader sends every request of a test server on behalf of one client
+func withHeader(h http.Handler, key, value string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set(key, value)
+		h.ServeHTTP(w, r)
+	})
+}
+
+func TestAuth(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, nil,
+			WithAuthenticator(APIKeyAuthenticator{
+				"admin-key":  Principal{Name: "root", Roles: []string{"admin"}},
+				"editor-key": Principal{Name: "bob", Roles: []string{"editor"}},
+			}),
+			WithAuthenticator(HMACAuthenticator{Secret: secret, Now: func() time.Time { return now }}),
+			WithPolicy(Policy{Tables: map[string]TableRule{
+				"items": TableRule{
+					Read:  []string{anyone},
+					Write: []string{"editor", "admin"},
+					Columns: map[string]ColumnRule{
+						"updated": ColumnRule{Read: []string{anyone}, Write: []string{"admin"}},
+					},
+				},
+				"users": TableRule{
+					Read:  []string{"editor", "admin"},
+					Write: []string{"admin"},
+					Columns: map[string]ColumnRule{
+						"password": ColumnRule{Read: []string{"admin"}, Write: []string{"admin"}},
+					},
+				},
+			}}),
+		)
+
+		s.run(t, []Case{
+			Case{
+				Path:   "/",
+				Result: CR{"response": CR{"tables": []string{"items"}}},
+			},
+			Case{
+				Path:   "/users",
+				Status: http.StatusForbidden,
+				Result: CR{"error": "forbidden", "code": "forbidden"},
+			},
+			Case{
+				Path:   "/items/",
+				Method: http.MethodPut,
+				Status: http.StatusForbidden,
+				Body:   CR{"title": "anonymous", "description": ""},
+				Result: CR{"error": "forbidden", "code": "forbidden"},
+			},
+		})
+
+		s.as(t, "X-API-Key", "editor-key").run(t, []Case{
+			Case{
+				Path:   "/",
+				Result: CR{"response": CR{"tables": []string{"items", "users"}}},
+			},
+			Case{
+				Path: "/users/1",
+				Result: CR{
+					"response": CR{
+						"record": CR{
+							"user_id": 1,
+							"login":   "rvasily",
+							"email":   "rvasily@example.com",
+							"info":    "none",
+							"updated": nil,
+						},
+					},
+				},
+			},
+			Case{
+				Path:   "/users",
+				Query:  "where[password]=love",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "unknown column password", "code": "bad_request"},
+			},
+			Case{
+				Path:   "/users/1",
+				Method: http.MethodPost,
+				Status: http.StatusForbidden,
+				Body:   CR{"info": "hacked"},
+				Result: CR{"error": "forbidden", "code": "forbidden"},
+			},
+			Case{
+				Path:   "/items/",
+				Method: http.MethodPut,
+				Status: http.StatusForbidden,
+				Body:   CR{"title": "editor", "description": "", "updated": "bob"},
+				Result: CR{"error": "field updated is read-only", "code": "forbidden", "field": "updated"},
+			},
+			Case{
+				Path:   "/items/",
+				Method: http.MethodPut,
+				Body:   CR{"title": "editor", "description": ""},
+				Result: CR{"response": CR{"id": 3}},
+			},
+			Case{
+				Path:   "/_batch",
+				Method: http.MethodPost,
+				Status: http.StatusForbidden,
+				Body: []CR{
+					CR{"op": "update", "table": "users", "key": 1, "record": CR{"info": "hacked"}},
+				},
+				Result: CR{"error": "operation 0: forbidden", "code": "forbidden"},
+			},
+		})
+
+		admin := "Bearer " + NewHMACToken(secret, Principal{Name: "root", Roles: []string{"admin"}}, now.Add(time.Hour))
+		s.as(t, "Authorization", admin).run(t, []Case{
+			Case{
+				Path:  "/users",
+				Query: "fields=login,password",
+				Result: CR{
+					"response": CR{
+						"records": []CR{CR{"login": "rvasily", "password": "love"}},
+					},
+				},
+			},
+		})
+
+		for _, auth := range []struct{ key, value string }{
+			{"X-API-Key", "wrong-key"},
+			{"Authorization", "Bearer " + NewHMACToken([]byte("other"), Principal{Name: "root"}, now.Add(time.Hour))},
+			{"Authorization", "Bearer " + NewHMACToken(secret, Principal{Name: "root"}, now.Add(-time.Second))},
+		} {
+			s.as(t, auth.key, auth.value).run(t, []Case{
+				Case{
+					Path:   "/items",
+					Status: http.StatusUnauthorized,
+					Result: CR{"error": "unauthorized", "code": "unauthorized"},
+				},
+			})
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type (
+	// testBackend is a database the feature tests run against. Their setup
+	// is written for SQLite, ddl turns it into the syntax of the backend.
+	testBackend struct {
+		name    string
+		dialect Dialect
+		open    func(t testing.TB) *sql.DB
+		ddl     func(q string) string
+	}
+
+	// testServer serves a backend seeded with the sample tables
+	testServer struct {
+		*httptest.Server
+		db      *sql.DB
+		handler *DbExplorer
+	}
+)
+
+var testBackends = []testBackend{
+	{name: "sqlite", dialect: sqliteDialect{}, open: PrepareSQLiteTestApis, ddl: func(q string) string { return q }},
+	{name: "fakedb", dialect: mysqlDialect{}, open: prepareFakeTestApis, ddl: mysqlDDL},
+	{name: "mysql", dialect: mysqlDialect{}, open: prepareMySQLTestApis, ddl: mysqlDDL},
+}
+
+// prepareFakeTestApis opens a fakedb of its own with the sample tables
+func prepareFakeTestApis(t testing.TB) *sql.DB {
+	fakeDatabases.Lock()
+	delete(fakeDatabases.m, t.Name())
+	fakeDatabases.Unlock()
+	db, err := sql.Open("fakedb", t.Name()+"?seed=docker-entrypoint-initdb.d/sample_db.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	if err = db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		fakeDatabases.Lock()
+		delete(fakeDatabases.m, t.Name())
+		fakeDatabases.Unlock()
+	})
+	return db
+}
+
+// prepareMySQLTestApis creates a database of its own with the sample tables
+// on the MySQL server of MYSQL_DSN, the test is skipped without it
+func prepareMySQLTestApis(t testing.TB) *sql.DB {
+	dsn := os.Getenv("MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("MYSQL_DSN is not set")
+	}
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// test names are too long and too odd for database names
+	h := fnv.New64a()
+	h.Write([]byte(t.Name()))
+	name := fmt.Sprintf("db_explorer_%016x", h.Sum64())
+	for _, q := range []string{"DROP DATABASE IF EXISTS `" + name + "`", "CREATE DATABASE `" + name + "` DEFAULT CHARSET utf8"} {
+		if _, err = server.Exec(q); err != nil {
+			server.Close()
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		server.Exec("DROP DATABASE IF EXISTS `" + name + "`")
+		server.Close()
+	})
+
+	cfg.DBName = name
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	PrepareTestApis(db)
+	return db
+}
+
+// mysqlDDL rewrites the SQLite spelling of auto increment
+func mysqlDDL(q string) string {
+	return strings.ReplaceAll(q, "AUTOINCREMENT", "AUTO_INCREMENT")
+}
+
+// forEachBackend runs the test once for every backend
+func forEachBackend(t *testing.T, test func(t *testing.T, b testBackend)) {
+	for _, b := range testBackends {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			test(t, b)
+		})
+	}
+}
+
+// prepare opens the sample database and runs setup on it
+func (b testBackend) prepare(t *testing.T, setup ...string) *sql.DB {
+	t.Helper()
+	db := b.open(t)
+	t.Cleanup(func() { db.Close() })
+	for _, q := range setup {
+		if _, err := db.Exec(b.ddl(q)); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	return db
+}
+
+// serve prepares the database and serves it with opts
+func (b testBackend) serve(t *testing.T, setup []string, opts ...Option) *testServer {
+	t.Helper()
+	return b.serveDB(t, b.prepare(t, setup...), opts...)
+}
+
+// serveDB serves a database prepared by the test
+func (b testBackend) serveDB(t *testing.T, db *sql.DB, opts ...Option) *testServer {
+	t.Helper()
+	handler, err := NewDbExplorer(db, append([]Option{WithDialect(b.dialect)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		ts.Close()
+		handler.Close()
+	})
+	return &testServer{Server: ts, db: db, handler: handler}
+}
+
+// run runs the cases against the server
+func (s *testServer) run(t *testing.T, cases []Case) {
+	t.Helper()
+	runCases(t, s.Server, s.db, cases)
+}
+
+// as serves the same handler on behalf of the client with the header
+func (s *testServer) as(t *testing.T, key, value string) *testServer {
+	ts := httptest.NewServer(withHeader(s.handler, key, value))
+	t.Cleanup(ts.Close)
+	return &testServer{Server: ts, db: s.db, handler: s.handler}
+}
+
+// replicaTB names the replica database after the test it belongs to
+type replicaTB struct {
+	testing.TB
+}
+
+func (r replicaTB) Name() string {
+	return r.TB.Name() + "/replica"
+}
+
+// replica opens a second sample database that stands for a replica
+func (b testBackend) replica(t *testing.T) *sql.DB {
+	db := b.open(replicaTB{t})
+	t.Cleanup(func() { db.Close() })
+	return db
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const batchPath = "_batch"
+
+type batchOperation struct {
+	Op     string                 `json:"op"`
+	Table  string                 `json:"table"`
+	Key    interface{}            `json:"key"`
+	Record map[string]interface{} `json:"record"`
+
+	key    []interface{}
+	record map[string]interface{}
+}
+
+// putRecords inserts an array of records from PUT /$table in a single
+// transaction, nothing is inserted if any of them is invalid
+func (d *DbExplorer) putRecords(w http.ResponseWriter, p Principal, table string, list []interface{}) (err error) {
+	tab := d.columns[table]
+	records := make([]map[string]interface{}, len(list))
+	for i, item := range list {
+		rawRecord, ok := item.(map[string]interface{})
+		if !ok {
+			return writeError(w, fmt.Errorf("record %d: %w", i, errorObjectExpected))
+		}
+		records[i], err = d.createRecord(p, table, rawRecord)
+		if err != nil {
+			return writeError(w, fmt.Errorf("record %d: %w", i, err))
+		}
+	}
+
+	tx, err := d.begin()
+	if err != nil {
+		return errorInternal
+	}
+	defer tx.Rollback()
+
+	keys := make([]map[string]interface{}, len(records))
+	for i, record := range records {
+		lastId, err := d.insertRow(tx, table, record)
+		if err != nil {
+			return d.dbError(err)
+		}
+		keys[i] = tab.insertedKey(record, lastId)
+	}
+	if err = tx.Commit(); err != nil {
+		return errorInternal
+	}
+
+	writeResponse(w, finalResponse{Response: map[string]interface{}{"inserted": len(keys), "keys": keys}})
+	return
+}
+
+// batchKey accepts the key in the path form ("12,7"), as a number or as an
+// object with primary key columns
+func (d *DbExplorer) batchKey(tab Table, raw interface{}) ([]interface{}, error) {
+	var parts []string
+	switch raw := raw.(type) {
+	case string:
+		parts = strings.Split(raw, ",")
+	case map[string]interface{}:
+		for _, name := range tab.PK {
+			v, ok := raw[name]
+			if !ok || v == nil {
+				return nil, errorInvalidKey
+			}
+			parts = append(parts, fmt.Sprint(v))
+		}
+	case nil:
+		return nil, errorInvalidKey
+	default:
+		parts = []string{fmt.Sprint(raw)}
+	}
+	return d.parseKeyParts(tab, parts)
+}
+
+func (d *DbExplorer) prepareOperation(p Principal, op *batchOperation) (err error) {
+	tab, ok := d.columns[op.Table]
+	if !ok {
+		return badRequest("unknown table")
+	}
+	if len(tab.PK) == 0 {
+		return errorReadOnlyTable
+	}
+	if !d.policy.canWrite(p, op.Table) {
+		return errorForbidden
+	}
+	switch op.Op {
+	case "create":
+		op.record, err = d.createRecord(p, op.Table, op.Record)
+		return err
+	case "update":
+		for _, c := range tab.pkColumns() {
+			if _, ok := op.Record[c.Name]; ok {
+				return fieldError(c, errorInvalidType)
+			}
+		}
+		if op.key, err = d.batchKey(tab, op.Key); err != nil {
+			return err
+		}
+		if op.record, err = d.createRecord(p, op.Table, op.Record); err != nil {
+			return err
+		}
+		if !tab.setsColumns(op.record) {
+			return errorNothingToUpdate
+		}
+		return nil
+	case "delete":
+		op.key, err = d.batchKey(tab, op.Key)
+		return err
+	}
+	return badRequest("unknown operation %s", op.Op)
+}
+
+// postBatch runs create, update and delete operations across tables in one
+// transaction: either all of them are committed or none
+func (d *DbExplorer) postBatch(w http.ResponseWriter, r *http.Request) (err error) {
+	defer r.Body.Close()
+	bs, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorInternal
+	}
+	var ops []batchOperation
+	if err = decodeJSON(bs, &ops); err != nil {
+		return writeError(w, &apiError{status: http.StatusBadRequest, code: CodeInvalidJSON, msg: "a list of operations expected"})
+	}
+	p := principalFrom(r)
+	for i := range ops {
+		if err = d.prepareOperation(p, &ops[i]); err != nil {
+			return writeError(w, fmt.Errorf("operation %d: %w", i, err))
+		}
+	}
+	writes := make(map[string]int)
+	for _, op := range ops {
+		writes[op.Table]++
+	}
+	for table, n := range writes {
+		if err = d.checkWriteQuota(w, r, table, n); err != nil {
+			return err
+		}
+	}
+
+	tx, err := d.begin()
+	if err != nil {
+		return errorInternal
+	}
+	defer tx.Rollback()
+
+	results := make([]map[string]interface{}, len(ops))
+	for i, op := range ops {
+		switch op.Op {
+		case "create":
+			lastId, err := d.insertRow(tx, op.Table, op.record)
+			if err != nil {
+				return d.dbError(err)
+			}
+			results[i] = d.columns[op.Table].insertedKey(op.record, lastId)
+		case "update":
+			updated, err := d.updateRow(tx, op.Table, op.key, op.record)
+			if err != nil {
+				return d.dbError(err)
+			}
+			results[i] = map[string]interface{}{"updated": updated}
+		case "delete":
+			deleted, err := d.deleteRow(tx, op.Table, op.key)
+			if err != nil {
+				return d.dbError(err)
+			}
+			results[i] = map[string]interface{}{"deleted": deleted}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errorInternal
+	}
+
+	writeResponse(w, finalResponse{Response: map[string]interface{}{"results": results}})
+	return
+}
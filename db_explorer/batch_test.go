@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, []string{
+			`CREATE TABLE tags (id INTEGER PRIMARY KEY AUTOINCREMENT, name varchar(255) NOT NULL);`,
+			`CREATE UNIQUE INDEX tags_name ON tags (name);`,
+			`INSERT INTO tags (name) VALUES ('go');`,
+		})
+
+		s.run(t, []Case{
+			Case{
+				Path:   "/items/",
+				Method: http.MethodPut,
+				Body: []CR{
+					CR{"title": "bulk 1", "description": ""},
+					CR{"title": "bulk 2", "description": "", "updated": "autotests"},
+				},
+				Result: CR{
+					"response": CR{
+						"inserted": 2,
+						"keys":     []CR{CR{"id": 3}, CR{"id": 4}},
+					},
+				},
+			},
+			// an invalid record rolls back the whole array
+			Case{
+				Path:   "/items/",
+				Method: http.MethodPut,
+				Status: http.StatusBadRequest,
+				Body: []CR{
+					CR{"title": "bulk 3", "description": ""},
+					CR{"title": 42},
+				},
+				Result: CR{"error": "record 1: field title have invalid type", "code": "invalid_type", "field": "title"},
+			},
+			Case{
+				Path:  "/items",
+				Query: "fields=id&where[title][like]=bulk%25",
+				Result: CR{
+					"response": CR{
+						"records": []CR{CR{"id": 3}, CR{"id": 4}},
+					},
+				},
+			},
+			Case{
+				Path:   "/_batch",
+				Method: http.MethodPost,
+				Body: []CR{
+					CR{"op": "create", "table": "users", "record": CR{"login": "batch"}},
+					CR{"op": "update", "table": "items", "key": "3", "record": CR{"description": "batched"}},
+					CR{"op": "update", "table": "items", "key": CR{"id": 100500}, "record": CR{"description": "batched"}},
+					CR{"op": "delete", "table": "items", "key": 4},
+				},
+				Result: CR{
+					"response": CR{
+						"results": []CR{
+							CR{"user_id": 2},
+							CR{"updated": 1},
+							CR{"updated": 0},
+							CR{"deleted": 1},
+						},
+					},
+				},
+			},
+			Case{
+				Path:   "/_batch",
+				Method: http.MethodPost,
+				Status: http.StatusBadRequest,
+				Body: []CR{
+					CR{"op": "delete", "table": "items", "key": 3},
+					CR{"op": "update", "table": "items", "key": 1, "record": CR{"id": 5}},
+				},
+				Result: CR{"error": "operation 1: field id have invalid type", "code": "invalid_type", "field": "id"},
+			},
+			Case{
+				Path:   "/_batch",
+				Method: http.MethodPost,
+				Status: http.StatusBadRequest,
+				Body: []CR{
+					CR{"op": "truncate", "table": "items"},
+				},
+				Result: CR{"error": "operation 0: unknown operation truncate", "code": "bad_request"},
+			},
+			Case{
+				Path:   "/_batch",
+				Method: http.MethodPost,
+				Status: http.StatusBadRequest,
+				Body: []CR{
+					CR{"op": "delete", "table": "nope", "key": 1},
+				},
+				Result: CR{"error": "operation 0: unknown table", "code": "bad_request"},
+			},
+		})
+
+		// a database error in the middle rolls back earlier operations
+		body := `[{"op": "delete", "table": "items", "key": 3}, {"op": "create", "table": "tags", "record": {"name": "go"}}]`
+		resp, err := client.Post(s.URL+"/_batch", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusConflict {
+			t.Fatalf("expected http status 409, got %v", resp.StatusCode)
+		}
+
+		s.run(t, []Case{
+			Case{
+				Path:   "/_batch",
+				Method: http.MethodPost,
+				Body:   []CR{CR{"op": "update", "table": "items", "key": 1, "record": CR{"unknown": 1}}},
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "operation 0: nothing to update", "code": "bad_request"},
+			},
+			Case{
+				Path: "/items/3",
+				Result: CR{
+					"response": CR{
+						"record": CR{"id": 3, "title": "bulk 1", "description": "batched", "updated": nil},
+					},
+				},
+			},
+		})
+	})
+}
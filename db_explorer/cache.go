@@ -0,0 +1,245 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cachePath is the admin endpoint with the counters of the cache: GET /_cache
+const cachePath = "_cache"
+
+type (
+	cacheEntry struct {
+		key, table string
+		records    []map[string]interface{}
+		expires    time.Time
+	}
+
+	// queryCache keeps the records read by GET /$table and GET /$table/$id,
+	// the least recently used entry goes first when it is full. Reads get a
+	// ticket before they query, an invalidation of the table after that
+	// keeps them from storing what they read.
+	queryCache struct {
+		mu      sync.Mutex
+		size    int
+		ttl     time.Duration
+		lru     *list.List
+		entries map[string]*list.Element
+		byTable map[string]map[*list.Element]struct{}
+		// clock goes up with every invalidation, invalidated and purged
+		// hold the time of the last one of a table and of all of them
+		clock       uint64
+		invalidated map[string]uint64
+		purged      uint64
+
+		hits, misses uint64
+	}
+
+	// CacheStats are the counters of the cache set up by WithCache
+	CacheStats struct {
+		Hits    uint64 `json:"hits"`
+		Misses  uint64 `json:"misses"`
+		Entries int    `json:"entries"`
+		Size    int    `json:"size"`
+	}
+)
+
+// WithCache keeps the records of up to size list and record reads for ttl,
+// no expiry if ttl is 0. Writes through DbExplorer drop what was read from
+// the tables they change and from the tables referencing those. Changes
+// made around DbExplorer show up once entries expire, or when polled with
+// WithChangePolling. With WithReplicas only reads from the primary are
+// kept, and reads after a recent write skip the cache.
+func WithCache(size int, ttl time.Duration) Option {
+	return func(d *DbExplorer) {
+		d.cache = nil
+		if size > 0 {
+			d.cache = &queryCache{
+				size:        size,
+				ttl:         ttl,
+				lru:         list.New(),
+				entries:     make(map[string]*list.Element),
+				byTable:     make(map[string]map[*list.Element]struct{}),
+				invalidated: make(map[string]uint64),
+			}
+		}
+	}
+}
+
+func cacheKey(table, query string, args []interface{}) string {
+	var b strings.Builder
+	b.WriteString(table)
+	b.WriteByte(0)
+	b.WriteString(query)
+	for _, a := range args {
+		fmt.Fprintf(&b, "\x00%T:%v", a, a)
+	}
+	return b.String()
+}
+
+// copyRecords copies the records so that callers can change them
+func copyRecords(records []map[string]interface{}) []map[string]interface{} {
+	copied := make([]map[string]interface{}, len(records))
+	for i, r := range records {
+		copied[i] = make(map[string]interface{}, len(r))
+		for k, v := range r {
+			copied[i][k] = v
+		}
+	}
+	return copied
+}
+
+// ticket returns what get returns on a miss, for reads that skip the entries
+func (c *queryCache) ticket() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clock
+}
+
+// get returns the cached records or, on a miss, the ticket to put them
+func (c *queryCache) get(key string) (records []map[string]interface{}, ticket uint64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, found := c.entries[key]; found {
+		e := el.Value.(*cacheEntry)
+		if c.ttl <= 0 || time.Now().Before(e.expires) {
+			c.lru.MoveToFront(el)
+			c.hits++
+			return copyRecords(e.records), 0, true
+		}
+		c.remove(el)
+	}
+	c.misses++
+	return nil, c.clock, false
+}
+
+func (c *queryCache) put(table, key string, ticket uint64, records []map[string]interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.invalidated[table] > ticket || c.purged > ticket {
+		return
+	}
+	if el, found := c.entries[key]; found {
+		c.remove(el)
+	}
+	el := c.lru.PushFront(&cacheEntry{key: key, table: table, records: copyRecords(records), expires: time.Now().Add(c.ttl)})
+	c.entries[key] = el
+	if c.byTable[table] == nil {
+		c.byTable[table] = make(map[*list.Element]struct{})
+	}
+	c.byTable[table][el] = struct{}{}
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *queryCache) remove(el *list.Element) {
+	e := el.Value.(*cacheEntry)
+	c.lru.Remove(el)
+	delete(c.entries, e.key)
+	delete(c.byTable[e.table], el)
+}
+
+// invalidate drops the entries of the tables
+func (c *queryCache) invalidate(tables ...string) {
+	if c == nil || len(tables) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock++
+	for _, table := range tables {
+		c.invalidated[table] = c.clock
+		for el := range c.byTable[table] {
+			c.remove(el)
+		}
+	}
+}
+
+// purge drops every entry, the schema they were read with is gone
+func (c *queryCache) purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock++
+	c.purged = c.clock
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.byTable = make(map[string]map[*list.Element]struct{})
+}
+
+func (c *queryCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Entries: c.lru.Len(), Size: c.size}
+}
+
+// CacheStats returns the counters of the cache, zero without WithCache
+func (d *DbExplorer) CacheStats() CacheStats {
+	if d.cache == nil {
+		return CacheStats{}
+	}
+	return d.cache.stats()
+}
+
+// affectedTables returns the table and the tables referencing it, directly
+// or through others, which cascading foreign keys may change with it
+func (d *DbExplorer) affectedTables(table string) []string {
+	affected := []string{table}
+	seen := map[string]bool{table: true}
+	for i := 0; i < len(affected); i++ {
+		for _, name := range d.tables {
+			for _, fk := range d.columns[name].ForeignKeys {
+				if fk.RefTable == affected[i] && !seen[name] {
+					seen[name] = true
+					affected = append(affected, name)
+				}
+			}
+		}
+	}
+	return affected
+}
+
+// cachedSelect reads records of the table through the cache
+func (d *DbExplorer) cachedSelect(table string, columns []Col, q string, args []interface{}) ([]map[string]interface{}, error) {
+	if d.cache == nil {
+		return d.selectRows(d.reader(), columns, q, args)
+	}
+	key := cacheKey(table, q, args)
+	// a client that has just written may have done so through another
+	// DbExplorer, whose writes didn't invalidate the entries here
+	ticket := d.cache.ticket()
+	if !d.primaryReads {
+		records, t, ok := d.cache.get(key)
+		if ok {
+			return records, nil
+		}
+		ticket = t
+	}
+	records, err := d.selectRows(d.reader(), columns, q, args)
+	// a lagging replica may return what an invalidation has just dropped
+	if err == nil && d.readsPrimary() {
+		d.cache.put(table, key, ticket, records)
+	}
+	return records, err
+}
+
+func (d *DbExplorer) getCache(w http.ResponseWriter, r *http.Request) (err error) {
+	if d.cache == nil {
+		return errorUnknownPath
+	}
+	if !d.policy.canAdmin(principalFrom(r)) {
+		return writeForbidden(w)
+	}
+	s := d.cache.stats()
+	writeResponse(w, finalResponse{Response: map[string]interface{}{
+		"hits": s.Hits, "misses": s.Misses, "entries": s.Entries, "size": s.Size,
+	}})
+	return
+}
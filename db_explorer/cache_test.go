@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueryCache(t *testing.T) {
+	d := &DbExplorer{}
+	WithCache(2, time.Minute)(d)
+	c := d.cache
+	record := func(v string) []map[string]interface{} {
+		return []map[string]interface{}{{"title": v}}
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		_, ticket, _ := c.get(key)
+		c.put("items", key, ticket, record(key))
+	}
+	if _, _, ok := c.get("a"); ok {
+		t.Errorf("the least recently used entry must be evicted")
+	}
+	got, _, ok := c.get("c")
+	if !ok || got[0]["title"] != "c" {
+		t.Fatalf("expected c to be cached, got %v", got)
+	}
+	got[0]["title"] = "changed"
+	if again, _, _ := c.get("c"); again[0]["title"] != "c" {
+		t.Errorf("callers must not change cached records, got %v", again)
+	}
+
+	// a read that started before an invalidation does not store what it read
+	_, ticket, _ := c.get("stale")
+	c.invalidate("items")
+	c.put("items", "stale", ticket, record("stale"))
+	if _, _, ok := c.get("stale"); ok {
+		t.Errorf("a read racing with a write must not be cached")
+	}
+	if _, _, ok := c.get("b"); ok {
+		t.Errorf("invalidate must drop the entries of the table")
+	}
+
+	WithCache(10, time.Millisecond)(d)
+	_, ticket, _ = d.cache.get("a")
+	d.cache.put("items", "a", ticket, record("a"))
+	time.Sleep(5 * time.Millisecond)
+	if _, _, ok := d.cache.get("a"); ok {
+		t.Errorf("entries must expire after ttl")
+	}
+}
+
+func TestCache(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		ts := b.serve(t, nil, WithCache(100, time.Minute))
+
+		title := func() interface{} {
+			_, result := doWithHeaders(t, http.MethodGet, ts.URL+"/items/1", nil, nil)
+			return result["response"].(map[string]interface{})["record"].(map[string]interface{})["title"]
+		}
+		listed := func() interface{} {
+			_, result := doWithHeaders(t, http.MethodGet, ts.URL+"/items?limit=1", nil, nil)
+			records := result["response"].(map[string]interface{})["records"].([]interface{})
+			return records[0].(map[string]interface{})["title"]
+		}
+
+		title()
+		listed()
+		// changes made around DbExplorer are not seen until the entry goes
+		if _, err := ts.db.Exec(`UPDATE items SET title = 'around' WHERE id = 1`); err != nil {
+			t.Fatal(err)
+		}
+		if got := title(); got != "database/sql" {
+			t.Errorf("expected the cached record, got %v", got)
+		}
+		if got := listed(); got != "database/sql" {
+			t.Errorf("expected the cached list, got %v", got)
+		}
+
+		// writes through DbExplorer invalidate the table
+		doWithHeaders(t, http.MethodPost, ts.URL+"/items/1", nil, CR{"title": "through"})
+		if got := title(); got != "through" {
+			t.Errorf("an update must invalidate the record, got %v", got)
+		}
+		if got := listed(); got != "through" {
+			t.Errorf("an update must invalidate lists, got %v", got)
+		}
+
+		resp, result := doWithHeaders(t, http.MethodGet, ts.URL+"/_cache", nil, nil)
+		expected := CR{"hits": 2, "misses": 4, "entries": 2, "size": 100}
+		if resp.StatusCode != http.StatusOK || !reflect.DeepEqual(jsonRoundTrip(result["response"]), jsonRoundTrip(expected)) {
+			t.Errorf("expected counters %v, got %d %v", expected, resp.StatusCode, result)
+		}
+		if s := ts.handler.CacheStats(); s.Hits != 2 || s.Misses != 4 {
+			t.Errorf("unexpected stats %+v", s)
+		}
+	})
+}
+
+func TestCacheReplicas(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		replica := b.replica(t)
+		// the replica lags behind, the primary has already changed the title
+		db := b.prepare(t, `UPDATE items SET title = 'primary' WHERE id = 1`)
+		ts := b.serveDB(t, db, WithReplicas(replica), WithReplicaLag(time.Minute), WithCache(100, 0))
+
+		title := func(headers map[string]string) interface{} {
+			_, result := doWithHeaders(t, http.MethodGet, ts.URL+"/items/1", headers, nil)
+			return result["response"].(map[string]interface{})["record"].(map[string]interface{})["title"]
+		}
+		write := func(title string) map[string]string {
+			resp, _ := doWithHeaders(t, http.MethodPost, ts.URL+"/items/1", nil, CR{"title": title})
+			return map[string]string{lastWriteHeader: resp.Header.Get(lastWriteHeader)}
+		}
+
+		title(nil)
+		if got := title(nil); got != "database/sql" {
+			t.Errorf("expected the replica, got %v", got)
+		}
+		if s := ts.handler.CacheStats(); s.Entries != 0 {
+			t.Errorf("reads from a replica must not be cached, got %+v", s)
+		}
+
+		recent := write("written")
+		if got := title(recent); got != "written" {
+			t.Errorf("read after a write: expected written, got %v", got)
+		}
+		// the replica still has the old title, the primary read filled the cache
+		if got := title(nil); got != "written" {
+			t.Errorf("expected the record read from the primary, got %v", got)
+		}
+
+		recent = write("rewritten")
+		if got := title(nil); got != "database/sql" {
+			t.Errorf("expected the lagging replica after the invalidation, got %v", got)
+		}
+		if got := title(recent); got != "rewritten" {
+			t.Errorf("the lagging replica must not fill the cache, got %v", got)
+		}
+		if s := ts.handler.CacheStats(); s.Hits != 1 {
+			t.Errorf("expected a single hit, got %+v", s)
+		}
+	})
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	changesPath = "_changes"
+	// changeHistory is the number of recent changes kept to resume feeds
+	changeHistory = 1000
+	// changeBuffer is the number of changes a slow subscriber may lag behind
+	// before it is disconnected
+	changeBuffer = 256
+	// ChangePolled is the operation of changes found by WithChangePolling,
+	// which can't tell inserts from updates
+	ChangePolled = "change"
+	// changesKeepAlive is how often an idle feed sends a comment, so that
+	// proxies don't close the connection
+	changesKeepAlive = 15 * time.Second
+)
+
+type (
+	changeEvent struct {
+		seq   int64
+		Table string `json:"table"`
+		Op    string `json:"op"`
+		PK    string `json:"pk"`
+	}
+
+	// changeFeed fans changes out to the subscribers of GET /_changes.
+	// Event ids are <epoch>-<seq>, the epoch changes with every start so a
+	// client resuming with an id of another run is told to reload.
+	changeFeed struct {
+		mu          sync.Mutex
+		epoch       string
+		seq         int64
+		history     []changeEvent
+		subscribers map[chan changeEvent]struct{}
+	}
+
+	// txn is a transaction that publishes its changes, invalidates the
+	// cache of the tables it changed and appends to the audit file once it
+	// is committed
+	txn struct {
+		*sql.Tx
+		feed      *changeFeed
+		changes   []changeEvent
+		cache     *queryCache
+		tables    []string
+		auditFile *auditFile
+		audited   [][]byte
+		metrics   *metrics
+		ctx       *requestContext
+	}
+
+	changePolling struct {
+		column   string
+		interval time.Duration
+	}
+)
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		subscribers: make(map[chan changeEvent]struct{}),
+	}
+}
+
+func (f *changeFeed) id(seq int64) string {
+	return f.epoch + "-" + strconv.FormatInt(seq, 10)
+}
+
+func (f *changeFeed) publish(events ...changeEvent) {
+	if f == nil || len(events) == 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range events {
+		f.seq++
+		e.seq = f.seq
+		if len(f.history) == changeHistory {
+			f.history = append(f.history[:0], f.history[1:]...)
+		}
+		f.history = append(f.history, e)
+		for ch := range f.subscribers {
+			select {
+			case ch <- e:
+			default:
+				// the subscriber can't keep up, it has to resume from its last id
+				delete(f.subscribers, ch)
+				close(ch)
+			}
+		}
+	}
+}
+
+// subscribe returns the changes after lastID and a channel with the ones to
+// come. Reset is true when the changes after lastID are no longer known.
+func (f *changeFeed) subscribe(lastID string) (ch chan changeEvent, backlog []changeEvent, reset bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch = make(chan changeEvent, changeBuffer)
+	f.subscribers[ch] = struct{}{}
+	if lastID == "" {
+		return ch, nil, false
+	}
+	epoch, seqString, _ := strings.Cut(lastID, "-")
+	seq, err := strconv.ParseInt(seqString, 10, 64)
+	if err != nil || epoch != f.epoch || seq > f.seq {
+		return ch, nil, true
+	}
+	if len(f.history) > 0 && f.history[0].seq > seq+1 {
+		reset = true
+	}
+	for _, e := range f.history {
+		if e.seq > seq {
+			backlog = append(backlog, e)
+		}
+	}
+	return ch, backlog, reset
+}
+
+func (f *changeFeed) unsubscribe(ch chan changeEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subscribers[ch]; ok {
+		delete(f.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (d *DbExplorer) begin() (*txn, error) {
+	tx, err := d.db.BeginTx(d.ctx.request(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &txn{Tx: tx, feed: d.feed, cache: d.cache, metrics: d.metrics, ctx: d.ctx}, nil
+}
+
+func (t *txn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer t.metrics.since("query", time.Now())
+	return t.Tx.QueryContext(t.ctx.statement(), query, args...)
+}
+
+func (t *txn) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer t.metrics.since("query", time.Now())
+	return t.Tx.QueryRowContext(t.ctx.statement(), query, args...)
+}
+
+func (t *txn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer t.metrics.since("exec", time.Now())
+	return t.Tx.ExecContext(t.ctx.statement(), query, args...)
+}
+
+func (t *txn) Commit() error {
+	if err := t.Tx.Commit(); err != nil {
+		return err
+	}
+	t.cache.invalidate(t.tables...)
+	t.feed.publish(t.changes...)
+	if t.auditFile != nil {
+		return t.auditFile.append(t.audited...)
+	}
+	return nil
+}
+
+// recordChange writes the mutation to the audit log and queues it for the
+// change feed and the cache, which get it when the transaction is committed
+func (d *DbExplorer) recordChange(ex execer, op, table string, key []interface{}, before, after map[string]interface{}) error {
+	e := changeEvent{Table: table, Op: op, PK: keyString(key)}
+	if t, ok := ex.(*txn); ok {
+		t.changes = append(t.changes, e)
+		t.tables = append(t.tables, d.affectedTables(table)...)
+	} else {
+		d.cache.invalidate(d.affectedTables(table)...)
+		d.feed.publish(e)
+	}
+	return d.writeAudit(ex, op, table, key, before, after)
+}
+
+// WithChangePolling also feeds changes made around DbExplorer: every
+// interval the tables having the column, a timestamp or a number that goes
+// up with every change of a row, are checked for rows where it went up.
+// Changes made through DbExplorer that touch the column are reported twice.
+func WithChangePolling(column string, interval time.Duration) Option {
+	return func(d *DbExplorer) {
+		d.polling = changePolling{column: column, interval: interval}
+	}
+}
+
+func (d *DbExplorer) pollChanges() {
+	ticker := time.NewTicker(d.polling.interval)
+	defer ticker.Stop()
+	// a scan still running when DbExplorer is closed is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-d.state.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	// marks keep the highest value of the column seen in every table
+	marks := make(map[string]interface{})
+	d.scanChanges(ctx, marks)
+	for {
+		select {
+		case <-ticker.C:
+			d.scanChanges(ctx, marks)
+		case <-d.state.done:
+			return
+		}
+	}
+}
+
+// scanChanges polls every table once, its statements run on the primary
+// with the statement timeout as those of requests do
+func (d *DbExplorer) scanChanges(ctx context.Context, marks map[string]interface{}) {
+	s := d.snapshot()
+	s.ctx = &requestContext{ctx: ctx, timeout: d.timeout}
+	defer s.ctx.release()
+	for _, table := range s.tables {
+		tab := s.columns[table]
+		c, ok := tab.column(d.polling.column)
+		if !ok || len(tab.PK) == 0 {
+			continue
+		}
+		mark, seen := marks[table]
+		if !seen {
+			// changes are reported from the moment the table is first seen
+			if mark, err := s.maxMark(table, c); err == nil {
+				marks[table] = mark
+			}
+			continue
+		}
+		if mark, err := s.pollTable(tab, table, c, mark); err == nil {
+			marks[table] = mark
+		}
+	}
+}
+
+// maxMark returns the highest value of the column in the table, the raw
+// driver value as pollTable compares it
+func (d *DbExplorer) maxMark(table string, c Col) (mark interface{}, err error) {
+	rows, err := d.primary().Query(fmt.Sprintf("SELECT MAX(%s) FROM %s", d.quote(c.Name), d.quote(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		err = rows.Scan(&mark)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return mark, rows.Err()
+}
+
+// pollTable publishes the rows where the column went past the mark and
+// returns the new mark, the raw driver value so it compares as stored
+func (d *DbExplorer) pollTable(tab Table, table string, c Col, mark interface{}) (interface{}, error) {
+	pks := tab.pkColumns()
+	args := &sqlArgs{dialect: d.dialect}
+	cond := d.quote(c.Name) + " IS NOT NULL"
+	if mark != nil {
+		cond = fmt.Sprintf("%s > %s", d.quote(c.Name), args.add(mark))
+	}
+	q := fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s ORDER BY %s",
+		d.columnsSQL(pks), d.quote(c.Name), d.quote(table), cond, d.quote(c.Name))
+	rows, err := d.primary().Query(q, args.args...)
+	if err != nil {
+		return mark, err
+	}
+	defer rows.Close()
+
+	stubs := make([]interface{}, len(pks)+1)
+	ptrs := make([]interface{}, len(stubs))
+	for i := range stubs {
+		ptrs[i] = &stubs[i]
+	}
+	events := make([]changeEvent, 0)
+	last := mark
+	for rows.Next() {
+		if err = rows.Scan(ptrs...); err != nil {
+			return mark, err
+		}
+		key := make([]interface{}, len(pks))
+		for i, pk := range pks {
+			if key[i], err = d.types.lookup(pk).Decode(pk, stubs[i]); err != nil {
+				return mark, err
+			}
+		}
+		events = append(events, changeEvent{Table: table, Op: ChangePolled, PK: keyString(key)})
+		last = stubs[len(pks)]
+	}
+	if err = rows.Err(); err != nil {
+		return mark, err
+	}
+	if len(events) > 0 {
+		d.cache.invalidate(d.affectedTables(table)...)
+	}
+	d.feed.publish(events...)
+	return last, nil
+}
+
+// getChanges streams changes of the tables as Server-Sent Events. A client
+// resumes with the Last-Event-ID header or the last_event_id parameter, a
+// "reset" event tells it that changes were missed and it has to reload.
+func (d *DbExplorer) getChanges(w http.ResponseWriter, r *http.Request) (err error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errorInternal
+	}
+	p := principalFrom(r)
+	tables := make(map[string]struct{})
+	if list := r.URL.Query().Get("tables"); list != "" {
+		for _, table := range strings.Split(list, ",") {
+			if _, ok := d.columns[table]; !ok {
+				return errorUnknownTable
+			}
+			if !d.policy.canRead(p, table) {
+				return errorForbidden
+			}
+			tables[table] = struct{}{}
+		}
+	} else {
+		for _, table := range d.tables {
+			if d.policy.canRead(p, table) {
+				tables[table] = struct{}{}
+			}
+		}
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+
+	ch, backlog, reset := d.feed.subscribe(lastID)
+	defer d.feed.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if reset {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	send := func(e changeEvent) {
+		if _, ok := tables[e.Table]; !ok {
+			return
+		}
+		bs, _ := json.Marshal(e)
+		fmt.Fprintf(w, "id: %s\nevent: change\ndata: %s\n\n", d.feed.id(e.seq), bs)
+	}
+	for _, e := range backlog {
+		send(e)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(changesKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case e, ok := <-ch:
+			if !ok {
+				fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+				flusher.Flush()
+				return nil
+			}
+			send(e)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
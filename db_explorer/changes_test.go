@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sseEvent struct {
+	id, event string
+	data      CR
+}
+
+// openChanges subscribes to the change feed, events are read until the
+// returned function is called
+func openChanges(t *testing.T, url, lastID string) (<-chan sseEvent, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req = req.WithContext(ctx)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("[GET %s] expected an event stream, got %d %s", url, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		var e sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				e.id = line[4:]
+			case strings.HasPrefix(line, "event: "):
+				e.event = line[7:]
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(line[6:]), &e.data)
+			case line == "" && e.event != "":
+				events <- e
+				e = sseEvent{}
+			}
+		}
+	}()
+	return events, cancel
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+	}
+	return sseEvent{}
+}
+
+func TestChanges(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		ts := b.serve(t, []string{
+			`CREATE TABLE tasks (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title varchar(255) NOT NULL,
+  revision int NOT NULL DEFAULT 0
+);`,
+			`INSERT INTO tasks (title, revision) VALUES ('old', 1);`,
+		}, WithChangePolling("revision", 10*time.Millisecond))
+
+		events, stop := openChanges(t, ts.URL+"/_changes?tables=items,tasks", "")
+		defer stop()
+
+		doWithHeaders(t, http.MethodPut, ts.URL+"/users/", nil, CR{"login": "filtered", "password": "", "email": "", "info": ""})
+		doWithHeaders(t, http.MethodPut, ts.URL+"/items/", nil, CR{"title": "new", "description": ""})
+		doWithHeaders(t, http.MethodPost, ts.URL+"/items/3", nil, CR{"title": "newer"})
+		// a failed transaction publishes nothing
+		doWithHeaders(t, http.MethodPut, ts.URL+"/items/", nil, []CR{CR{"title": "ok", "description": ""}, CR{"title": 1}})
+		doWithHeaders(t, http.MethodDelete, ts.URL+"/items/3", nil, nil)
+
+		expected := []CR{
+			CR{"table": "items", "op": "insert", "pk": "3"},
+			CR{"table": "items", "op": "update", "pk": "3"},
+			CR{"table": "items", "op": "delete", "pk": "3"},
+		}
+		ids := make([]string, 0)
+		for _, want := range expected {
+			e := nextEvent(t, events)
+			if e.event != "change" || e.id == "" || e.data["table"] != want["table"] || e.data["op"] != want["op"] || e.data["pk"] != want["pk"] {
+				t.Errorf("expected %v, got %+v", want, e)
+			}
+			ids = append(ids, e.id)
+		}
+
+		// changes made around DbExplorer are found by polling
+		time.Sleep(30 * time.Millisecond)
+		if _, err := ts.db.Exec(`UPDATE tasks SET title = 'changed', revision = 2 WHERE id = 1`); err != nil {
+			t.Fatal(err)
+		}
+		if e := nextEvent(t, events); e.data["table"] != "tasks" || e.data["op"] != ChangePolled || e.data["pk"] != "1" {
+			t.Errorf("expected the polled change of tasks 1, got %+v", e)
+		}
+
+		// resuming replays what came after the last seen event
+		resumed, stopResumed := openChanges(t, ts.URL+"/_changes?tables=items", ids[0])
+		defer stopResumed()
+		for _, want := range expected[1:] {
+			if e := nextEvent(t, resumed); e.data["op"] != want["op"] {
+				t.Errorf("resumed: expected %v, got %+v", want, e)
+			}
+		}
+
+		reset, stopReset := openChanges(t, ts.URL+"/_changes", "unknown-1")
+		defer stopReset()
+		if e := nextEvent(t, reset); e.event != "reset" {
+			t.Errorf("unknown event id: expected a reset, got %+v", e)
+		}
+
+		resp, result := doWithHeaders(t, http.MethodGet, ts.URL+"/_changes?tables=nope", nil, nil)
+		if resp.StatusCode != http.StatusNotFound || result["code"] != CodeUnknownTable {
+			t.Errorf("unknown table: expected 404, got %d %v", resp.StatusCode, result)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // тут вы пишете код
@@ -28,21 +30,83 @@ type (
 	}
 	Col struct {
 		Name string
+		// Type is the base type name, the key in the type registry
 		Type string
+		// Def is the full lower case definition, e.g. enum('a','b') or bigint(20) unsigned
+		Def  string
 		Null bool
 		PK   bool
 	}
 	Table struct {
-		Columns       []Col
-		PK            string
+		Columns []Col
+		// PK lists the primary key columns in key order, it is empty for
+		// tables without a primary key, which are read-only
+		PK            []string
 		AutoIncrement map[string]struct{}
-		columnString  string
+		ForeignKeys   []ForeignKey
+		// FullText lists the columns of the full-text indexes
+		FullText     [][]string
+		columnString string
+		// softDelete is the column marking soft deleted records, if any
+		softDelete *Col
+	}
+	ForeignKey struct {
+		Name       string   `json:"name"`
+		Table      string   `json:"table"`
+		Columns    []string `json:"columns"`
+		RefTable   string   `json:"ref_table"`
+		RefColumns []string `json:"ref_columns"`
 	}
 	DbExplorer struct {
-		db *sql.DB
+		db      *sql.DB
+		dialect Dialect
+		types   typeRegistry
 		//regexps map[]
+		// tables and columns are the schema snapshot a request works with,
+		// ServeHTTP takes a fresh one from state
 		tables  []string
 		columns map[string]Table
+		state   *schemaState
+		refresh time.Duration
+		// version is the column used as the ETag of records, see WithVersionColumn
+		version string
+		// softDelete is the column name of soft deletes, see WithSoftDeleteColumn
+		softDelete string
+
+		authenticators []Authenticator
+		policy         *Policy
+
+		audit   auditSink
+		feed    *changeFeed
+		polling changePolling
+
+		replicas *replicaSet
+		cache    *queryCache
+
+		// maxLimit, limiter and quotas are set by WithMaxLimit, WithRateLimit
+		// and WithWriteQuota
+		maxLimit int
+		limiter  *rateLimiter
+		quotas   map[string]*rateLimiter
+
+		metrics   *metrics
+		accessLog *accessLog
+		// primaryReads sends the reads of a request to the primary, it is
+		// set on the snapshot for clients that have just written
+		primaryReads bool
+		// actor is the name of the principal of the request on a snapshot
+		actor string
+		// ctx is the context of the request on a snapshot, timeout is set by
+		// WithStatementTimeout
+		ctx     *requestContext
+		timeout time.Duration
+	}
+	Option func(d *DbExplorer)
+	// execer is implemented by both *sql.DB and *sql.Tx
+	execer interface {
+		queryer
+		Exec(query string, args ...interface{}) (sql.Result, error)
+		QueryRow(query string, args ...interface{}) *sql.Row
 	}
 )
 
@@ -50,80 +114,47 @@ var (
 	errorInternal = errors.New("internal error")
 )
 
-func (c *Col) defaultVar() interface{} {
-	switch c.Type {
-	case "varchar", "text":
-		return ""
-	case "int":
-		return 0
-	case "float64", "float":
-		return 0.0
-	}
-	return ""
-}
-
 type finalResponse struct {
 	Error    string                 `json:"error,omitempty"`
+	Code     string                 `json:"code,omitempty"`
+	Field    string                 `json:"field,omitempty"`
 	Response map[string]interface{} `json:"response,omitempty"`
 }
 
 // нужно сохранить TABLES, поля в структуру!
 func (d *DbExplorer) getAllTables() (tables []string, err error) {
-	var rows *sql.Rows
-	rows, err = d.db.Query("SHOW TABLES")
-	if err != nil {
-		return
-	}
-	defer rows.Close()
-	var table string
-	for rows.Next() {
-		err = rows.Scan(&table)
-		if err != nil {
-			return
-		}
-		tables = append(tables, table)
-	}
-	return
+	return d.dialect.Tables(d.primary())
 }
 
 func (d *DbExplorer) getColumns(table string) (tab Table, err error) {
-	var rows *sql.Rows
-	rows, err = d.db.Query("SHOW FULL COLUMNS FROM " + table)
+	tab, err = d.dialect.Columns(d.primary(), table)
 	if err != nil {
-		return
+		return tab, err
 	}
-	defer rows.Close()
-	var col Column
-	tab.Columns = make([]Col, 0)
-	tab.AutoIncrement = make(map[string]struct{})
-	pk := ""
-	for rows.Next() {
-		err = rows.Scan(&col.Field, &col.Type, &col.Collation, &col.Null, &col.Key, &col.Default, &col.Extra, &col.Privileges, &col.Comment)
-		if err != nil {
-			return
-		}
-		c := Col{
-			Name: col.Field,
-			Type: strings.Split(strings.ToLower(col.Type), "(")[0],
-			Null: strings.ToLower(col.Null) == "yes",
-			PK:   strings.ToLower(col.Key) == "pri",
-		}
-		if c.PK {
-			pk = c.Name
-		}
-		tab.Columns = append(tab.Columns, c)
-		if strings.ToLower(col.Extra) == "auto_increment" {
-			tab.AutoIncrement[c.Name] = struct{}{}
-		}
+	if c, ok := tab.column(d.softDelete); ok && d.softDelete != "" {
+		tab.softDelete = &c
 	}
-	tab.PK = pk
-	return
+	return tab, nil
 }
 
-func (d *DbExplorer) processSelectRows(table string, rows *sql.Rows) (result []map[string]interface{}, err error) {
+func (d *DbExplorer) quote(ident string) string {
+	return d.dialect.Quote(ident)
+}
+
+func (d *DbExplorer) processSelectRows(columns []Col, rows *sql.Rows) (result []map[string]interface{}, err error) {
 	result = make([]map[string]interface{}, 0)
-	stubs := make([]interface{}, len(d.columns[table].Columns))
-	stubsPtrs := make([]interface{}, len(d.columns[table].Columns))
+	err = d.scanRows(columns, rows, func(res map[string]interface{}) error {
+		result = append(result, res)
+		return nil
+	})
+	return
+}
+
+// scanRows decodes rows one at a time and hands them to fn, so they don't
+// have to be kept in memory
+func (d *DbExplorer) scanRows(columns []Col, rows *sql.Rows, fn func(map[string]interface{}) error) (err error) {
+	stubs := make([]interface{}, len(columns))
+	stubsPtrs := make([]interface{}, len(columns))
 	for i := range stubs {
 		stubsPtrs[i] = &stubs[i]
 	}
@@ -133,88 +164,82 @@ func (d *DbExplorer) processSelectRows(table string, rows *sql.Rows) (result []m
 			return
 		}
 		res := make(map[string]interface{})
-		for i, c := range d.columns[table].Columns {
-			switch c.Type {
-			case "int":
-				v := &sql.NullInt32{}
-				err = v.Scan(stubs[i])
-				if err != nil {
-					return
-				}
-				if c.Null && !v.Valid {
-					res[c.Name] = nil
-					break
-				}
-				res[c.Name] = v.Int32
-			case "float":
-				v := &sql.NullFloat64{}
-				err = v.Scan(stubs[i])
-				if err != nil {
-					return
-				}
-				if c.Null && !v.Valid {
-					res[c.Name] = nil
-					break
-				}
-				res[c.Name] = v.Float64
-			case "varchar", "text":
-				v := &sql.NullString{}
-				err = v.Scan(stubs[i])
-				if err != nil {
-					return
-				}
-				if c.Null && !v.Valid {
-					res[c.Name] = nil
-					break
-				}
-				res[c.Name] = v.String
+		for i, c := range columns {
+			if stubs[i] == nil {
+				res[c.Name] = nil
+				continue
+			}
+			res[c.Name], err = d.types.lookup(c).Decode(c, stubs[i])
+			if err != nil {
+				return
 			}
 		}
-		result = append(result, res)
+		if err = fn(res); err != nil {
+			return
+		}
 	}
-	return
+	return rows.Err()
 }
 
-func (d *DbExplorer) selectList(table string, limit, offset int) (result []map[string]interface{}, err error) {
-	var rows *sql.Rows
-	rows, err = d.db.Query(fmt.Sprintf("SELECT %s FROM %s LIMIT ? OFFSET ?", d.columns[table].columnString, table), limit, offset)
-	if err != nil {
-		return
+func (d *DbExplorer) selectList(table string, lq listQuery) (result []map[string]interface{}, err error) {
+	columns, q, args := d.listSQL(table, lq)
+	return d.cachedSelect(table, columns, q, args)
+}
+
+// queryList runs the select of a list query, a negative limit selects all rows
+func (d *DbExplorer) queryList(table string, lq listQuery) (columns []Col, rows *sql.Rows, err error) {
+	columns, q, args := d.listSQL(table, lq)
+	rows, err = d.reader().Query(q, args...)
+	return columns, rows, err
+}
+
+func (d *DbExplorer) listSQL(table string, lq listQuery) (columns []Col, q string, args []interface{}) {
+	columns = d.columns[table].Columns
+	if len(lq.fields) > 0 {
+		columns = lq.fields
 	}
-	defer rows.Close()
-	return d.processSelectRows(table, rows)
+	sa := &sqlArgs{dialect: d.dialect}
+	q = fmt.Sprintf("SELECT %s FROM %s", d.columnsSQL(columns), d.quote(table))
+	q += d.whereSQL(lq.where, sa)
+	q += d.orderSQL(lq.order)
+	if lq.limit >= 0 {
+		q += fmt.Sprintf(" LIMIT %s OFFSET %s", sa.add(lq.limit), sa.add(lq.offset))
+	}
+	return columns, q, sa.args
 }
 
 func writeUnknownTable(w http.ResponseWriter) (err error) {
-	resp := finalResponse{Error: "unknown table"}
-	bs, err := json.Marshal(resp)
-	if err != nil {
-		return errorInternal
-	}
-	w.WriteHeader(http.StatusNotFound)
-	w.Write(bs)
-	return
+	return writeError(w, errorUnknownTable)
 }
 
-func writeRecordProblem(w http.ResponseWriter, err error) (e error) {
-	resp := finalResponse{Error: err.Error()}
-	bs, err := json.Marshal(resp)
-	if err != nil {
-		return errorInternal
-	}
-	w.WriteHeader(http.StatusBadRequest)
-	w.Write(bs)
-	return
+func writeRecordNotFound(w http.ResponseWriter) (err error) {
+	return writeError(w, errorRecordNotFound)
+}
+
+func (d *DbExplorer) selectByKey(table string, key []interface{}) (result []map[string]interface{}, err error) {
+	q, args := d.keySQL(table, key)
+	return d.cachedSelect(table, d.columns[table].Columns, q, args)
+}
+
+func (d *DbExplorer) selectRow(qr queryer, table string, key []interface{}) (result []map[string]interface{}, err error) {
+	q, args := d.keySQL(table, key)
+	return d.selectRows(qr, d.columns[table].Columns, q, args)
+}
+
+func (d *DbExplorer) keySQL(table string, key []interface{}) (q string, args []interface{}) {
+	tab := d.columns[table]
+	sa := &sqlArgs{dialect: d.dialect}
+	q = fmt.Sprintf("SELECT %s FROM %s", tab.columnString, d.quote(table)) + d.whereSQL(tab.keyConditions(key), sa)
+	return q, sa.args
 }
 
-func (d *DbExplorer) selectById(table string, id int) (result []map[string]interface{}, err error) {
-	var rows *sql.Rows
-	rows, err = d.db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", d.columns[table].columnString, table, d.columns[table].PK), id)
+func (d *DbExplorer) selectRows(qr queryer, columns []Col, q string, args []interface{}) (result []map[string]interface{}, err error) {
+	rows, err := qr.Query(q, args...)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
-	return d.processSelectRows(table, rows)
+	return d.processSelectRows(columns, rows)
 }
 
 func extractPartsOfPath(r *http.Request) (arr []string) {
@@ -226,28 +251,38 @@ func extractPartsOfPath(r *http.Request) (arr []string) {
 	return
 }
 
-func (d *DbExplorer) createRecord(table string, rawRecord map[string]interface{}) (result map[string]interface{}, err error) {
+func (d *DbExplorer) createRecord(p Principal, table string, rawRecord map[string]interface{}) (result map[string]interface{}, err error) {
 	result = make(map[string]interface{})
+	if err = d.checkWritable(p, table, rawRecord); err != nil {
+		return result, err
+	}
 	for _, col := range d.columns[table].Columns {
 		v, ok := rawRecord[col.Name]
 		if !ok {
 			continue
 		}
-		ttype := fmt.Sprintf("%T", v)
-		misTypes := ttype == "int" && col.Type != "int" || ttype == "float64" && col.Type != "int" && col.Type != "float64" || ttype == "string" && col.Type != "varchar" && col.Type != "text"
-		nullCheck := v == nil && !col.Null
-		if misTypes || nullCheck {
-			return result, errors.New(fmt.Sprintf("field %s have invalid type", col.Name))
-		}
-		if col.Type == "int" {
-			result[col.Name] = int(v.(float64))
+		if v == nil {
+			if !col.Null {
+				return result, fieldError(col, errorInvalidType)
+			}
+			result[col.Name] = nil
 			continue
 		}
-		result[col.Name] = v
+		result[col.Name], err = d.types.lookup(col).Encode(col, v)
+		if err != nil {
+			return result, fieldError(col, err)
+		}
 	}
 	return result, nil
 }
 
+// decodeJSON keeps numbers as json.Number so big integers and decimals survive
+func decodeJSON(bs []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
 func boolToInt(b bool) int {
 	if b {
 		return 1
@@ -264,61 +299,192 @@ func writeResponse(w http.ResponseWriter, resp finalResponse) {
 	w.Write(bs)
 }
 
-func (d *DbExplorer) updateRecord(table string, id int, record map[string]interface{}) (updated int, err error) {
-	tx, err := d.db.Begin()
+// updateRecord updates the record if it still matches ifMatch, when it is
+// not empty, and returns the ETag of the updated record
+func (d *DbExplorer) updateRecord(table string, key []interface{}, record map[string]interface{}, ifMatch string) (updated int, etag string, err error) {
+	tx, err := d.begin()
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
 	defer tx.Rollback()
 
+	current, err := d.checkIfMatch(tx, table, key, ifMatch)
+	if err != nil {
+		return 0, "", err
+	}
+	updated, etag, err = d.updateVersion(tx, table, key, record, current)
+	if err != nil {
+		return 0, "", err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, "", err
+	}
+	return updated, etag, nil
+}
+
+// updateVersion updates the version of the record If-Match was checked
+// against, if any, and returns the ETag of the result
+func (d *DbExplorer) updateVersion(tx *txn, table string, key []interface{}, record, current map[string]interface{}) (updated int, etag string, err error) {
+	versioned := d.versionConditions(table, current)
+	updated, err = d.updateRow(tx, table, key, record, versioned...)
+	if err != nil {
+		return 0, "", err
+	}
+	if updated == 0 && d.columns[table].softDelete != nil {
+		rows, err := d.selectRow(tx, table, key)
+		if err != nil {
+			return 0, "", err
+		}
+		if len(rows) > 0 && d.columns[table].isDeleted(rows[0]) {
+			return 0, "", errorRecordNotFound
+		}
+	}
+	if updated == 0 && len(versioned) > 0 {
+		return 0, "", errorPreconditionFailed
+	}
+	rows, err := d.selectRow(tx, table, key)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(rows) > 0 {
+		etag = d.recordETag(table, rows[0])
+	}
+	return updated, etag, nil
+}
+
+// settable tells whether updates may set the column, auto increment and
+// soft delete columns are left to the database and to DELETE and _restore
+func (t Table) settable(name string) bool {
+	if _, ok := t.AutoIncrement[name]; ok {
+		return false
+	}
+	return t.softDelete == nil || name != t.softDelete.Name
+}
+
+// setsColumns tells whether an update with the record has anything to set
+func (t Table) setsColumns(record map[string]interface{}) bool {
+	for name := range record {
+		if t.settable(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateRow updates the record with the key, extra conditions narrow it down
+// further. Soft deleted records are left alone and the soft delete column is
+// never set, records are restored only through _restore.
+func (d *DbExplorer) updateRow(ex execer, table string, key []interface{}, record map[string]interface{}, extra ...condition) (updated int, err error) {
+	tab := d.columns[table]
 	cols := make([]string, 0)
-	vals := make([]interface{}, 0)
-	pk := d.columns[table].PK
+	args := &sqlArgs{dialect: d.dialect}
 	for k, v := range record {
-		if _, ok := d.columns[table].AutoIncrement[k]; ok {
+		if !tab.settable(k) {
 			continue
 		}
-		cols = append(cols, fmt.Sprintf("%s = ?", k))
-		vals = append(vals, v)
+		cols = append(cols, fmt.Sprintf("%s = %s", d.quote(k), args.add(v)))
+	}
+	if len(cols) == 0 {
+		return 0, errorNothingToUpdate
+	}
+	if c, ok := d.versionColumn(table); ok && d.types.lookup(c).Kind() == "integer" {
+		if _, set := record[c.Name]; !set {
+			cols = append(cols, fmt.Sprintf("%s = %s + 1", d.quote(c.Name), d.quote(c.Name)))
+		}
 	}
-	vals = append(vals, id)
 	colsString := strings.Join(cols, ", ")
-	q := fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?", table, colsString, pk)
+	conds := tab.keyConditions(key)
+	if tab.softDelete != nil {
+		conds = append(conds, condition{col: *tab.softDelete, op: "null", value: true})
+	}
+	q := fmt.Sprintf("UPDATE %s SET %s", d.quote(table), colsString) + d.whereSQL(append(conds, extra...), args)
 
-	res, err := tx.Exec(q, vals...)
+	before, err := d.auditImage(ex, table, key)
 	if err != nil {
 		return 0, err
 	}
-	err = tx.Commit()
+	res, err := ex.Exec(q, args.args...)
 	if err != nil {
 		return 0, err
 	}
 	affected, err := res.RowsAffected()
-	return boolToInt(affected > 0), err
+	if err != nil || affected == 0 {
+		return 0, err
+	}
+	after, err := d.auditImage(ex, table, key)
+	if err != nil {
+		return 0, err
+	}
+	return 1, d.recordChange(ex, AuditUpdate, table, key, before, after)
 }
 
-func (d *DbExplorer) deleteById(table string, id int) (deleted int, err error) {
-	q := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", table, d.columns[table].PK)
-	res, err := d.db.Exec(q, id)
+// deleteByKey deletes the record if it still matches ifMatch, when it is not empty
+func (d *DbExplorer) deleteByKey(table string, key []interface{}, ifMatch string) (deleted int, err error) {
+	tx, err := d.begin()
 	if err != nil {
 		return 0, err
 	}
-	affected, err := res.RowsAffected()
+	defer tx.Rollback()
+
+	current, err := d.checkIfMatch(tx, table, key, ifMatch)
+	if err != nil {
+		return 0, err
+	}
+	versioned := d.versionConditions(table, current)
+	deleted, err = d.deleteRow(tx, table, key, versioned...)
 	if err != nil {
 		return 0, err
 	}
-	return boolToInt(affected > 0), err
+	if deleted == 0 && ifMatch != "" {
+		return 0, errorPreconditionFailed
+	}
+	return deleted, tx.Commit()
+}
+
+// deleteRow deletes the record with the key, extra conditions narrow it down
+// further. Records of tables with the soft delete column are only marked.
+func (d *DbExplorer) deleteRow(ex execer, table string, key []interface{}, extra ...condition) (deleted int, err error) {
+	if d.columns[table].softDelete != nil {
+		return d.markDeleted(ex, table, key, true, extra...)
+	}
+	args := &sqlArgs{dialect: d.dialect}
+	q := fmt.Sprintf("DELETE FROM %s", d.quote(table)) + d.whereSQL(append(d.columns[table].keyConditions(key), extra...), args)
+	before, err := d.auditImage(ex, table, key)
+	if err != nil {
+		return 0, err
+	}
+	res, err := ex.Exec(q, args.args...)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil || affected == 0 {
+		return 0, err
+	}
+	return 1, d.recordChange(ex, AuditDelete, table, key, before, nil)
 }
 
 func (d *DbExplorer) insertRecord(table string, record map[string]interface{}) (lastId int64, err error) {
-	tx, err := d.db.Begin()
+	tx, err := d.begin()
 	if err != nil {
 		return lastId, err
 	}
 	defer tx.Rollback()
 
+	lastId, err = d.insertRow(tx, table, record)
+	if err != nil {
+		return lastId, err
+	}
+	err = tx.Commit()
+	return lastId, err
+}
+
+// insertSQL builds the INSERT of a record, returning tells that the statement
+// ends with RETURNING and gives the new id as a row
+func (d *DbExplorer) insertSQL(table string, record map[string]interface{}) (q string, vals []interface{}, returning bool) {
 	cols := make([]string, 0)
-	vals := make([]interface{}, 0)
+	vals = make([]interface{}, 0)
 	questions := make([]string, 0)
 	for _, c := range d.columns[table].Columns {
 		if _, ok := d.columns[table].AutoIncrement[c.Name]; ok {
@@ -329,27 +495,50 @@ func (d *DbExplorer) insertRecord(table string, record map[string]interface{}) (
 		if !ok && c.Null {
 			continue
 		}
-		cols = append(cols, c.Name)
 		if !ok {
-			v = c.defaultVar()
+			if v, ok = d.types.lookup(c).Zero(c); !ok {
+				continue
+			}
 		}
+		cols = append(cols, d.quote(c.Name))
 		vals = append(vals, v)
-		questions = append(questions, "?")
+		questions = append(questions, d.dialect.Placeholder(len(vals)))
 	}
 	colsString := strings.Join(cols, ", ")
 	questionsString := strings.Join(questions, ", ")
-	q := fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s)", table, colsString, questionsString)
+	q = fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s)", d.quote(table), colsString, questionsString)
+
+	if pk := d.columns[table].autoIncrementPK(); d.dialect.Returning() && pk != "" {
+		return q + " RETURNING " + d.quote(pk), vals, true
+	}
+	return q, vals, false
+}
 
-	res, err := tx.Exec(q, vals...)
+func (d *DbExplorer) insertRow(ex execer, table string, record map[string]interface{}) (lastId int64, err error) {
+	q, vals, returning := d.insertSQL(table, record)
+	if returning {
+		err = ex.QueryRow(q, vals...).Scan(&lastId)
+	} else {
+		var res sql.Result
+		res, err = ex.Exec(q, vals...)
+		if err == nil && d.columns[table].autoIncrementPK() != "" {
+			lastId, err = res.LastInsertId()
+		}
+	}
 	if err != nil {
 		return lastId, err
 	}
-	lastId, err = res.LastInsertId()
+
+	inserted := d.columns[table].insertedKey(record, lastId)
+	key := make([]interface{}, len(d.columns[table].PK))
+	for i, name := range d.columns[table].PK {
+		key[i] = inserted[name]
+	}
+	after, err := d.auditImage(ex, table, key)
 	if err != nil {
 		return lastId, err
 	}
-	err = tx.Commit()
-	return lastId, err
+	return lastId, d.recordChange(ex, AuditInsert, table, key, nil, after)
 }
 
 func readParam(r *http.Request, paramName string, defaultValue int) int {
@@ -366,62 +555,108 @@ func readParam(r *http.Request, paramName string, defaultValue int) int {
 	return defaultValue
 }
 
-func (d *DbExplorer) getTables(w http.ResponseWriter) (err error) {
-	resp := finalResponse{Response: map[string]interface{}{"tables": d.tables}}
+func (d *DbExplorer) getTables(w http.ResponseWriter, r *http.Request) (err error) {
+	p := principalFrom(r)
+	tables := make([]string, 0, len(d.tables))
+	for _, table := range d.tables {
+		if d.policy.canRead(p, table) {
+			tables = append(tables, table)
+		}
+	}
+	resp := finalResponse{Response: map[string]interface{}{"tables": tables}}
+	relations := make([]ForeignKey, 0)
+	for _, fk := range d.relations() {
+		if d.policy.canRead(p, fk.Table) && d.policy.canRead(p, fk.RefTable) {
+			relations = append(relations, fk)
+		}
+	}
+	if len(relations) > 0 {
+		resp.Response["relations"] = relations
+	}
 	writeResponse(w, resp)
 	return
 }
 
 func (d *DbExplorer) getFromTable(w http.ResponseWriter, r *http.Request, arr []string) (err error) {
 	table := arr[0]
-	_, ok := d.columns[table]
-	if !ok {
+	if _, ok := d.columns[table]; !ok {
 		return writeUnknownTable(w)
 	}
+	p := principalFrom(r)
+	if !d.policy.canRead(p, table) {
+		return writeForbidden(w)
+	}
+	tab := d.visibleTable(p, table)
 
-	limit := readParam(r, "limit", 5)
-	offset := readParam(r, "offset", 0)
+	lq, err := d.parseListQuery(r, tab)
+	if err != nil {
+		return writeError(w, err)
+	}
+	if len(lq.fields) == 0 && len(tab.Columns) < len(d.columns[table].Columns) {
+		lq.fields = tab.Columns
+	}
+	if format := r.URL.Query().Get("format"); format != "" {
+		return d.exportRows(w, r, table, lq, format)
+	}
+	expand, err := d.parseExpand(p, r, tab, &lq)
+	if err != nil {
+		return writeError(w, err)
+	}
 
-	result, err := d.selectList(table, limit, offset)
+	response, err := d.listPage(table, lq)
 	if err != nil {
 		return errorInternal
 	}
+	if records, ok := response["records"].([]map[string]interface{}); ok {
+		if err = d.expand(p, r, records, expand); err != nil {
+			return errorInternal
+		}
+	}
 
-	resp := finalResponse{Response: map[string]interface{}{"records": result}}
+	resp := finalResponse{Response: response}
 	writeResponse(w, resp)
 	return
 }
 
-func (d *DbExplorer) getRecord(w http.ResponseWriter, arr []string) (e error) {
+func (d *DbExplorer) getRecord(w http.ResponseWriter, r *http.Request, arr []string) (e error) {
 	var resp finalResponse
 	table := arr[0]
-	idString := arr[1]
-	var id int
-
-	id, err := strconv.Atoi(idString)
-	if err != nil {
-		return errorInternal
-	}
 
-	_, ok := d.columns[table]
+	tab, ok := d.columns[table]
 	if !ok {
 		return writeUnknownTable(w)
 	}
+	p := principalFrom(r)
+	if !d.policy.canRead(p, table) {
+		return writeForbidden(w)
+	}
+
+	key, err := d.parseRecordKey(r, tab, arr[1])
+	if err != nil {
+		return writeError(w, err)
+	}
+	expand, err := d.parseExpand(p, r, d.visibleTable(p, table), nil)
+	if err != nil {
+		return writeError(w, err)
+	}
 
-	result, err := d.selectById(table, id)
+	result, err := d.selectByKey(table, key)
 	if err != nil {
 		return errorInternal
 	}
-	if len(result) == 0 {
-		resp = finalResponse{Error: "record not found"}
-		bs, err := json.Marshal(resp)
-		if err != nil {
-			return errorInternal
-		}
-		w.WriteHeader(http.StatusNotFound)
-		w.Write(bs)
+	if len(result) == 0 || tab.isDeleted(result[0]) && !withDeleted(r) {
+		return writeRecordNotFound(w)
+	}
+	etag := d.recordETag(table, result[0])
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
+	d.hideColumns(p, table, result)
+	if err = d.expand(p, r, result, expand); err != nil {
+		return errorInternal
+	}
 
 	resp = finalResponse{Response: map[string]interface{}{"record": result[0]}}
 	writeResponse(w, resp)
@@ -434,13 +669,23 @@ func (d *DbExplorer) putRecord(w http.ResponseWriter, r *http.Request) (err erro
 	if arr := extractPartsOfPath(r); len(arr) == 1 {
 		table = arr[0]
 	} else {
-		return errorInternal
+		return errorUnknownPath
 	}
 
-	_, ok := d.columns[table]
+	tab, ok := d.columns[table]
 	if !ok {
 		return writeUnknownTable(w)
 	}
+	if len(tab.PK) == 0 {
+		return writeReadOnlyTable(w)
+	}
+	p := principalFrom(r)
+	if !d.policy.canWrite(p, table) {
+		return writeForbidden(w)
+	}
+	if format := importFormat(r); format != "" {
+		return d.importRecords(w, r, p, table, format)
+	}
 
 	defer r.Body.Close()
 	bs, err := ioutil.ReadAll(r.Body)
@@ -448,21 +693,34 @@ func (d *DbExplorer) putRecord(w http.ResponseWriter, r *http.Request) (err erro
 		return errorInternal
 	}
 
-	var rawRecord map[string]interface{}
-	err = json.Unmarshal(bs, &rawRecord)
+	var body interface{}
+	err = decodeJSON(bs, &body)
 	if err != nil {
-		return errorInternal
+		return errorInvalidJSON
+	}
+	if list, ok := body.([]interface{}); ok {
+		if err = d.checkWriteQuota(w, r, table, len(list)); err != nil {
+			return err
+		}
+		return d.putRecords(w, p, table, list)
+	}
+	rawRecord, ok := body.(map[string]interface{})
+	if !ok {
+		return errorInvalidJSON
+	}
+	if err = d.checkWriteQuota(w, r, table, 1); err != nil {
+		return err
 	}
-	record, err := d.createRecord(table, rawRecord)
+	record, err := d.createRecord(p, table, rawRecord)
 	if err != nil {
-		return writeRecordProblem(w, err)
+		return writeError(w, err)
 	}
 	lastId, err := d.insertRecord(table, record)
 	if err != nil {
-		return errorInternal
+		return d.dbError(err)
 	}
 
-	resp = finalResponse{Response: map[string]interface{}{d.columns[table].PK: lastId}}
+	resp = finalResponse{Response: tab.insertedKey(record, lastId)}
 	writeResponse(w, resp)
 	return
 }
@@ -472,22 +730,28 @@ func (d *DbExplorer) postRecord(w http.ResponseWriter, r *http.Request) (e error
 	var resp finalResponse
 	table := ""
 	idString := ""
-	var id int
 	if arr := extractPartsOfPath(r); len(arr) == 2 {
 		table = arr[0]
 		idString = arr[1]
-		id, err = strconv.Atoi(idString)
-		if err != nil {
-			return errorInternal
-		}
 	} else {
-		return errorInternal
+		return errorUnknownPath
 	}
 
-	_, ok := d.columns[table]
+	tab, ok := d.columns[table]
 	if !ok {
 		return writeUnknownTable(w)
 	}
+	if len(tab.PK) == 0 {
+		return writeReadOnlyTable(w)
+	}
+	p := principalFrom(r)
+	if !d.policy.canWrite(p, table) {
+		return writeForbidden(w)
+	}
+	key, err := d.parseRecordKey(r, tab, idString)
+	if err != nil {
+		return writeError(w, err)
+	}
 
 	defer r.Body.Close()
 	bs, err := ioutil.ReadAll(r.Body)
@@ -496,24 +760,30 @@ func (d *DbExplorer) postRecord(w http.ResponseWriter, r *http.Request) (e error
 	}
 
 	var rawRecord map[string]interface{}
-	err = json.Unmarshal(bs, &rawRecord)
+	err = decodeJSON(bs, &rawRecord)
 	if err != nil {
-		return errorInternal
+		return errorInvalidJSON
 	}
-	for k := range rawRecord {
-		if _, ok := d.columns[table].AutoIncrement[k]; ok {
-			err = errors.New(fmt.Sprintf("field %s have invalid type", d.columns[table].PK))
-			return writeRecordProblem(w, err)
+	// primary key can't be changed for an existing record
+	for _, c := range tab.pkColumns() {
+		if _, ok := rawRecord[c.Name]; ok {
+			return writeError(w, fieldError(c, errorInvalidType))
 		}
 	}
-	record, err := d.createRecord(table, rawRecord)
+	record, err := d.createRecord(p, table, rawRecord)
 	if err != nil {
-		return writeRecordProblem(w, err)
+		return writeError(w, err)
+	}
+	if !tab.setsColumns(record) {
+		return writeError(w, errorNothingToUpdate)
 	}
 
-	updated, err := d.updateRecord(table, id, record)
+	updated, etag, err := d.updateRecord(table, key, record, r.Header.Get("If-Match"))
 	if err != nil {
-		return errorInternal
+		return d.dbError(err)
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
 	}
 
 	resp = finalResponse{Response: map[string]interface{}{"updated": updated}}
@@ -529,22 +799,28 @@ func (d *DbExplorer) deleteRecord(w http.ResponseWriter, r *http.Request) (err e
 		table = arr[0]
 		idString = arr[1]
 	} else {
-		return errorInternal
+		return errorUnknownPath
 	}
 
-	_, ok := d.columns[table]
+	tab, ok := d.columns[table]
 	if !ok {
 		return writeUnknownTable(w)
 	}
+	if len(tab.PK) == 0 {
+		return writeReadOnlyTable(w)
+	}
+	if !d.policy.canWrite(principalFrom(r), table) {
+		return writeForbidden(w)
+	}
 
-	id, err := strconv.Atoi(idString)
+	key, err := d.parseRecordKey(r, tab, idString)
 	if err != nil {
-		return errorInternal
+		return writeError(w, err)
 	}
 
-	deleted, err := d.deleteById(table, id)
+	deleted, err := d.deleteByKey(table, key, r.Header.Get("If-Match"))
 	if err != nil {
-		return errorInternal
+		return d.dbError(err)
 	}
 
 	resp = finalResponse{Response: map[string]interface{}{"deleted": deleted}}
@@ -553,49 +829,146 @@ func (d *DbExplorer) deleteRecord(w http.ResponseWriter, r *http.Request) (err e
 }
 
 func (d *DbExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var err error
+	d = d.snapshot()
+	d.ctx = &requestContext{ctx: r.Context(), timeout: d.timeout}
+	defer d.ctx.release()
+	if d.metrics != nil || d.accessLog != nil {
+		rr := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		// r is replaced by the authenticated request before it is observed
+		defer func() { d.observe(rr, r, start) }()
+		w = rr
+	}
+	r, err := d.authenticate(r)
+	if err != nil {
+		// failed logins are charged to the address, guessing keys is throttled
+		if limited := d.checkRateLimit(w, r); limited != nil {
+			err = limited
+		}
+		writeError(w, err)
+		return
+	}
+	d.actor = principalFrom(r).Name
+	if err = d.checkRateLimit(w, r); err != nil {
+		writeError(w, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		// PUT /$table charges every record it creates itself
+		if arr := extractPartsOfPath(r); len(arr) > 1 || len(arr) == 1 && r.Method != http.MethodPut {
+			if err = d.checkWriteQuota(w, r, arr[0], 1); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+	}
+	if d.replicas != nil {
+		if r.Method == http.MethodGet {
+			d.primaryReads = d.replicas.recentWrite(r.Header.Get(lastWriteHeader))
+		} else {
+			w = &stampWriter{ResponseWriter: w}
+		}
+	}
 	switch {
 	case r.Method == "GET" && r.URL.Path == "/":
-		d.getTables(w)
+		d.getTables(w, r)
+	case r.Method == "GET" && strings.Trim(r.URL.Path, "/") == openAPIPath:
+		err = d.getOpenAPI(w, r)
+	case r.Method == "GET" && strings.Trim(r.URL.Path, "/") == auditPath:
+		err = d.getAudit(w, r)
+	case r.Method == "GET" && strings.Trim(r.URL.Path, "/") == cachePath:
+		err = d.getCache(w, r)
+	case r.Method == "GET" && strings.Trim(r.URL.Path, "/") == metricsPath:
+		err = d.getMetrics(w, r)
+	case r.Method == "GET" && strings.Trim(r.URL.Path, "/") == changesPath:
+		err = d.getChanges(w, r)
+	case r.Method == "GET" && strings.Trim(r.URL.Path, "/") == searchPath:
+		err = d.searchTables(w, r)
 	case r.Method == "GET":
 		arr := extractPartsOfPath(r)
 		if len(arr) == 1 {
 			err = d.getFromTable(w, r, arr)
+		} else if len(arr) == 2 && arr[1] == aggregatePath {
+			err = d.getAggregate(w, r, arr)
 		} else if len(arr) == 2 {
-			err = d.getRecord(w, arr)
+			err = d.getRecord(w, r, arr)
+		} else if len(arr) == 3 {
+			err = d.getRelated(w, r, arr)
 		} else {
-			err = errorInternal
+			err = errorUnknownPath
 		}
+	case r.Method == "PUT" && len(extractPartsOfPath(r)) == 2:
+		err = d.replaceRecord(w, r)
 	case r.Method == "PUT":
 		err = d.putRecord(w, r)
+	case r.Method == "PATCH":
+		err = d.patchRecord(w, r)
+	case r.Method == "POST" && strings.Trim(r.URL.Path, "/") == batchPath:
+		err = d.postBatch(w, r)
+	case r.Method == "POST" && strings.Trim(r.URL.Path, "/") == schemaPath:
+		err = d.postSchema(w, r)
+	case r.Method == "POST" && strings.HasSuffix(strings.TrimRight(r.URL.Path, "/"), "/"+restorePath):
+		err = d.restoreRecord(w, r)
 	case r.Method == "POST":
 		err = d.postRecord(w, r)
 	case r.Method == "DELETE":
 		err = d.deleteRecord(w, r)
+	default:
+		err = errorBadMethod
 	}
-	if errors.Is(err, errorInternal) {
-		w.WriteHeader(http.StatusInternalServerError)
+	if err != nil {
+		if d.ctx.timedOut() {
+			err = errorStatementTimeout
+		}
+		writeError(w, err)
+	}
+}
+
+// WithColumnType registers a column type under its base SQL type name,
+// replacing the built-in one if there is any.
+func WithColumnType(name string, t ColumnType) Option {
+	return func(d *DbExplorer) {
+		d.types[name] = t
 	}
 }
 
-func NewDbExplorer(db *sql.DB) (d *DbExplorer, err error) {
+// WithDialect selects the SQL dialect, MySQL is used by default.
+func WithDialect(dialect Dialect) Option {
+	return func(d *DbExplorer) {
+		d.dialect = dialect
+	}
+}
+
+func NewDbExplorer(db *sql.DB, opts ...Option) (d *DbExplorer, err error) {
 	if db == nil {
 		return nil, fmt.Errorf("database is nil")
 	}
-	d = &DbExplorer{db: db}
-	d.tables, err = d.getAllTables()
-	d.columns = make(map[string]Table, len(d.tables))
-	for _, table := range d.tables {
-		tab, err := d.getColumns(table)
-		if err != nil {
-			return d, err
-		}
-		cols := make([]string, len(tab.Columns))
-		for i, c := range tab.Columns {
-			cols[i] = c.Name
-		}
-		tab.columnString = strings.Join(cols, ", ")
-		d.columns[table] = tab
+	d = &DbExplorer{
+		db:      db,
+		dialect: mysqlDialect{},
+		types:   defaultTypes(),
+		state:   &schemaState{done: make(chan struct{})},
+
+		softDelete: defaultSoftDeleteColumn,
+		feed:       newChangeFeed(),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	s, err := d.loadSchema()
+	if err != nil {
+		return d, err
+	}
+	d.state.current.Store(s)
+	d.tables, d.columns = s.tables, s.columns
+	if d.refresh > 0 {
+		go d.pollSchema(d.refresh)
+	}
+	if d.polling.column != "" && d.polling.interval > 0 {
+		go d.pollChanges()
+	}
+	if d.replicas != nil && len(d.replicas.replicas) > 0 {
+		go d.checkReplicas()
 	}
 	return d, nil
 }
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+type (
+	queryer interface {
+		Query(query string, args ...interface{}) (*sql.Rows, error)
+	}
+	// Dialect hides the differences between SQL engines that DbExplorer
+	// cares about: schema introspection, placeholders, identifier quoting
+	// and the way the id of an inserted row is obtained.
+	Dialect interface {
+		Name() string
+		Tables(q queryer) ([]string, error)
+		Columns(q queryer, table string) (Table, error)
+		ForeignKeys(q queryer, table string) ([]ForeignKey, error)
+		// FullTextIndexes returns the columns of every full-text index of
+		// the table that can be searched with MATCH ... AGAINST.
+		FullTextIndexes(q queryer, table string) ([][]string, error)
+		// Placeholder returns the bind parameter for the n-th (1-based) argument.
+		Placeholder(n int) string
+		Quote(ident string) string
+		// Returning reports whether inserted ids must be read through
+		// INSERT ... RETURNING instead of sql.Result.LastInsertId.
+		Returning() bool
+		// LocksRows reports whether SELECT ... FOR UPDATE locks the rows it
+		// reads until the transaction ends. SQLite has no row locks, the
+		// first writer locks the whole database and the others fail.
+		LocksRows() bool
+		// ErrorCode recognizes constraint violations in driver errors: it
+		// returns CodeDuplicateKey, CodeForeignKey or CodeTooLong and the
+		// offending column when the driver reports it, or an empty code.
+		ErrorCode(err error) (code, field string)
+	}
+
+	mysqlDialect    struct{}
+	postgresDialect struct{}
+	sqliteDialect   struct{}
+)
+
+// DialectByDriver returns the dialect matching a database/sql driver name.
+func DialectByDriver(driver string) (Dialect, error) {
+	switch driver {
+	case "mysql":
+		return mysqlDialect{}, nil
+	case "postgres", "pgx":
+		return postgresDialect{}, nil
+	case "sqlite3", "sqlite":
+		return sqliteDialect{}, nil
+	}
+	return nil, fmt.Errorf("unsupported driver %q", driver)
+}
+
+// normalizeType reduces an engine specific column type to the MySQL base
+// type name used as a key in the type registry.
+func normalizeType(rawType string) string {
+	t := strings.TrimSpace(strings.Split(strings.ToLower(rawType), "(")[0])
+	switch {
+	case strings.HasPrefix(t, "timestamp"):
+		return "datetime"
+	case strings.HasPrefix(t, "time"):
+		return "time"
+	}
+	switch t {
+	case "integer", "int4", "serial":
+		return "int"
+	case "int8", "bigserial":
+		return "bigint"
+	case "int2":
+		return "smallint"
+	case "real", "float4":
+		return "float"
+	case "double precision", "float8":
+		return "double"
+	case "numeric":
+		return "decimal"
+	case "character varying", "nvarchar", "uuid":
+		return "varchar"
+	case "character", "nchar":
+		return "char"
+	case "clob":
+		return "text"
+	case "boolean":
+		return "bool"
+	case "bytea":
+		return "blob"
+	case "jsonb":
+		return "json"
+	}
+	return t
+}
+
+func scanStrings(q queryer, query string, args ...interface{}) (result []string, err error) {
+	var rows *sql.Rows
+	rows, err = q.Query(query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	var s string
+	for rows.Next() {
+		err = rows.Scan(&s)
+		if err != nil {
+			return
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+// between returns the part of s enclosed by start and end, or an empty string
+func between(s, start, end string) string {
+	i := strings.Index(s, start)
+	if i < 0 {
+		return ""
+	}
+	s = s[i+len(start):]
+	j := strings.Index(s, end)
+	if j < 0 {
+		return ""
+	}
+	return s[:j]
+}
+
+func (mysqlDialect) Name() string { return "mysql" }
+
+func (mysqlDialect) Tables(q queryer) ([]string, error) {
+	return scanStrings(q, "SHOW TABLES")
+}
+
+func (m mysqlDialect) Columns(q queryer, table string) (tab Table, err error) {
+	var rows *sql.Rows
+	rows, err = q.Query("SHOW FULL COLUMNS FROM " + m.Quote(table))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	var col Column
+	tab.Columns = make([]Col, 0)
+	tab.AutoIncrement = make(map[string]struct{})
+	for rows.Next() {
+		err = rows.Scan(&col.Field, &col.Type, &col.Collation, &col.Null, &col.Key, &col.Default, &col.Extra, &col.Privileges, &col.Comment)
+		if err != nil {
+			return
+		}
+		def := strings.ToLower(col.Type)
+		c := Col{
+			Name: col.Field,
+			Type: strings.Split(def, "(")[0],
+			Def:  def,
+			Null: strings.ToLower(col.Null) == "yes",
+			PK:   strings.ToLower(col.Key) == "pri",
+		}
+		if strings.HasPrefix(def, "tinyint(1)") || def == "bit(1)" {
+			c.Type = "bool"
+		}
+		tab.Columns = append(tab.Columns, c)
+		if strings.ToLower(col.Extra) == "auto_increment" {
+			tab.AutoIncrement[c.Name] = struct{}{}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	// SHOW COLUMNS gives the table order, composite keys need the index order
+	tab.PK, err = scanStrings(q, `SELECT COLUMN_NAME FROM information_schema.KEY_COLUMN_USAGE
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND CONSTRAINT_NAME = 'PRIMARY'
+		ORDER BY ORDINAL_POSITION`, table)
+	return tab, err
+}
+
+// scanForeignKeys reads rows of (constraint, column, referenced table,
+// referenced column) ordered by constraint and position into foreign keys
+func scanForeignKeys(q queryer, table, query string, args ...interface{}) (fks []ForeignKey, err error) {
+	var rows *sql.Rows
+	rows, err = q.Query(query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	var name, col, refTable string
+	var refCol sql.NullString
+	for rows.Next() {
+		err = rows.Scan(&name, &col, &refTable, &refCol)
+		if err != nil {
+			return
+		}
+		if len(fks) == 0 || fks[len(fks)-1].Name != name {
+			fks = append(fks, ForeignKey{Name: name, Table: table, RefTable: refTable})
+		}
+		fk := &fks[len(fks)-1]
+		fk.Columns = append(fk.Columns, col)
+		if refCol.Valid {
+			fk.RefColumns = append(fk.RefColumns, refCol.String)
+		}
+	}
+	return fks, rows.Err()
+}
+
+func (mysqlDialect) ForeignKeys(q queryer, table string) ([]ForeignKey, error) {
+	return scanForeignKeys(q, table, `SELECT CONSTRAINT_NAME, COLUMN_NAME, REFERENCED_TABLE_NAME, REFERENCED_COLUMN_NAME
+		FROM information_schema.KEY_COLUMN_USAGE
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND REFERENCED_TABLE_NAME IS NOT NULL
+		ORDER BY CONSTRAINT_NAME, ORDINAL_POSITION`, table)
+}
+
+func (mysqlDialect) FullTextIndexes(q queryer, table string) (indexes [][]string, err error) {
+	rows, err := q.Query(`SELECT INDEX_NAME, COLUMN_NAME
+		FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_TYPE = 'FULLTEXT'
+		ORDER BY INDEX_NAME, SEQ_IN_INDEX`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var name, col, last string
+	for rows.Next() {
+		if err = rows.Scan(&name, &col); err != nil {
+			return nil, err
+		}
+		if len(indexes) == 0 || name != last {
+			indexes = append(indexes, nil)
+			last = name
+		}
+		indexes[len(indexes)-1] = append(indexes[len(indexes)-1], col)
+	}
+	return indexes, rows.Err()
+}
+
+func (mysqlDialect) Placeholder(int) string { return "?" }
+
+func (mysqlDialect) Quote(ident string) string {
+	return "`" + strings.ReplaceAll(ident, "`", "``") + "`"
+}
+
+func (mysqlDialect) Returning() bool { return false }
+
+func (mysqlDialect) LocksRows() bool { return true }
+
+func (mysqlDialect) ErrorCode(err error) (code, field string) {
+	var me *mysql.MySQLError
+	if !errors.As(err, &me) {
+		return "", ""
+	}
+	switch me.Number {
+	case 1062: // ER_DUP_ENTRY
+		return CodeDuplicateKey, ""
+	case 1451, 1452: // ER_ROW_IS_REFERENCED_2, ER_NO_REFERENCED_ROW_2
+		return CodeForeignKey, between(me.Message, "FOREIGN KEY (`", "`")
+	case 1406: // ER_DATA_TOO_LONG
+		return CodeTooLong, between(me.Message, "column '", "'")
+	}
+	return "", ""
+}
+
+func (postgresDialect) Name() string { return "postgres" }
+
+func (postgresDialect) Tables(q queryer) ([]string, error) {
+	return scanStrings(q, `SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'
+		ORDER BY table_name`)
+}
+
+func (postgresDialect) Columns(q queryer, table string) (tab Table, err error) {
+	pks, err := scanStrings(q, `SELECT kcu.column_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu
+			ON tc.constraint_name = kcu.constraint_name AND tc.table_schema = kcu.table_schema
+		WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = current_schema() AND tc.table_name = $1
+		ORDER BY kcu.ordinal_position`, table)
+	if err != nil {
+		return
+	}
+	var rows *sql.Rows
+	rows, err = q.Query(`SELECT column_name, data_type, udt_schema, udt_name, is_nullable, column_default, is_identity,
+			character_maximum_length, numeric_precision, numeric_scale
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1
+		ORDER BY ordinal_position`, table)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	tab.Columns = make([]Col, 0)
+	tab.AutoIncrement = make(map[string]struct{})
+	// enum labels are read once the columns are, a connection runs one query at a time
+	enums := make(map[int][2]string)
+	var name, dataType, udtSchema, udtName, nullable, identity string
+	var def sql.NullString
+	var length, precision, scale sql.NullInt64
+	for rows.Next() {
+		err = rows.Scan(&name, &dataType, &udtSchema, &udtName, &nullable, &def, &identity, &length, &precision, &scale)
+		if err != nil {
+			return
+		}
+		c := Col{
+			Name: name,
+			Type: normalizeType(dataType),
+			Def:  strings.ToLower(dataType),
+			Null: strings.ToLower(nullable) == "yes",
+		}
+		switch {
+		case (c.Type == "varchar" || c.Type == "char") && length.Valid:
+			c.Def = fmt.Sprintf("%s(%d)", c.Type, length.Int64)
+		case c.Type == "decimal" && precision.Valid:
+			c.Def = fmt.Sprintf("decimal(%d,%d)", precision.Int64, scale.Int64)
+		case c.Type == "user-defined":
+			enums[len(tab.Columns)] = [2]string{udtSchema, udtName}
+			c.Type, c.Def = udtName, udtName
+		}
+		for _, pk := range pks {
+			if pk == name {
+				c.PK = true
+			}
+		}
+		tab.Columns = append(tab.Columns, c)
+		if strings.ToLower(identity) == "yes" || strings.HasPrefix(def.String, "nextval(") {
+			tab.AutoIncrement[name] = struct{}{}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	rows.Close()
+	for i, udt := range enums {
+		var labels []string
+		labels, err = scanStrings(q, `SELECT e.enumlabel FROM pg_enum e
+			JOIN pg_type t ON t.oid = e.enumtypid
+			JOIN pg_namespace n ON n.oid = t.typnamespace
+			WHERE n.nspname = $1 AND t.typname = $2
+			ORDER BY e.enumsortorder`, udt[0], udt[1])
+		if err != nil {
+			return
+		}
+		// other user defined types keep their name and fall back to strings
+		if len(labels) > 0 {
+			tab.Columns[i].Type, tab.Columns[i].Def = "enum", enumDef(labels)
+		}
+	}
+	tab.PK = pks
+	return tab, nil
+}
+
+// enumDef writes the labels of a Postgres enum as a MySQL enum definition
+// so that enum columns are checked the same way on both engines
+func enumDef(labels []string) string {
+	quoted := make([]string, len(labels))
+	for i, l := range labels {
+		quoted[i] = "'" + strings.ReplaceAll(l, "'", "''") + "'"
+	}
+	return "enum(" + strings.Join(quoted, ",") + ")"
+}
+
+func (postgresDialect) ForeignKeys(q queryer, table string) ([]ForeignKey, error) {
+	return scanForeignKeys(q, table, `SELECT kcu.constraint_name, kcu.column_name, rku.table_name, rku.column_name
+		FROM information_schema.referential_constraints rc
+		JOIN information_schema.key_column_usage kcu
+			ON kcu.constraint_name = rc.constraint_name AND kcu.constraint_schema = rc.constraint_schema
+		JOIN information_schema.key_column_usage rku
+			ON rku.constraint_name = rc.unique_constraint_name AND rku.constraint_schema = rc.unique_constraint_schema
+			AND rku.ordinal_position = kcu.position_in_unique_constraint
+		WHERE kcu.table_schema = current_schema() AND kcu.table_name = $1
+		ORDER BY kcu.constraint_name, kcu.ordinal_position`, table)
+}
+
+// FullTextIndexes returns nothing, text columns are searched with LIKE
+func (postgresDialect) FullTextIndexes(queryer, string) ([][]string, error) { return nil, nil }
+
+func (postgresDialect) Placeholder(n int) string { return "$" + strconv.Itoa(n) }
+
+func (postgresDialect) Quote(ident string) string {
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
+
+func (postgresDialect) Returning() bool { return true }
+
+func (postgresDialect) LocksRows() bool { return true }
+
+func (postgresDialect) ErrorCode(err error) (code, field string) {
+	var pe *pq.Error
+	if !errors.As(err, &pe) {
+		return "", ""
+	}
+	switch pe.Code {
+	case "23505": // unique_violation
+		return CodeDuplicateKey, between(pe.Detail, "Key (", ")")
+	case "23503": // foreign_key_violation
+		return CodeForeignKey, between(pe.Detail, "Key (", ")")
+	case "22001": // string_data_right_truncation
+		return CodeTooLong, pe.Column
+	}
+	return "", ""
+}
+
+func (sqliteDialect) Name() string { return "sqlite" }
+
+func (sqliteDialect) Tables(q queryer) ([]string, error) {
+	return scanStrings(q, `SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%'
+		ORDER BY name`)
+}
+
+func (s sqliteDialect) Columns(q queryer, table string) (tab Table, err error) {
+	var rows *sql.Rows
+	rows, err = q.Query("PRAGMA table_info(" + s.Quote(table) + ")")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	tab.Columns = make([]Col, 0)
+	tab.AutoIncrement = make(map[string]struct{})
+	var (
+		cid, notNull, pk int
+		name, colType    string
+		def              sql.NullString
+		rowid            string
+		// pk is the 1-based position of the column in the primary key
+		pkOrder = make(map[int]string)
+	)
+	for rows.Next() {
+		err = rows.Scan(&cid, &name, &colType, &notNull, &def, &pk)
+		if err != nil {
+			return
+		}
+		c := Col{
+			Name: name,
+			Type: normalizeType(colType),
+			Def:  strings.ToLower(colType),
+			Null: notNull == 0 && pk == 0,
+			PK:   pk > 0,
+		}
+		if c.PK {
+			pkOrder[pk] = name
+			if strings.EqualFold(colType, "integer") {
+				rowid = name
+			}
+		}
+		tab.Columns = append(tab.Columns, c)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	for i := 1; i <= len(pkOrder); i++ {
+		tab.PK = append(tab.PK, pkOrder[i])
+	}
+	// a single INTEGER PRIMARY KEY column is an alias for rowid
+	if len(tab.PK) == 1 && rowid != "" {
+		tab.AutoIncrement[rowid] = struct{}{}
+	}
+	return tab, nil
+}
+
+// ForeignKeys leaves RefColumns empty when the reference implicitly
+// points to the primary key of the parent table
+func (s sqliteDialect) ForeignKeys(q queryer, table string) ([]ForeignKey, error) {
+	return scanForeignKeys(q, table, `SELECT ? || '_fk_' || id, "from", "table", "to"
+		FROM pragma_foreign_key_list(?) ORDER BY id, seq`, table, table)
+}
+
+// FullTextIndexes returns nothing, FTS5 tables are separate virtual tables
+// and text columns are searched with LIKE
+func (sqliteDialect) FullTextIndexes(queryer, string) ([][]string, error) { return nil, nil }
+
+func (sqliteDialect) Placeholder(int) string { return "?" }
+
+func (sqliteDialect) Quote(ident string) string {
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
+
+func (sqliteDialect) Returning() bool { return false }
+
+func (sqliteDialect) LocksRows() bool { return false }
+
+// ErrorCode reads the message, SQLite drivers word constraint errors the
+// same way, e.g. "UNIQUE constraint failed: users.login", and the error type
+// of mattn/go-sqlite3 only exists in cgo builds
+func (sqliteDialect) ErrorCode(err error) (code, field string) {
+	const unique = "UNIQUE constraint failed: "
+	msg := err.Error()
+	if i := strings.Index(msg, unique); i >= 0 {
+		// modernc.org/sqlite adds the code, e.g. "users.login (2067)"
+		cols := msg[i+len(unique):]
+		if j := strings.Index(cols, " ("); j >= 0 {
+			cols = cols[:j]
+		}
+		// composite unique keys name all their columns
+		if j := strings.LastIndex(cols, "."); j >= 0 && !strings.Contains(cols, ",") {
+			field = cols[j+1:]
+		}
+		return CodeDuplicateKey, field
+	}
+	if strings.Contains(msg, "FOREIGN KEY constraint failed") {
+		return CodeForeignKey, ""
+	}
+	return "", ""
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error codes sent in the "code" field of error responses, clients should
+// rely on them rather than on the message
+const (
+	CodeBadRequest         = "bad_request"
+	CodeInvalidJSON        = "invalid_json"
+	CodeInvalidType        = "invalid_type"
+	CodeInvalidValue       = "invalid_value"
+	CodeInvalidKey         = "invalid_key"
+	CodeUnknownTable       = "unknown_table"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeReadOnly           = "read_only"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeDuplicateKey       = "duplicate_key"
+	CodeForeignKey         = "foreign_key"
+	CodeTooLong            = "too_long"
+	CodePreconditionFailed = "precondition_failed"
+	CodeUnsupportedMedia   = "unsupported_media_type"
+	CodeInvalidPatch       = "invalid_patch"
+	CodePatchConflict      = "patch_conflict"
+	CodeInvalidCSV         = "invalid_csv"
+	CodeTooManyRequests    = "too_many_requests"
+	CodeTimeout            = "timeout"
+	CodeInternal           = "internal"
+)
+
+// apiError is an error with everything needed to answer the request. It
+// may be wrapped with fmt.Errorf("...: %w"), the response then carries the
+// outer message and the status, code and field of the apiError.
+type apiError struct {
+	status int
+	code   string
+	field  string
+	msg    string
+	// err is the sentinel the error stands for, if any
+	err error
+}
+
+func (e *apiError) Error() string { return e.msg }
+
+func (e *apiError) Unwrap() error { return e.err }
+
+// fieldError reports a value of a column that can't be accepted
+func fieldError(col Col, err error) error {
+	if errors.Is(err, errorInvalidValue) {
+		return &apiError{
+			status: http.StatusUnprocessableEntity,
+			code:   CodeInvalidValue,
+			field:  col.Name,
+			msg:    fmt.Sprintf("field %s have invalid value", col.Name),
+			err:    errorInvalidValue,
+		}
+	}
+	return &apiError{
+		status: http.StatusBadRequest,
+		code:   CodeInvalidType,
+		field:  col.Name,
+		msg:    fmt.Sprintf("field %s have invalid type", col.Name),
+		err:    errorInvalidType,
+	}
+}
+
+// badRequest is a problem with the request itself, the message is sent to
+// the client as it is
+func badRequest(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusBadRequest, code: CodeBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+func forbiddenError(field, msg string) error {
+	return &apiError{status: http.StatusForbidden, code: CodeForbidden, field: field, msg: msg, err: errorForbidden}
+}
+
+var (
+	errorUnknownTable   = &apiError{status: http.StatusNotFound, code: CodeUnknownTable, msg: "unknown table"}
+	errorRecordNotFound = &apiError{status: http.StatusNotFound, code: CodeNotFound, msg: "record not found"}
+	errorInvalidJSON    = &apiError{status: http.StatusBadRequest, code: CodeInvalidJSON, msg: "invalid json"}
+	errorUnknownPath    = &apiError{status: http.StatusNotFound, code: CodeNotFound, msg: "unknown path"}
+	errorBadMethod      = &apiError{status: http.StatusMethodNotAllowed, code: CodeMethodNotAllowed, msg: "method not allowed"}
+	// errorNothingToUpdate is returned for an update without any column
+	// that can be set
+	errorNothingToUpdate = &apiError{status: http.StatusBadRequest, code: CodeBadRequest, msg: "nothing to update"}
+	// errorObjectExpected is returned for a JSON body that is not an object
+	errorObjectExpected = &apiError{status: http.StatusBadRequest, code: CodeInvalidJSON, msg: "object expected"}
+	// errorStatementTimeout answers requests whose statements ran longer
+	// than WithStatementTimeout allows
+	errorStatementTimeout = &apiError{status: http.StatusGatewayTimeout, code: CodeTimeout, msg: "statement timeout"}
+)
+
+// sentinelErrors gives the status and code of the errors returned by the
+// rest of the package
+var sentinelErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{errorInternal, http.StatusInternalServerError, CodeInternal},
+	{errorUnauthorized, http.StatusUnauthorized, CodeUnauthorized},
+	{errorForbidden, http.StatusForbidden, CodeForbidden},
+	{errorInvalidKey, http.StatusBadRequest, CodeInvalidKey},
+	{errorNoPrimaryKey, http.StatusBadRequest, CodeInvalidKey},
+	{errorReadOnlyTable, http.StatusMethodNotAllowed, CodeReadOnly},
+}
+
+// toAPIError finds out how to answer with err. Unknown errors are internal,
+// their message may tell about the database and is not sent.
+func toAPIError(err error) *apiError {
+	var ae *apiError
+	if errors.As(err, &ae) {
+		return &apiError{status: ae.status, code: ae.code, field: ae.field, msg: err.Error(), err: err}
+	}
+	for _, s := range sentinelErrors {
+		if errors.Is(err, s.err) {
+			return &apiError{status: s.status, code: s.code, msg: err.Error(), err: err}
+		}
+	}
+	return &apiError{status: http.StatusInternalServerError, code: CodeInternal, msg: errorInternal.Error(), err: err}
+}
+
+// dbError turns constraint violations reported by the driver into client
+// errors, anything else is internal
+func (d *DbExplorer) dbError(err error) error {
+	var ae *apiError
+	if errors.As(err, &ae) {
+		return err
+	}
+	code, field := d.dialect.ErrorCode(err)
+	switch code {
+	case CodeDuplicateKey:
+		return &apiError{status: http.StatusConflict, code: code, field: field, msg: "duplicate key", err: err}
+	case CodeForeignKey:
+		return &apiError{status: http.StatusConflict, code: code, field: field, msg: "foreign key constraint failed", err: err}
+	case CodeTooLong:
+		return &apiError{status: http.StatusUnprocessableEntity, code: code, field: field, msg: "value is too long", err: err}
+	}
+	return errorInternal
+}
+
+func writeError(w http.ResponseWriter, err error) (e error) {
+	ae := toAPIError(err)
+	resp := finalResponse{Error: ae.msg, Code: ae.code, Field: ae.field}
+	bs, e := json.Marshal(resp)
+	if e != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(ae.status)
+	w.Write(bs)
+	return
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+func TestDialectErrorCodes(t *testing.T) {
+	cases := []struct {
+		dialect Dialect
+		err     error
+		code    string
+		field   string
+	}{
+		{mysqlDialect{}, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'rvasily' for key 'login'"}, CodeDuplicateKey, ""},
+		{mysqlDialect{}, &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails (`golang`.`posts`, CONSTRAINT `posts_ibfk_1` FOREIGN KEY (`user_id`) REFERENCES `users` (`user_id`))"}, CodeForeignKey, "user_id"},
+		{mysqlDialect{}, &mysql.MySQLError{Number: 1406, Message: "Data too long for column 'title' at row 1"}, CodeTooLong, "title"},
+		{mysqlDialect{}, &mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax"}, "", ""},
+		{postgresDialect{}, &pq.Error{Code: "23505", Detail: "Key (login)=(rvasily) already exists."}, CodeDuplicateKey, "login"},
+		{postgresDialect{}, &pq.Error{Code: "23503", Detail: `Key (user_id)=(5) is not present in table "users".`}, CodeForeignKey, "user_id"},
+		{postgresDialect{}, &pq.Error{Code: "22001"}, CodeTooLong, ""},
+		{sqliteDialect{}, errorInternal, "", ""},
+		{sqliteDialect{}, errors.New("UNIQUE constraint failed: users.login"), CodeDuplicateKey, "login"},
+		{sqliteDialect{}, errors.New("constraint failed: UNIQUE constraint failed: users.login (2067)"), CodeDuplicateKey, "login"},
+		{sqliteDialect{}, errors.New("UNIQUE constraint failed: order_items.order_id, order_items.item_id"), CodeDuplicateKey, ""},
+		{sqliteDialect{}, errors.New("FOREIGN KEY constraint failed"), CodeForeignKey, ""},
+	}
+	for i, c := range cases {
+		code, field := c.dialect.ErrorCode(c.err)
+		if code != c.code || field != c.field {
+			t.Errorf("case %d [%s]: expected %q %q, got %q %q", i, c.dialect.Name(), c.code, c.field, code, field)
+		}
+	}
+}
+
+func TestToAPIError(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   string
+		msg    string
+	}{
+		// driver and other unexpected errors don't reach the client
+		{&mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax near 'secret'"}, http.StatusInternalServerError, CodeInternal, "internal error"},
+		{badRequest("unknown column %s", "x"), http.StatusBadRequest, CodeBadRequest, "unknown column x"},
+		{fmt.Errorf("operation 2: %w", badRequest("unknown table")), http.StatusBadRequest, CodeBadRequest, "operation 2: unknown table"},
+		{errorInvalidCursor, http.StatusBadRequest, CodeBadRequest, "invalid cursor"},
+		{errorInvalidKey, http.StatusBadRequest, CodeInvalidKey, "invalid key"},
+	}
+	for i, c := range cases {
+		ae := toAPIError(c.err)
+		if ae.status != c.status || ae.code != c.code || ae.msg != c.msg {
+			t.Errorf("case %d: expected %d %s %q, got %d %s %q", i, c.status, c.code, c.msg, ae.status, ae.code, ae.msg)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, []string{
+			`CREATE UNIQUE INDEX users_login ON users (login);`,
+			`CREATE TABLE posts (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id int NOT NULL,
+  title varchar(255) NOT NULL,
+  FOREIGN KEY (user_id) REFERENCES users (user_id)
+);`,
+		})
+
+		// SQLite names the column of a duplicate key and MySQL only the index,
+		// MySQL names the column of a foreign key and SQLite doesn't
+		duplicate := CR{"error": "duplicate key", "code": "duplicate_key", "field": "login"}
+		foreignKey := CR{"error": "foreign key constraint failed", "code": "foreign_key"}
+		if b.name != "sqlite" {
+			duplicate = CR{"error": "duplicate key", "code": "duplicate_key"}
+			foreignKey = CR{"error": "foreign key constraint failed", "code": "foreign_key", "field": "user_id"}
+		}
+
+		s.run(t, []Case{
+			Case{
+				Path:   "/users/",
+				Method: http.MethodPut,
+				Status: http.StatusConflict,
+				Body:   CR{"login": "rvasily", "password": "", "email": "", "info": ""},
+				Result: duplicate,
+			},
+			Case{
+				Path:   "/posts/",
+				Method: http.MethodPut,
+				Status: http.StatusConflict,
+				Body:   CR{"user_id": 42, "title": "orphan"},
+				Result: foreignKey,
+			},
+			// an update that sets nothing is a client mistake
+			Case{
+				Path:   "/users/1",
+				Method: http.MethodPost,
+				Status: http.StatusBadRequest,
+				Body:   CR{},
+				Result: CR{"error": "nothing to update", "code": "bad_request"},
+			},
+			Case{
+				Path:   "/users/1",
+				Method: http.MethodPost,
+				Status: http.StatusBadRequest,
+				Body:   CR{"unknown": "x"},
+				Result: CR{"error": "nothing to update", "code": "bad_request"},
+			},
+			Case{
+				Path:   "/posts/",
+				Method: http.MethodPut,
+				Body:   CR{"user_id": 1, "title": "first"},
+				Result: CR{"response": CR{"id": 1}},
+			},
+			Case{
+				Path:   "/users/1",
+				Method: http.MethodDelete,
+				Status: http.StatusConflict,
+				Result: foreignKey,
+			},
+			Case{
+				Path:   "/items/1/posts/1",
+				Status: http.StatusNotFound,
+				Result: CR{"error": "unknown path", "code": "not_found"},
+			},
+			Case{
+				Path:   "/items/1/2",
+				Method: http.MethodPut,
+				Status: http.StatusNotFound,
+				Result: CR{"error": "unknown path", "code": "not_found"},
+			},
+			Case{
+				Path:   "/items/1",
+				Method: http.MethodOptions,
+				Status: http.StatusMethodNotAllowed,
+				Result: CR{"error": "method not allowed", "code": "method_not_allowed"},
+			},
+			Case{
+				Path:   "/items/",
+				Method: http.MethodPut,
+				Status: http.StatusBadRequest,
+				Body:   []interface{}{CR{"title": "ok", "description": ""}, 42},
+				Result: CR{"error": "record 1: object expected", "code": "invalid_json"},
+			},
+		})
+
+		for _, path := range []string{"/items/", "/items/1"} {
+			method := http.MethodPut
+			if path == "/items/1" {
+				method = http.MethodPost
+			}
+			req, _ := http.NewRequest(method, s.URL+path, strings.NewReader(`{"title": `))
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var result CR
+			json.NewDecoder(resp.Body).Decode(&result)
+			resp.Body.Close()
+			expected := CR{"error": "invalid json", "code": "invalid_json"}
+			if resp.StatusCode != http.StatusBadRequest || !reflect.DeepEqual(result, expected) {
+				t.Errorf("[%s %s] expected 400 %v, got %d %v", method, path, expected, resp.StatusCode, result)
+			}
+		}
+	})
+}
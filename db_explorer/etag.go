@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var errorPreconditionFailed = &apiError{status: http.StatusPreconditionFailed, code: CodePreconditionFailed, msg: "record was changed"}
+
+// WithVersionColumn makes the column, in the tables that have it, the ETag
+// of a record instead of a hash of the whole record. An integer version
+// column is incremented by every update and If-Match is checked in the
+// UPDATE itself, so concurrent updates can't both succeed.
+func WithVersionColumn(name string) Option {
+	return func(d *DbExplorer) {
+		d.version = name
+	}
+}
+
+func (d *DbExplorer) versionColumn(table string) (Col, bool) {
+	if d.version == "" {
+		return Col{}, false
+	}
+	return d.columns[table].column(d.version)
+}
+
+// recordETag is computed from the full record as it is in the database,
+// before hidden columns are removed and relations expanded
+func (d *DbExplorer) recordETag(table string, record map[string]interface{}) string {
+	if c, ok := d.versionColumn(table); ok && record[c.Name] != nil {
+		return `"` + fmt.Sprint(record[c.Name]) + `"`
+	}
+	bs, _ := json.Marshal(record)
+	sum := sha256.Sum256(bs)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches checks a list of ETags from If-Match or If-None-Match, weak
+// ETags are compared as strong ones
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// lockRow reads the record with the key and, where the dialect can, locks it
+// until the transaction ends so that nobody changes it in between
+func (d *DbExplorer) lockRow(q queryer, table string, key []interface{}) ([]map[string]interface{}, error) {
+	query, args := d.keySQL(table, key)
+	if d.dialect.LocksRows() {
+		query += " FOR UPDATE"
+	}
+	return d.selectRows(q, d.columns[table].Columns, query, args)
+}
+
+// checkIfMatch compares If-Match with the record as the transaction sees
+// it, a missing record never matches and a soft deleted one is not found.
+// The record stays locked, a concurrent update with the same ETag waits and
+// then fails the check.
+func (d *DbExplorer) checkIfMatch(q queryer, table string, key []interface{}, ifMatch string) (current map[string]interface{}, err error) {
+	if ifMatch == "" {
+		return nil, nil
+	}
+	rows, err := d.lockRow(q, table, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > 0 && d.columns[table].isDeleted(rows[0]) {
+		return nil, errorRecordNotFound
+	}
+	if len(rows) == 0 || !etagMatches(ifMatch, d.recordETag(table, rows[0])) {
+		return nil, errorPreconditionFailed
+	}
+	return rows[0], nil
+}
+
+// versionConditions makes an update or delete apply only to the version
+// of the record If-Match was checked against
+func (d *DbExplorer) versionConditions(table string, current map[string]interface{}) []condition {
+	c, ok := d.versionColumn(table)
+	if !ok || current == nil || current[c.Name] == nil || d.types.lookup(c).Kind() != "integer" {
+		return nil
+	}
+	return []condition{{col: c, op: "eq", value: current[c.Name]}}
+}
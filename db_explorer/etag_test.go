@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// doWithHeaders sends a request with extra headers, which runCases can't do
+func doWithHeaders(t *testing.T, method, url string, headers map[string]string, body interface{}) (*http.Response, CR) {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(reqBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	bs, _ := ioutil.ReadAll(resp.Body)
+	var result CR
+	if len(bs) > 0 {
+		if err = json.Unmarshal(bs, &result); err != nil {
+			t.Fatalf("[%s %s] cant unpack json: %v", method, url, err)
+		}
+	}
+	return resp, result
+}
+
+func TestETag(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, []string{
+			`CREATE TABLE docs (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title varchar(255) NOT NULL,
+  version int NOT NULL DEFAULT 1
+);`,
+			`INSERT INTO docs (title) VALUES ('draft')`,
+		}, WithVersionColumn("version"))
+
+		// hash based ETags for a table without the version column
+		resp, _ := doWithHeaders(t, http.MethodGet, s.URL+"/items/1", nil, nil)
+		etag := resp.Header.Get("ETag")
+		if etag == "" {
+			t.Fatalf("no ETag for /items/1")
+		}
+		resp, _ = doWithHeaders(t, http.MethodGet, s.URL+"/items/1", map[string]string{"If-None-Match": etag}, nil)
+		if resp.StatusCode != http.StatusNotModified {
+			t.Errorf("If-None-Match with the current ETag: expected 304, got %d", resp.StatusCode)
+		}
+
+		resp, result := doWithHeaders(t, http.MethodPost, s.URL+"/items/1", map[string]string{"If-Match": etag}, CR{"title": "first editor"})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("If-Match with the current ETag: expected 200, got %d %v", resp.StatusCode, result)
+		}
+		newETag := resp.Header.Get("ETag")
+		if newETag == "" || newETag == etag {
+			t.Errorf("update must return the new ETag, got %q", newETag)
+		}
+
+		// the second editor still has the old ETag
+		resp, result = doWithHeaders(t, http.MethodPost, s.URL+"/items/1", map[string]string{"If-Match": etag}, CR{"title": "second editor"})
+		if resp.StatusCode != http.StatusPreconditionFailed || result["code"] != CodePreconditionFailed {
+			t.Errorf("If-Match with a stale ETag: expected 412, got %d %v", resp.StatusCode, result)
+		}
+		resp, result = doWithHeaders(t, http.MethodDelete, s.URL+"/items/1", map[string]string{"If-Match": etag}, nil)
+		if resp.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("DELETE with a stale ETag: expected 412, got %d %v", resp.StatusCode, result)
+		}
+		resp, _ = doWithHeaders(t, http.MethodGet, s.URL+"/items/1", map[string]string{"If-None-Match": etag}, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("If-None-Match with a stale ETag: expected 200, got %d", resp.StatusCode)
+		}
+		resp, result = doWithHeaders(t, http.MethodDelete, s.URL+"/items/1", map[string]string{"If-Match": newETag}, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("DELETE with the current ETag: expected 200, got %d %v", resp.StatusCode, result)
+		}
+
+		// the version column is the ETag and goes up with every update
+		resp, _ = doWithHeaders(t, http.MethodGet, s.URL+"/docs/1", nil, nil)
+		if etag = resp.Header.Get("ETag"); etag != `"1"` {
+			t.Fatalf("expected ETag \"1\", got %q", etag)
+		}
+		resp, _ = doWithHeaders(t, http.MethodPost, s.URL+"/docs/1", map[string]string{"If-Match": `"1"`}, CR{"title": "final"})
+		if etag = resp.Header.Get("ETag"); resp.StatusCode != http.StatusOK || etag != `"2"` {
+			t.Errorf("expected 200 with ETag \"2\", got %d %q", resp.StatusCode, etag)
+		}
+		resp, _ = doWithHeaders(t, http.MethodPost, s.URL+"/docs/1", map[string]string{"If-Match": `"1"`}, CR{"title": "lost update"})
+		if resp.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("stale version: expected 412, got %d", resp.StatusCode)
+		}
+		resp, _ = doWithHeaders(t, http.MethodPost, s.URL+"/docs/100500", map[string]string{"If-Match": "*"}, CR{"title": "missing"})
+		if resp.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("If-Match * on a missing record: expected 412, got %d", resp.StatusCode)
+		}
+		// updates without If-Match still bump the version
+		doWithHeaders(t, http.MethodPost, s.URL+"/docs/1", nil, CR{"title": "unconditional"})
+		s.run(t, []Case{
+			Case{
+				Path: "/docs/1",
+				Result: CR{
+					"response": CR{
+						"record": CR{"id": 1, "title": "unconditional", "version": 3},
+					},
+				},
+			},
+		})
+	})
+}
+
+// TestIfMatchConcurrent runs two updates with the same ETag side by side,
+// fakedb holds every statement back so that both read before either writes
+func TestIfMatchConcurrent(t *testing.T) {
+	db, err := sql.Open("fakedb", t.Name()+"?seed=docker-entrypoint-initdb.d/sample_db.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	for _, method := range []string{http.MethodPost, http.MethodPatch} {
+		setFakeDelay(t, 0)
+		resp, _ := doWithHeaders(t, http.MethodGet, ts.URL+"/items/1", nil, nil)
+		etag := resp.Header.Get("ETag")
+
+		setFakeDelay(t, 20*time.Millisecond)
+		statuses := make(chan int, 2)
+		for _, title := range []string{"first", "second"} {
+			go func(title string) {
+				resp, _ := doWithHeaders(t, method, ts.URL+"/items/1", map[string]string{"If-Match": etag}, CR{"title": title})
+				statuses <- resp.StatusCode
+			}(title)
+		}
+		got := map[int]int{}
+		got[<-statuses]++
+		got[<-statuses]++
+		if got[http.StatusOK] != 1 || got[http.StatusPreconditionFailed] != 1 {
+			t.Errorf("%s: expected one update and one 412, got %v", method, got)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+
+	// importBatchSize is the number of imported rows inserted in one transaction
+	importBatchSize = 500
+	// importMaxLine limits the length of a single NDJSON line
+	importMaxLine = 16 << 20
+)
+
+var errorInvalidCSV = &apiError{status: http.StatusBadRequest, code: CodeInvalidCSV, msg: "invalid csv"}
+
+var exportContentTypes = map[string]string{
+	formatCSV:    "text/csv; charset=utf-8",
+	formatNDJSON: "application/x-ndjson",
+}
+
+var importFormats = map[string]string{
+	"text/csv":             formatCSV,
+	"application/x-ndjson": formatNDJSON,
+	"application/ndjson":   formatNDJSON,
+}
+
+// importFormat returns the import format of a request body, an empty
+// string means a regular JSON body
+func importFormat(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return importFormats[mediaType]
+}
+
+// exportRows streams all the rows matching the list query as CSV or NDJSON.
+// Unlike a regular list the limit is not applied unless it is given, without
+// one an export of more than WithMaxLimit rows is refused.
+func (d *DbExplorer) exportRows(w http.ResponseWriter, r *http.Request, table string, lq listQuery, format string) (err error) {
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		return badRequest("unknown format %s", format)
+	}
+	params := r.URL.Query()
+	if lq.cursor {
+		return badRequest("cursor pagination is not supported with format")
+	}
+	if _, ok := params["expand"]; ok {
+		return badRequest("expand is not supported with format")
+	}
+	if _, ok := params["limit"]; !ok {
+		if lq.offset > 0 {
+			return badRequest("offset requires limit with format")
+		}
+		lq.limit = -1
+		if d.maxLimit > 0 {
+			if err = d.checkExportSize(table, lq); err != nil {
+				return err
+			}
+		}
+	}
+
+	columns, rows, err := d.queryList(table, lq)
+	if err != nil {
+		return errorInternal
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	if format == formatNDJSON {
+		enc := json.NewEncoder(w)
+		// the status and headers are already sent, a failed export can only
+		// be cut short
+		d.scanRows(columns, rows, func(record map[string]interface{}) error {
+			return enc.Encode(record)
+		})
+		return nil
+	}
+
+	cw := csv.NewWriter(w)
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.Name
+	}
+	cw.Write(header)
+	line := make([]string, len(columns))
+	d.scanRows(columns, rows, func(record map[string]interface{}) error {
+		for i, c := range columns {
+			line[i] = csvValue(record[c.Name])
+		}
+		return cw.Write(line)
+	})
+	cw.Flush()
+	return nil
+}
+
+// checkExportSize refuses an export without a limit that has more rows than
+// WithMaxLimit allows, it is not cut short without the client knowing
+func (d *DbExplorer) checkExportSize(table string, lq listQuery) error {
+	lq.limit, lq.offset = 1, d.maxLimit
+	columns, rows, err := d.queryList(table, lq)
+	if err != nil {
+		return errorInternal
+	}
+	defer rows.Close()
+	found, err := d.processSelectRows(columns, rows)
+	if err != nil {
+		return errorInternal
+	}
+	if len(found) > 0 {
+		return badRequest("more than %d rows to export, use limit and offset", d.maxLimit)
+	}
+	return nil
+}
+
+// csvValue writes NULL as an empty field and JSON values as their text
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case json.RawMessage:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// csvField converts a CSV field to the value a JSON body would have for
+// the column, an empty field of a nullable column is NULL
+func (d *DbExplorer) csvField(c Col, s string) interface{} {
+	if s == "" && c.Null {
+		return nil
+	}
+	switch d.types.lookup(c).Kind() {
+	case "integer", "number":
+		return json.Number(s)
+	case "boolean":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	case "string":
+		return s
+	default:
+		var v interface{}
+		if err := decodeJSON([]byte(s), &v); err == nil {
+			return v
+		}
+	}
+	return s
+}
+
+// importRow is a validated row waiting to be inserted
+type importRow struct {
+	line   int
+	record map[string]interface{}
+}
+
+// rowReader returns the raw record found at a line of the body. An apiError
+// is reported for the row and the import goes on, io.EOF ends the import
+// and any other error aborts it.
+type rowReader func() (line int, raw map[string]interface{}, err error)
+
+func (d *DbExplorer) csvRows(body io.Reader, tab Table) (rowReader, error) {
+	cr := csv.NewReader(body)
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return func() (int, map[string]interface{}, error) { return 0, nil, io.EOF }, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid csv header: %w", errorInvalidCSV)
+	}
+	header = append([]string{}, header...)
+	return func() (line int, raw map[string]interface{}, err error) {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			return 0, nil, err
+		}
+		var pe *csv.ParseError
+		if errors.As(err, &pe) {
+			return pe.StartLine, nil, errorInvalidCSV
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		line, _ = cr.FieldPos(0)
+		raw = make(map[string]interface{}, len(fields))
+		for i, name := range header {
+			if c, ok := tab.column(name); ok {
+				raw[name] = d.csvField(c, fields[i])
+			}
+		}
+		return line, raw, nil
+	}, nil
+}
+
+func ndjsonRows(body io.Reader) rowReader {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), importMaxLine)
+	line := 0
+	return func() (int, map[string]interface{}, error) {
+		for scanner.Scan() {
+			line++
+			bs := scanner.Bytes()
+			if len(bs) == 0 {
+				continue
+			}
+			var raw map[string]interface{}
+			if err := decodeJSON(bs, &raw); err != nil {
+				return line, nil, errorInvalidJSON
+			}
+			if raw == nil {
+				return line, nil, errorObjectExpected
+			}
+			return line, raw, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return 0, nil, badRequest("line %d: %v", line+1, err)
+		}
+		return 0, nil, io.EOF
+	}
+}
+
+// importRecords reads a CSV or NDJSON body of PUT /$table, every row is
+// validated on its own and the valid ones are inserted in batches. Rows
+// that fail are listed in the response instead of failing the import.
+func (d *DbExplorer) importRecords(w http.ResponseWriter, r *http.Request, p Principal, table, format string) (err error) {
+	defer r.Body.Close()
+	next := ndjsonRows(r.Body)
+	if format == formatCSV {
+		if next, err = d.csvRows(r.Body, d.columns[table]); err != nil {
+			return err
+		}
+	}
+
+	inserted := 0
+	report := make([]map[string]interface{}, 0)
+	batch := make([]importRow, 0, importBatchSize)
+	flush := func() error {
+		n, failed, err := d.insertBatch(table, batch)
+		inserted += n
+		report = append(report, failed...)
+		batch = batch[:0]
+		return err
+	}
+	for {
+		line, raw, err := next()
+		if err == io.EOF {
+			break
+		}
+		var record map[string]interface{}
+		if err == nil {
+			record, err = d.createRecord(p, table, raw)
+		}
+		var ae *apiError
+		if err != nil && (!errors.As(err, &ae) || errors.Is(err, errorInternal)) {
+			return err
+		}
+		if err != nil {
+			report = append(report, rowError(line, err))
+			continue
+		}
+		if err = d.checkWriteQuota(w, r, table, 1); err != nil {
+			// the rows read so far are inserted, the rest is not read
+			report = append(report, rowError(line, err))
+			break
+		}
+		batch = append(batch, importRow{line: line, record: record})
+		if len(batch) == importBatchSize {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err = flush(); err != nil {
+		return err
+	}
+	// rows failing in the database are found after the rows that are invalid
+	sort.SliceStable(report, func(i, j int) bool {
+		return report[i]["line"].(int) < report[j]["line"].(int)
+	})
+
+	writeResponse(w, finalResponse{Response: map[string]interface{}{"inserted": inserted, "errors": report}})
+	return
+}
+
+// insertBatch inserts the rows in one transaction, if any of them fails the
+// batch is rolled back and inserted again row by row to find the failing ones
+func (d *DbExplorer) insertBatch(table string, batch []importRow) (inserted int, failed []map[string]interface{}, err error) {
+	if len(batch) == 0 {
+		return 0, nil, nil
+	}
+	tx, err := d.begin()
+	if err != nil {
+		return 0, nil, errorInternal
+	}
+	ok := true
+	for _, row := range batch {
+		if _, err = d.insertRow(tx, table, row.record); err != nil {
+			ok = false
+			break
+		}
+	}
+	if ok {
+		if err = tx.Commit(); err != nil {
+			return 0, nil, errorInternal
+		}
+		return len(batch), nil, nil
+	}
+	tx.Rollback()
+
+	for _, row := range batch {
+		if _, err = d.insertRecord(table, row.record); err != nil {
+			err = d.dbError(err)
+			if errors.Is(err, errorInternal) {
+				return inserted, failed, err
+			}
+			failed = append(failed, rowError(row.line, err))
+			continue
+		}
+		inserted++
+	}
+	return inserted, failed, nil
+}
+
+func rowError(line int, err error) map[string]interface{} {
+	ae := toAPIError(err)
+	report := map[string]interface{}{"line": line, "error": ae.msg, "code": ae.code}
+	if ae.field != "" {
+		report["field"] = ae.field
+	}
+	return report
+}
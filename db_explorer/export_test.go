@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, []string{`CREATE UNIQUE INDEX users_login ON users (login);`})
+
+		exports := []struct {
+			query       string
+			contentType string
+			body        string
+		}{
+			{"format=csv&fields=id,title,updated", "text/csv; charset=utf-8",
+				"id,title,updated\n1,database/sql,rvasily\n2,memcache,\n"},
+			{"format=ndjson&fields=id,updated&order=-id&limit=1", "application/x-ndjson",
+				`{"id":2,"updated":null}` + "\n"},
+			{"format=csv&fields=title&where[title]=memcache", "text/csv; charset=utf-8",
+				"title\nmemcache\n"},
+		}
+		for _, c := range exports {
+			resp, err := client.Get(s.URL + "/items?" + c.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bs, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != c.contentType || string(bs) != c.body {
+				t.Errorf("[GET /items?%s] expected %s %q, got %d %s %q",
+					c.query, c.contentType, c.body, resp.StatusCode, resp.Header.Get("Content-Type"), bs)
+			}
+		}
+
+		// SQLite names the column of a duplicate key and MySQL only the index
+		duplicate := CR{"line": 3, "error": "duplicate key", "code": "duplicate_key", "field": "login"}
+		if b.name != "sqlite" {
+			duplicate = CR{"line": 3, "error": "duplicate key", "code": "duplicate_key"}
+		}
+		imports := []struct {
+			contentType string
+			body        string
+			result      CR
+		}{
+			{"text/csv", "login,password,email,info\n" +
+				"alice,secret,alice@example.com,hi\n" +
+				"rvasily,dup,dup@example.com,\n" +
+				"bob,secret,bob@example.com\n" +
+				"carol,secret,carol@example.com,\n",
+				CR{"response": CR{"inserted": 2, "errors": []CR{
+					duplicate,
+					CR{"line": 4, "error": "invalid csv", "code": "invalid_csv"},
+				}}}},
+			{"application/x-ndjson", `{"login": "dave", "password": "", "email": "", "info": "", "updated": null}` + "\n" +
+				"\n" +
+				`{"login": "eve", "password": 42, "email": "", "info": ""}` + "\n" +
+				`{"login": ` + "\n",
+				CR{"response": CR{"inserted": 1, "errors": []CR{
+					CR{"line": 3, "error": "field password have invalid type", "code": "invalid_type", "field": "password"},
+					CR{"line": 4, "error": "invalid json", "code": "invalid_json"},
+				}}}},
+		}
+		for _, c := range imports {
+			req, _ := http.NewRequest(http.MethodPut, s.URL+"/users/", strings.NewReader(c.body))
+			req.Header.Set("Content-Type", c.contentType)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bs, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			var result interface{}
+			if err = decodeJSON(bs, &result); err != nil {
+				t.Fatalf("cant unpack json: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK || !reflect.DeepEqual(jsonRoundTrip(result), jsonRoundTrip(c.result)) {
+				t.Errorf("[PUT /users/ %s] expected %v, got %d %s", c.contentType, c.result, resp.StatusCode, bs)
+			}
+		}
+
+		s.run(t, []Case{
+			Case{
+				Path:  "/users",
+				Query: "fields=user_id,login&order=user_id&limit=10",
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"user_id": 1, "login": "rvasily"},
+							CR{"user_id": 2, "login": "alice"},
+							CR{"user_id": 3, "login": "carol"},
+							CR{"user_id": 4, "login": "dave"},
+						},
+					},
+				},
+			},
+			Case{
+				Path:   "/items",
+				Query:  "format=xml",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "unknown format xml", "code": "bad_request"},
+			},
+			Case{
+				Path:   "/items",
+				Query:  "format=csv&cursor=",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "cursor pagination is not supported with format", "code": "bad_request"},
+			},
+		})
+	})
+}
@@ -0,0 +1,1411 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// fakedb is an in-process database/sql driver speaking the part of MySQL
+// that DbExplorer uses with mysqlDialect, so that tests run without a
+// server. The data source name is the name of the database, every
+// connection to the same name shares it, and ?seed=file runs a SQL script
+// when the database is first opened:
+//
+//	sql.Open("fakedb", "TestApis?seed=docker-entrypoint-initdb.d/sample_db.sql")
+//
+// Constraint violations fail with the *mysql.MySQLError the server would
+// return. Statements are atomic, transactions are not isolated: a rollback
+// puts back the tables the transaction changed. SELECT ... FOR UPDATE in a
+// transaction locks the whole database against other FOR UPDATE reads until
+// the transaction ends, coarser than row locks but enough to order writers
+// that read first. Setting the delay of a
+// database holds its statements back until their context ends, to test
+// timeouts.
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+var fakeDatabases = struct {
+	sync.Mutex
+	m map[string]*fakeDB
+}{m: make(map[string]*fakeDB)}
+
+type (
+	fakeDriver struct{}
+
+	fakeDB struct {
+		mu     sync.Mutex
+		name   string
+		tables map[string]*fakeTable
+		delay  time.Duration
+		// locked is held by a transaction that selected FOR UPDATE
+		locked sync.Mutex
+	}
+
+	fakeColumn struct {
+		name string
+		// typ is the type as SHOW COLUMNS tells it, base its name alone
+		typ, base     string
+		size, scale   int
+		values        []string
+		null          bool
+		def           interface{}
+		hasDefault    bool
+		autoIncrement bool
+		onUpdateNow   bool
+		comment       string
+	}
+	fakeIndex struct {
+		name             string
+		cols             []string
+		unique, fulltext bool
+	}
+	fakeForeignKey struct {
+		name     string
+		cols     []string
+		refTable string
+		refCols  []string
+		onDelete string
+	}
+	fakeTable struct {
+		name          string
+		cols          []fakeColumn
+		pk            []string
+		indexes       []fakeIndex
+		fks           []fakeForeignKey
+		rows          [][]interface{}
+		autoIncrement int64
+	}
+
+	fakeConn struct {
+		db *fakeDB
+		tx *fakeTx
+	}
+	fakeStmt struct {
+		conn   *fakeConn
+		st     fakeStatement
+		params int
+	}
+	fakeTx struct {
+		conn   *fakeConn
+		saved  map[string]*fakeTable
+		locked bool
+	}
+	// fakeChange keeps the tables a statement changes as they were before,
+	// to put them back when it fails
+	fakeChange struct {
+		db    *fakeDB
+		tx    *fakeTx
+		saved map[string]*fakeTable
+	}
+	fakeResult struct {
+		lastID, affected int64
+	}
+	fakeRows struct {
+		columns []string
+		rows    [][]interface{}
+		pos     int
+	}
+	// fakeSource is what a SELECT reads from
+	fakeSource struct {
+		cols []string
+		rows [][]interface{}
+	}
+)
+
+func fakeError(number uint16, format string, args ...interface{}) error {
+	return &mysql.MySQLError{Number: number, Message: fmt.Sprintf(format, args...)}
+}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	name, rawQuery, _ := strings.Cut(dsn, "?")
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	fakeDatabases.Lock()
+	defer fakeDatabases.Unlock()
+	db, ok := fakeDatabases.m[name]
+	if !ok {
+		db = &fakeDB{name: name, tables: make(map[string]*fakeTable)}
+		if seed := params.Get("seed"); seed != "" {
+			script, err := os.ReadFile(seed)
+			if err != nil {
+				return nil, err
+			}
+			if err = db.execScript(string(script)); err != nil {
+				return nil, fmt.Errorf("fakedb: seeding from %s: %w", seed, err)
+			}
+		}
+		fakeDatabases.m[name] = db
+	}
+	return &fakeConn{db: db}, nil
+}
+
+// execScript runs the statements of a script separated by semicolons
+func (db *fakeDB) execScript(script string) error {
+	toks, err := fakeTokenize(script)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range fakeSplitScript(toks) {
+		st, _, err := fakeParseTokens(script, stmt)
+		if err != nil {
+			return err
+		}
+		if _, err = db.exec(nil, st, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	st, params, err := fakeParse(query)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeStmt{conn: c, st: st, params: params}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, errors.New("fakedb: a transaction is already open")
+	}
+	c.tx = &fakeTx{conn: c, saved: make(map[string]*fakeTable)}
+	return c.tx, nil
+}
+
+func (tx *fakeTx) Commit() error {
+	tx.unlock()
+	tx.conn.tx = nil
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	defer tx.unlock()
+	db := tx.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for name, t := range tx.saved {
+		db.tables[name] = t
+	}
+	tx.conn.tx = nil
+	return nil
+}
+
+func (tx *fakeTx) unlock() {
+	if tx.locked {
+		tx.locked = false
+		tx.conn.db.locked.Unlock()
+	}
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return s.params
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.db.exec(s.conn.tx, s.st, args)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if sel, ok := s.st.(*fakeSelect); ok && sel.forUpdate && s.conn.tx != nil && !s.conn.tx.locked {
+		s.conn.db.locked.Lock()
+		s.conn.tx.locked = true
+	}
+	return s.conn.db.query(s.st, args)
+}
+
+func (s *fakeStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if err := s.conn.db.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.Exec(fakeValues(args))
+}
+
+func (s *fakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if err := s.conn.db.wait(ctx); err != nil {
+		return nil, err
+	}
+	return s.Query(fakeValues(args))
+}
+
+func fakeValues(named []driver.NamedValue) []driver.Value {
+	args := make([]driver.Value, len(named))
+	for i, nv := range named {
+		args[i] = nv.Value
+	}
+	return args
+}
+
+// wait holds a statement back by the delay of the database
+func (db *fakeDB) wait(ctx context.Context) error {
+	db.mu.Lock()
+	delay := db.delay
+	db.mu.Unlock()
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r fakeResult) LastInsertId() (int64, error) {
+	return r.lastID, nil
+}
+
+func (r fakeResult) RowsAffected() (int64, error) {
+	return r.affected, nil
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+// Next hands out text as []byte like the MySQL driver does
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows) {
+		return io.EOF
+	}
+	for i, v := range r.rows[r.pos] {
+		switch v := v.(type) {
+		case string:
+			dest[i] = []byte(v)
+		case []byte:
+			dest[i] = append([]byte{}, v...)
+		default:
+			dest[i] = v
+		}
+	}
+	r.pos++
+	return nil
+}
+
+func (t *fakeTable) colIndex(name string) (int, bool) {
+	for i, c := range t.cols {
+		if strings.EqualFold(c.name, name) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (t *fakeTable) addIndex(idx fakeIndex) {
+	if idx.name == "" && len(idx.cols) > 0 {
+		idx.name = idx.cols[0]
+	}
+	t.indexes = append(t.indexes, idx)
+}
+
+// clone copies the table so that it can be put back, rows are never
+// changed in place so they are shared
+func (t *fakeTable) clone() *fakeTable {
+	c := *t
+	c.rows = append([][]interface{}{}, t.rows...)
+	return &c
+}
+
+func (t *fakeTable) source() fakeSource {
+	cols := make([]string, len(t.cols))
+	for i, c := range t.cols {
+		cols[i] = c.name
+	}
+	return fakeSource{cols: cols, rows: t.rows}
+}
+
+func (s fakeSource) index() map[string]int {
+	index := make(map[string]int, len(s.cols))
+	for i, c := range s.cols {
+		index[strings.ToLower(c)] = i
+	}
+	return index
+}
+
+func (db *fakeDB) table(name string) (*fakeTable, error) {
+	t, ok := db.tables[name]
+	if !ok {
+		return nil, fakeError(1146, "Table '%s.%s' doesn't exist", db.name, name)
+	}
+	return t, nil
+}
+
+func (ch *fakeChange) touch(t *fakeTable) {
+	if _, ok := ch.saved[t.name]; !ok {
+		ch.saved[t.name] = t.clone()
+	}
+	if ch.tx != nil {
+		if _, ok := ch.tx.saved[t.name]; !ok {
+			ch.tx.saved[t.name] = t.clone()
+		}
+	}
+}
+
+func (ch *fakeChange) undo() {
+	for name, t := range ch.saved {
+		ch.db.tables[name] = t
+	}
+}
+
+func (db *fakeDB) exec(tx *fakeTx, st fakeStatement, args []driver.Value) (res driver.Result, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	ch := &fakeChange{db: db, tx: tx, saved: make(map[string]*fakeTable)}
+	switch st := st.(type) {
+	case *fakeInsert:
+		res, err = db.insert(ch, st, args)
+	case *fakeUpdate:
+		res, err = db.update(ch, st, args)
+	case *fakeDelete:
+		res, err = db.delete(ch, st, args)
+	case *fakeCreate:
+		return db.create(st)
+	case *fakeDrop:
+		return db.drop(st)
+	case *fakeCreateIndex:
+		return db.createIndex(st)
+	case *fakeAddColumn:
+		return db.addColumn(st)
+	case fakeSet:
+		return fakeResult{}, nil
+	default:
+		_, err = db.queryLocked(st, args)
+		return fakeResult{}, err
+	}
+	if err != nil {
+		ch.undo()
+	}
+	return res, err
+}
+
+func (db *fakeDB) query(st fakeStatement, args []driver.Value) (driver.Rows, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.queryLocked(st, args)
+}
+
+func (db *fakeDB) queryLocked(st fakeStatement, args []driver.Value) (*fakeRows, error) {
+	switch st := st.(type) {
+	case *fakeSelect:
+		return db.selectRows(st, args)
+	case fakeShowTables:
+		names := make([]string, 0, len(db.tables))
+		for name := range db.tables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		rows := &fakeRows{columns: []string{"Tables_in_" + db.name}}
+		for _, name := range names {
+			rows.rows = append(rows.rows, []interface{}{name})
+		}
+		return rows, nil
+	case fakeShowColumns:
+		return db.showColumns(st.table)
+	}
+	return nil, errors.New("fakedb: the statement returns no rows")
+}
+
+func (db *fakeDB) create(st *fakeCreate) (driver.Result, error) {
+	if _, ok := db.tables[st.table.name]; ok {
+		if st.ifNotExists {
+			return fakeResult{}, nil
+		}
+		return nil, fakeError(1050, "Table '%s' already exists", st.table.name)
+	}
+	t := *st.table
+	t.rows = nil
+	db.tables[t.name] = &t
+	return fakeResult{}, nil
+}
+
+func (db *fakeDB) createIndex(st *fakeCreateIndex) (driver.Result, error) {
+	t, err := db.table(st.table)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range st.index.cols {
+		if _, ok := t.colIndex(name); !ok {
+			return nil, fakeError(1072, "Key column '%s' doesn't exist in table", name)
+		}
+	}
+	c := t.clone()
+	c.indexes = append(append([]fakeIndex{}, t.indexes...), st.index)
+	for i, row := range c.rows {
+		if err = db.checkRow(c, row, i); err != nil {
+			return nil, err
+		}
+	}
+	db.tables[t.name] = c
+	return fakeResult{}, nil
+}
+
+// addColumn adds the column to the end, existing rows get its default
+func (db *fakeDB) addColumn(st *fakeAddColumn) (driver.Result, error) {
+	t, err := db.table(st.table)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := t.colIndex(st.column.name); ok {
+		return nil, fakeError(1060, "Duplicate column name '%s'", st.column.name)
+	}
+	def, err := st.column.coerce(st.column.def)
+	if err != nil {
+		return nil, err
+	}
+	c := t.clone()
+	c.cols = append(append([]fakeColumn{}, t.cols...), st.column)
+	for i, row := range t.rows {
+		c.rows[i] = append(append([]interface{}{}, row...), def)
+	}
+	db.tables[t.name] = c
+	return fakeResult{}, nil
+}
+
+func (db *fakeDB) drop(st *fakeDrop) (driver.Result, error) {
+	for _, name := range st.tables {
+		if _, ok := db.tables[name]; !ok && !st.ifExists {
+			return nil, fakeError(1051, "Unknown table '%s.%s'", db.name, name)
+		}
+		delete(db.tables, name)
+	}
+	return fakeResult{}, nil
+}
+
+// fakeKind groups column types by the values they store
+func fakeKind(base string) string {
+	switch base {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "year", "bit", "bool", "boolean":
+		return "integer"
+	case "float", "double", "real":
+		return "float"
+	case "decimal", "numeric", "dec", "fixed":
+		return "decimal"
+	case "blob", "tinyblob", "mediumblob", "longblob", "binary", "varbinary":
+		return "binary"
+	}
+	return "text"
+}
+
+// coerce converts a value to what the column stores, failing like MySQL
+// in strict mode does
+func (c fakeColumn) coerce(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if _, ok := v.(fakeNow); ok {
+		v = time.Now().Format(fakeDatetimeLayout)
+	}
+	invalid := func() error {
+		return fakeError(1366, "Incorrect %s value: '%s' for column '%s' at row 1", c.base, fakeString(v), c.name)
+	}
+	switch fakeKind(c.base) {
+	case "integer":
+		var n int64
+		switch x := v.(type) {
+		case int64:
+			n = x
+		case float64:
+			n = int64(math.Round(x))
+		default:
+			s := strings.TrimSpace(fakeString(x))
+			i, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				f, ferr := strconv.ParseFloat(s, 64)
+				if ferr != nil {
+					return nil, invalid()
+				}
+				i = int64(math.Round(f))
+			}
+			n = i
+		}
+		if n < 0 && strings.Contains(c.typ, "unsigned") {
+			return nil, fakeError(1264, "Out of range value for column '%s' at row 1", c.name)
+		}
+		return n, nil
+	case "float", "decimal":
+		var f float64
+		switch x := v.(type) {
+		case int64, float64:
+			f = fakeFloat(x)
+		default:
+			var err error
+			if f, err = strconv.ParseFloat(strings.TrimSpace(fakeString(x)), 64); err != nil {
+				return nil, invalid()
+			}
+		}
+		if fakeKind(c.base) == "float" {
+			return f, nil
+		}
+		return strconv.FormatFloat(f, 'f', c.scale, 64), nil
+	case "binary":
+		return []byte(fakeString(v)), nil
+	}
+	s := fakeString(v)
+	switch c.base {
+	case "date":
+		if len(s) > 10 && s[10] == ' ' {
+			s = s[:10]
+		}
+	case "enum":
+		for _, value := range c.values {
+			if strings.EqualFold(value, s) {
+				return value, nil
+			}
+		}
+		return nil, fakeError(1265, "Data truncated for column '%s' at row 1", c.name)
+	}
+	if c.size > 0 && utf8.RuneCountInString(s) > c.size {
+		return nil, fakeError(1406, "Data too long for column '%s' at row 1", c.name)
+	}
+	return s, nil
+}
+
+func (db *fakeDB) insert(ch *fakeChange, st *fakeInsert, args []driver.Value) (driver.Result, error) {
+	t, err := db.table(st.table)
+	if err != nil {
+		return nil, err
+	}
+	ch.touch(t)
+	targets := make([]int, len(st.cols))
+	for i, name := range st.cols {
+		var ok bool
+		if targets[i], ok = t.colIndex(name); !ok {
+			return nil, fakeError(1054, "Unknown column '%s' in 'field list'", name)
+		}
+	}
+	if len(st.cols) == 0 {
+		for i := range t.cols {
+			targets = append(targets, i)
+		}
+	}
+	res := fakeResult{}
+	env := &fakeEnv{db: db, args: args}
+	for _, values := range st.rows {
+		if len(values) != len(targets) {
+			return nil, fakeError(1136, "Column count doesn't match value count at row 1")
+		}
+		row := make([]interface{}, len(t.cols))
+		given := make([]bool, len(t.cols))
+		for i, e := range values {
+			v, err := e.eval(env)
+			if err != nil {
+				return nil, err
+			}
+			if row[targets[i]], err = t.cols[targets[i]].coerce(v); err != nil {
+				return nil, err
+			}
+			given[targets[i]] = true
+		}
+		for i, c := range t.cols {
+			switch {
+			case c.autoIncrement && (row[i] == nil || row[i] == int64(0)):
+				t.autoIncrement++
+				row[i] = t.autoIncrement
+				if res.lastID == 0 {
+					res.lastID = t.autoIncrement
+				}
+			case c.autoIncrement:
+				if n := row[i].(int64); n > t.autoIncrement {
+					t.autoIncrement = n
+				}
+				if res.lastID == 0 {
+					res.lastID = row[i].(int64)
+				}
+			case given[i]:
+				if row[i] == nil && !c.null {
+					return nil, fakeError(1048, "Column '%s' cannot be null", c.name)
+				}
+			case c.hasDefault:
+				if row[i], err = c.coerce(c.def); err != nil {
+					return nil, err
+				}
+			case !c.null:
+				return nil, fakeError(1364, "Field '%s' doesn't have a default value", c.name)
+			}
+		}
+		if err = db.checkRow(t, row, -1); err != nil {
+			return nil, err
+		}
+		t.rows = append(t.rows, row)
+		res.affected++
+	}
+	return res, nil
+}
+
+func (db *fakeDB) update(ch *fakeChange, st *fakeUpdate, args []driver.Value) (driver.Result, error) {
+	t, err := db.table(st.table)
+	if err != nil {
+		return nil, err
+	}
+	ch.touch(t)
+	targets := make([]int, len(st.sets))
+	for i, set := range st.sets {
+		var ok bool
+		if targets[i], ok = t.colIndex(set.col); !ok {
+			return nil, fakeError(1054, "Unknown column '%s' in 'field list'", set.col)
+		}
+	}
+	matched, err := db.matching(t, st.where, args)
+	if err != nil {
+		return nil, err
+	}
+	res := fakeResult{}
+	for _, i := range matched {
+		old := t.rows[i]
+		row := append([]interface{}{}, old...)
+		env := &fakeEnv{db: db, cols: t.source().index(), row: old, args: args}
+		given := make([]bool, len(t.cols))
+		for j, set := range st.sets {
+			v, err := set.expr.eval(env)
+			if err != nil {
+				return nil, err
+			}
+			c := t.cols[targets[j]]
+			if row[targets[j]], err = c.coerce(v); err != nil {
+				return nil, err
+			}
+			if row[targets[j]] == nil && !c.null {
+				return nil, fakeError(1048, "Column '%s' cannot be null", c.name)
+			}
+			given[targets[j]] = true
+		}
+		// like the MySQL driver by default, only rows that change count
+		if reflect.DeepEqual(old, row) {
+			continue
+		}
+		for j, c := range t.cols {
+			if c.onUpdateNow && !given[j] {
+				row[j], _ = c.coerce(fakeNow{})
+			}
+			if c.autoIncrement && row[j] != nil && row[j].(int64) > t.autoIncrement {
+				t.autoIncrement = row[j].(int64)
+			}
+		}
+		if err = db.checkRow(t, row, i); err != nil {
+			return nil, err
+		}
+		if err = db.checkReferenced(t, [][]interface{}{old}, [][]interface{}{row}); err != nil {
+			return nil, err
+		}
+		t.rows[i] = row
+		res.affected++
+	}
+	return res, nil
+}
+
+func (db *fakeDB) delete(ch *fakeChange, st *fakeDelete, args []driver.Value) (driver.Result, error) {
+	t, err := db.table(st.table)
+	if err != nil {
+		return nil, err
+	}
+	matched, err := db.matching(t, st.where, args)
+	if err != nil {
+		return nil, err
+	}
+	doomed := make(map[int]bool, len(matched))
+	for _, i := range matched {
+		doomed[i] = true
+	}
+	return fakeResult{affected: int64(len(matched))}, db.deleteRows(ch, t, doomed)
+}
+
+// matching returns the indexes of the rows where the condition holds
+func (db *fakeDB) matching(t *fakeTable, where fakeExpr, args []driver.Value) (matched []int, err error) {
+	env := &fakeEnv{db: db, cols: t.source().index(), args: args}
+	for i, row := range t.rows {
+		ok := true
+		if where != nil {
+			env.row = row
+			v, err := where.eval(env)
+			if err != nil {
+				return nil, err
+			}
+			ok, _ = fakeTruth(v)
+		}
+		if ok {
+			matched = append(matched, i)
+		}
+	}
+	return matched, nil
+}
+
+// deleteRows removes rows of t, the rows referencing them are dealt with
+// as their foreign keys say
+func (db *fakeDB) deleteRows(ch *fakeChange, t *fakeTable, doomed map[int]bool) error {
+	if len(doomed) == 0 {
+		return nil
+	}
+	ch.touch(t)
+	removed := make([][]interface{}, 0, len(doomed))
+	kept := make([][]interface{}, 0, len(t.rows))
+	for i, row := range t.rows {
+		if doomed[i] {
+			removed = append(removed, row)
+		} else {
+			kept = append(kept, row)
+		}
+	}
+	t.rows = kept
+	for _, child := range db.sortedTables() {
+		for _, fk := range child.fks {
+			if fk.refTable != t.name {
+				continue
+			}
+			cascade := make(map[int]bool)
+			for i, row := range child.rows {
+				if !fakeReferences(t, child, fk, row, removed) {
+					continue
+				}
+				switch fk.onDelete {
+				case "CASCADE":
+					cascade[i] = true
+				case "SET NULL":
+					ch.touch(child)
+					row = append([]interface{}{}, row...)
+					for _, name := range fk.cols {
+						j, _ := child.colIndex(name)
+						row[j] = nil
+					}
+					child.rows[i] = row
+				default:
+					return fakeForeignKeyError(1451, "Cannot delete or update a parent row", db.name, child.name, fk)
+				}
+			}
+			if err := db.deleteRows(ch, child, cascade); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (db *fakeDB) sortedTables() []*fakeTable {
+	names := make([]string, 0, len(db.tables))
+	for name := range db.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tables := make([]*fakeTable, len(names))
+	for i, name := range names {
+		tables[i] = db.tables[name]
+	}
+	return tables
+}
+
+func fakeForeignKeyError(number uint16, what, db, table string, fk fakeForeignKey) error {
+	return fakeError(number, "%s: a foreign key constraint fails (`%s`.`%s`, CONSTRAINT `%s` FOREIGN KEY (`%s`) REFERENCES `%s` (`%s`))",
+		what, db, table, fk.name, strings.Join(fk.cols, "`, `"), fk.refTable, strings.Join(fk.refCols, "`, `"))
+}
+
+// fakeReferences tells whether the row of child points by fk to one of
+// the rows of parent
+func fakeReferences(parent, child *fakeTable, fk fakeForeignKey, row []interface{}, parents [][]interface{}) bool {
+	for _, p := range parents {
+		match := true
+		for k, name := range fk.cols {
+			i, _ := child.colIndex(name)
+			j, _ := parent.colIndex(fk.refCols[k])
+			if row[i] == nil || p[j] == nil || fakeCompare(row[i], p[j]) != 0 {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRow checks the keys of a row about to be stored at position at,
+// -1 for a new one
+func (db *fakeDB) checkRow(t *fakeTable, row []interface{}, at int) error {
+	keys := make([]fakeIndex, 0, len(t.indexes)+1)
+	if len(t.pk) > 0 {
+		keys = append(keys, fakeIndex{name: "PRIMARY", cols: t.pk, unique: true})
+	}
+	keys = append(keys, t.indexes...)
+	for _, key := range keys {
+		if !key.unique {
+			continue
+		}
+		cols := make([]int, len(key.cols))
+		entry := make([]string, len(key.cols))
+		for i, name := range key.cols {
+			cols[i], _ = t.colIndex(name)
+			entry[i] = fakeString(row[cols[i]])
+		}
+		for i, other := range t.rows {
+			if i == at {
+				continue
+			}
+			same := true
+			for _, j := range cols {
+				if row[j] == nil || other[j] == nil || fakeCompare(row[j], other[j]) != 0 {
+					same = false
+					break
+				}
+			}
+			if same {
+				return fakeError(1062, "Duplicate entry '%s' for key '%s'", strings.Join(entry, "-"), key.name)
+			}
+		}
+	}
+	for _, fk := range t.fks {
+		parent, ok := db.tables[fk.refTable]
+		if !ok || !fakeReferences(parent, t, fk, row, parent.rows) {
+			null := false
+			for _, name := range fk.cols {
+				if i, _ := t.colIndex(name); row[i] == nil {
+					null = true
+				}
+			}
+			if !null {
+				return fakeForeignKeyError(1452, "Cannot add or update a child row", db.name, t.name, fk)
+			}
+		}
+	}
+	return nil
+}
+
+// checkReferenced fails when rows still referenced by other tables would
+// change their key
+func (db *fakeDB) checkReferenced(t *fakeTable, old, updated [][]interface{}) error {
+	for _, child := range db.sortedTables() {
+		for _, fk := range child.fks {
+			if fk.refTable != t.name {
+				continue
+			}
+			for k, row := range old {
+				changed := false
+				for _, name := range fk.refCols {
+					j, _ := t.colIndex(name)
+					if !reflect.DeepEqual(row[j], updated[k][j]) {
+						changed = true
+					}
+				}
+				if !changed {
+					continue
+				}
+				for _, crow := range child.rows {
+					if fakeReferences(t, child, fk, crow, [][]interface{}{row}) {
+						return fakeForeignKeyError(1451, "Cannot delete or update a parent row", db.name, child.name, fk)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (db *fakeDB) showColumns(table string) (*fakeRows, error) {
+	t, err := db.table(table)
+	if err != nil {
+		return nil, err
+	}
+	rows := &fakeRows{columns: []string{"Field", "Type", "Collation", "Null", "Key", "Default", "Extra", "Privileges", "Comment"}}
+	for _, c := range t.cols {
+		var collation, def interface{}
+		if fakeKind(c.base) == "text" {
+			collation = "utf8_general_ci"
+		}
+		switch {
+		case c.def == fakeNow{}:
+			def = "CURRENT_TIMESTAMP"
+		case c.def != nil:
+			def = fakeString(c.def)
+		}
+		null := "YES"
+		if !c.null {
+			null = "NO"
+		}
+		var extra []string
+		if c.autoIncrement {
+			extra = append(extra, "auto_increment")
+		}
+		if c.onUpdateNow {
+			extra = append(extra, "on update CURRENT_TIMESTAMP")
+		}
+		rows.rows = append(rows.rows, []interface{}{
+			c.name, c.typ, collation, null, t.keyKind(c.name), def,
+			strings.Join(extra, " "), "select,insert,update,references", c.comment,
+		})
+	}
+	return rows, nil
+}
+
+// keyKind is the Key of SHOW COLUMNS: PRI, UNI or MUL for the first column
+// of an index
+func (t *fakeTable) keyKind(col string) string {
+	for _, name := range t.pk {
+		if strings.EqualFold(name, col) {
+			return "PRI"
+		}
+	}
+	kind := ""
+	for _, idx := range t.indexes {
+		if !strings.EqualFold(idx.cols[0], col) {
+			continue
+		}
+		if idx.unique && len(idx.cols) == 1 {
+			return "UNI"
+		}
+		kind = "MUL"
+	}
+	for _, fk := range t.fks {
+		if strings.EqualFold(fk.cols[0], col) {
+			kind = "MUL"
+		}
+	}
+	return kind
+}
+
+// infoSchema builds the information_schema tables mysqlDialect reads
+func (db *fakeDB) infoSchema(name string) (src fakeSource, err error) {
+	switch strings.ToUpper(name) {
+	case "KEY_COLUMN_USAGE":
+		src.cols = []string{"CONSTRAINT_NAME", "TABLE_SCHEMA", "TABLE_NAME", "COLUMN_NAME", "ORDINAL_POSITION",
+			"REFERENCED_TABLE_SCHEMA", "REFERENCED_TABLE_NAME", "REFERENCED_COLUMN_NAME"}
+		for _, t := range db.sortedTables() {
+			for i, col := range t.pk {
+				src.rows = append(src.rows, []interface{}{"PRIMARY", db.name, t.name, col, int64(i + 1), nil, nil, nil})
+			}
+			for _, idx := range t.indexes {
+				if !idx.unique {
+					continue
+				}
+				for i, col := range idx.cols {
+					src.rows = append(src.rows, []interface{}{idx.name, db.name, t.name, col, int64(i + 1), nil, nil, nil})
+				}
+			}
+			for _, fk := range t.fks {
+				for i, col := range fk.cols {
+					src.rows = append(src.rows, []interface{}{fk.name, db.name, t.name, col, int64(i + 1), db.name, fk.refTable, fk.refCols[i]})
+				}
+			}
+		}
+	case "STATISTICS":
+		src.cols = []string{"TABLE_SCHEMA", "TABLE_NAME", "NON_UNIQUE", "INDEX_NAME", "SEQ_IN_INDEX", "COLUMN_NAME", "INDEX_TYPE"}
+		for _, t := range db.sortedTables() {
+			for i, col := range t.pk {
+				src.rows = append(src.rows, []interface{}{db.name, t.name, int64(0), "PRIMARY", int64(i + 1), col, "BTREE"})
+			}
+			for _, idx := range t.indexes {
+				kind := "BTREE"
+				if idx.fulltext {
+					kind = "FULLTEXT"
+				}
+				for i, col := range idx.cols {
+					src.rows = append(src.rows, []interface{}{db.name, t.name, int64(boolToInt(!idx.unique)), idx.name, int64(i + 1), col, kind})
+				}
+			}
+		}
+	default:
+		return src, fakeError(1109, "Unknown table '%s' in information_schema", name)
+	}
+	return src, nil
+}
+
+func (db *fakeDB) selectRows(st *fakeSelect, args []driver.Value) (*fakeRows, error) {
+	src := fakeSource{rows: [][]interface{}{{}}}
+	if schema, name, ok := strings.Cut(st.from, "."); ok && strings.EqualFold(schema, "information_schema") {
+		var err error
+		if src, err = db.infoSchema(name); err != nil {
+			return nil, err
+		}
+	} else if st.from != "" {
+		t, err := db.table(st.from)
+		if err != nil {
+			return nil, err
+		}
+		src = t.source()
+	}
+	cols := src.index()
+	env := &fakeEnv{db: db, cols: cols, args: args}
+
+	matched := make([][]interface{}, 0)
+	for _, row := range src.rows {
+		if st.where != nil {
+			env.row = row
+			v, err := st.where.eval(env)
+			if err != nil {
+				return nil, err
+			}
+			if ok, _ := fakeTruth(v); !ok {
+				continue
+			}
+		}
+		matched = append(matched, row)
+	}
+
+	// every result row stands for a row or, with aggregates, a group
+	type result struct {
+		row   []interface{}
+		group [][]interface{}
+		order []interface{}
+	}
+	aggregated := len(st.groupBy) > 0
+	for _, item := range st.items {
+		aggregated = aggregated || fakeHasAggregate(item.expr)
+	}
+	results := make([]*result, 0, len(matched))
+	if aggregated {
+		groups := make(map[string]*result)
+		for _, row := range matched {
+			env.row = row
+			key := make([]string, len(st.groupBy))
+			for i, e := range st.groupBy {
+				v, err := e.eval(env)
+				if err != nil {
+					return nil, err
+				}
+				key[i] = fmt.Sprintf("%T:%s", v, strings.ToLower(fakeString(v)))
+			}
+			k := strings.Join(key, "\x00")
+			g, ok := groups[k]
+			if !ok {
+				g = &result{row: row}
+				groups[k] = g
+				results = append(results, g)
+			}
+			g.group = append(g.group, row)
+		}
+		if len(results) == 0 && len(st.groupBy) == 0 {
+			results = append(results, &result{row: make([]interface{}, len(src.cols)), group: [][]interface{}{}})
+		}
+	} else {
+		for _, row := range matched {
+			results = append(results, &result{row: row})
+		}
+	}
+
+	if len(st.orderBy) > 0 {
+		for _, r := range results {
+			env.row, env.group = r.row, r.group
+			for _, o := range st.orderBy {
+				v, err := o.expr.eval(env)
+				if err != nil {
+					return nil, err
+				}
+				r.order = append(r.order, v)
+			}
+		}
+		sort.SliceStable(results, func(i, j int) bool {
+			for k, o := range st.orderBy {
+				a, b := results[i].order[k], results[j].order[k]
+				c := 0
+				switch {
+				case a == nil && b == nil:
+				case a == nil:
+					c = -1
+				case b == nil:
+					c = 1
+				default:
+					c = fakeCompare(a, b)
+				}
+				if o.desc {
+					c = -c
+				}
+				if c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+
+	if st.limit != nil {
+		env.row, env.group = nil, nil
+		limit, err := fakeCount(st.limit, env)
+		if err != nil {
+			return nil, err
+		}
+		offset := 0
+		if st.offset != nil {
+			if offset, err = fakeCount(st.offset, env); err != nil {
+				return nil, err
+			}
+		}
+		if offset > len(results) {
+			offset = len(results)
+		}
+		results = results[offset:]
+		if limit < len(results) {
+			results = results[:limit]
+		}
+	}
+
+	rows := &fakeRows{}
+	if st.star {
+		rows.columns = src.cols
+	}
+	for _, item := range st.items {
+		rows.columns = append(rows.columns, item.name)
+	}
+	for _, r := range results {
+		if st.star {
+			rows.rows = append(rows.rows, r.row)
+			continue
+		}
+		env.row, env.group = r.row, r.group
+		out := make([]interface{}, len(st.items))
+		for i, item := range st.items {
+			v, err := item.expr.eval(env)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		rows.rows = append(rows.rows, out)
+	}
+	return rows, nil
+}
+
+// fakeCount evaluates LIMIT and OFFSET
+func fakeCount(e fakeExpr, env *fakeEnv) (int, error) {
+	v, err := e.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok || n < 0 {
+		return 0, fakeError(1210, "Incorrect arguments to LIMIT")
+	}
+	return int(n), nil
+}
+
+func TestFakeDB(t *testing.T) {
+	db, err := sql.Open("fakedb", t.Name()+"?seed=docker-entrypoint-initdb.d/sample_db.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, q := range []string{
+		`CREATE TABLE tags (
+  id int(11) unsigned NOT NULL AUTO_INCREMENT,
+  item_id int(11) NOT NULL,
+  name varchar(8) NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY item_name (item_id, name),
+  FULLTEXT KEY name_text (name),
+  CONSTRAINT tags_item FOREIGN KEY (item_id) REFERENCES items (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;`,
+		`INSERT INTO tags (item_id, name) VALUES (1, 'go'), (1, 'sql'), (2, 'cache')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dialect := mysqlDialect{}
+	tables, err := dialect.Tables(db)
+	if err != nil || !reflect.DeepEqual(tables, []string{"items", "tags", "users"}) {
+		t.Fatalf("expected the seeded tables and tags, got %v %v", tables, err)
+	}
+	tab, err := dialect.Columns(db, "tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tab.AutoIncrement["id"]; !ok || !reflect.DeepEqual(tab.PK, []string{"id"}) || len(tab.Columns) != 3 {
+		t.Errorf("unexpected columns of tags: %+v", tab)
+	}
+	fks, err := dialect.ForeignKeys(db, "tags")
+	if err != nil || len(fks) != 1 || fks[0].RefTable != "items" || !reflect.DeepEqual(fks[0].Columns, []string{"item_id"}) {
+		t.Errorf("expected tags.item_id to reference items, got %+v %v", fks, err)
+	}
+	fulltext, err := dialect.FullTextIndexes(db, "tags")
+	if err != nil || !reflect.DeepEqual(fulltext, [][]string{{"name"}}) {
+		t.Errorf("expected a full-text index on name, got %v %v", fulltext, err)
+	}
+
+	var count int64
+	var maxName string
+	err = db.QueryRow("SELECT COUNT(*), MAX(`name`) FROM `tags` WHERE `item_id` = ? AND `name` LIKE ?", 1, "%O%").Scan(&count, &maxName)
+	if err != nil || count != 1 || maxName != "go" {
+		t.Errorf("expected 1 and go, got %d %q %v", count, maxName, err)
+	}
+
+	errorCodes := []struct {
+		query, code, field string
+	}{
+		{"INSERT INTO tags (item_id, name) VALUES (1, 'go')", CodeDuplicateKey, ""},
+		{"INSERT INTO tags (item_id, name) VALUES (100500, 'go')", CodeForeignKey, "item_id"},
+		{"INSERT INTO tags (item_id, name) VALUES (2, 'too long a tag')", CodeTooLong, "name"},
+		{"DELETE FROM items WHERE id = 2", CodeForeignKey, "item_id"},
+	}
+	for _, c := range errorCodes {
+		_, err := db.Exec(c.query)
+		if code, field := dialect.ErrorCode(err); code != c.code || field != c.field {
+			t.Errorf("[%s] expected %s %s, got %s %s: %v", c.query, c.code, c.field, code, field, err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("DELETE FROM tags WHERE item_id = ?", 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("DELETE FROM items WHERE id = ?", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.QueryRow("SELECT COUNT(*) FROM items").Scan(&count); err != nil || count != 2 {
+		t.Errorf("rollback must restore the deleted rows, got %d items %v", count, err)
+	}
+
+	res, err := db.Exec("UPDATE tags SET name = ? WHERE item_id = ?", "go", 1)
+	if err == nil {
+		t.Errorf("a failed update must not change anything, got %v", res)
+	}
+	if err = db.QueryRow("SELECT COUNT(*) FROM tags WHERE name = 'sql'").Scan(&count); err != nil || count != 1 {
+		t.Errorf("a failed statement must be undone, got %d %v", count, err)
+	}
+}
+
+func TestFakeDBErrors(t *testing.T) {
+	db, err := sql.Open("fakedb", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	t.Cleanup(func() {
+		fakeDatabases.Lock()
+		delete(fakeDatabases.m, t.Name())
+		fakeDatabases.Unlock()
+	})
+
+	for _, q := range []string{
+		"CREATE TABLE users (id int NOT NULL AUTO_INCREMENT, login varchar(8) NOT NULL, PRIMARY KEY (id), UNIQUE KEY login (login))",
+		"CREATE TABLE posts (id int NOT NULL AUTO_INCREMENT, user_id int NOT NULL, PRIMARY KEY (id), FOREIGN KEY (user_id) REFERENCES users (id))",
+		"INSERT INTO users (login) VALUES ('alice'), ('bob')",
+		"INSERT INTO posts (user_id) VALUES (1)",
+	} {
+		if _, err = db.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+
+	res, err := db.Exec("INSERT INTO users (login) VALUES (?)", "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := res.LastInsertId(); id != 3 {
+		t.Errorf("expected the id 3, got %d", id)
+	}
+
+	errs := []struct {
+		query  string
+		args   []interface{}
+		number uint16
+	}{
+		{"INSERT INTO users (login) VALUES (?)", []interface{}{"alice"}, 1062},
+		{"INSERT INTO users (login) VALUES (?)", []interface{}{"too long login"}, 1406},
+		{"INSERT INTO users (login) VALUES (NULL)", nil, 1048},
+		{"INSERT INTO posts (user_id) VALUES (42)", nil, 1452},
+		{"DELETE FROM users WHERE id = 1", nil, 1451},
+		{"UPDATE users SET nope = 1", nil, 1054},
+		{"SELECT * FROM nope", nil, 1146},
+		{"CREATE TABLE users (id int)", nil, 1050},
+	}
+	for _, c := range errs {
+		_, err := db.Exec(c.query, c.args...)
+		if e, ok := err.(*mysql.MySQLError); !ok || e.Number != c.number {
+			t.Errorf("%s: expected error %d, got %v", c.query, c.number, err)
+		}
+	}
+
+	// indexes and columns added later check the rows already there
+	if _, err = db.Exec("INSERT INTO posts (user_id) VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	ddl := []struct {
+		query  string
+		number uint16
+	}{
+		{"CREATE UNIQUE INDEX one_post ON posts (user_id)", 1062},
+		{"CREATE INDEX nope ON posts (nope)", 1072},
+		{"ALTER TABLE posts ADD COLUMN user_id int", 1060},
+	}
+	for _, c := range ddl {
+		_, err := db.Exec(c.query)
+		if e, ok := err.(*mysql.MySQLError); !ok || e.Number != c.number {
+			t.Errorf("%s: expected error %d, got %v", c.query, c.number, err)
+		}
+	}
+	if _, err = db.Exec("ALTER TABLE posts ADD title varchar(8) NOT NULL DEFAULT 'none'"); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query("SELECT id, login FROM users WHERE id >= ? ORDER BY id DESC LIMIT 2", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	got := make([]string, 0)
+	for rows.Next() {
+		var (
+			id    int
+			login string
+		)
+		if err = rows.Scan(&id, &login); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%d:%s", id, login))
+	}
+	if expected := []string{"3:carol", "2:bob"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	var n int
+	if err = db.QueryRow("SELECT COUNT(*) FROM posts WHERE user_id IN (1, 2) AND title = 'none'").Scan(&n); err != nil || n != 2 {
+		t.Errorf("expected the default for both posts, got %d %v", n, err)
+	}
+	var field, typ, null, key string
+	var def sql.NullString
+	var extra string
+	if err = db.QueryRow("SHOW FULL COLUMNS FROM users").Scan(&field, &typ, new(sql.NullString), &null, &key, &def, &extra, new(string), new(string)); err != nil {
+		t.Fatal(err)
+	}
+	if field != "id" || typ != "int" || null != "NO" || key != "PRI" || extra != "auto_increment" {
+		t.Errorf("unexpected column %s %s %s %s %s", field, typ, null, key, extra)
+	}
+}
@@ -0,0 +1,1742 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// The SQL understood by fakedb: the statements of sample_db.sql and the
+// queries DbExplorer builds with mysqlDialect, nothing more.
+
+type fakeTokenKind int
+
+const (
+	fakeTokenEOF fakeTokenKind = iota
+	fakeTokenWord
+	fakeTokenQuoted
+	fakeTokenString
+	fakeTokenNumber
+	fakeTokenParam
+	fakeTokenOp
+)
+
+type (
+	fakeToken struct {
+		kind fakeTokenKind
+		// text is the word as written, the value of strings and the
+		// name inside backticks
+		text       string
+		start, end int
+	}
+
+	fakeParser struct {
+		src    string
+		toks   []fakeToken
+		pos    int
+		params int
+	}
+
+	fakeStatement interface{}
+
+	fakeSelect struct {
+		items   []fakeSelectItem
+		star    bool
+		from    string
+		where   fakeExpr
+		groupBy []fakeExpr
+		orderBy []fakeOrder
+		limit   fakeExpr
+		offset  fakeExpr
+		// forUpdate locks the database until the transaction ends
+		forUpdate bool
+	}
+	fakeSelectItem struct {
+		expr fakeExpr
+		name string
+	}
+	fakeOrder struct {
+		expr fakeExpr
+		desc bool
+	}
+	fakeInsert struct {
+		table string
+		cols  []string
+		rows  [][]fakeExpr
+	}
+	fakeAssignment struct {
+		col  string
+		expr fakeExpr
+	}
+	fakeUpdate struct {
+		table string
+		sets  []fakeAssignment
+		where fakeExpr
+	}
+	fakeDelete struct {
+		table string
+		where fakeExpr
+	}
+	fakeCreate struct {
+		table       *fakeTable
+		ifNotExists bool
+	}
+	fakeDrop struct {
+		tables   []string
+		ifExists bool
+	}
+	fakeCreateIndex struct {
+		table string
+		index fakeIndex
+	}
+	// fakeAddColumn is ALTER TABLE ... ADD COLUMN
+	fakeAddColumn struct {
+		table  string
+		column fakeColumn
+	}
+	fakeShowTables  struct{}
+	fakeShowColumns struct {
+		table string
+	}
+	// fakeSet is a SET of session variables, it changes nothing
+	fakeSet struct{}
+)
+
+// fakeTokenize splits src into tokens, comments are dropped
+func fakeTokenize(src string) ([]fakeToken, error) {
+	toks := make([]fakeToken, 0)
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	isWord := func(c byte) bool {
+		return c == '_' || c == '$' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || strings.HasPrefix(src[i:], "--") && (i+2 == len(src) || src[i+2] == ' ' || src[i+2] == '\t' || src[i+2] == '\n'):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			j := strings.Index(src[i+2:], "*/")
+			if j < 0 {
+				return nil, fakeSyntaxError(src[i:])
+			}
+			i += j + 4
+		case c == '\'' || c == '"':
+			s, n, err := fakeUnquote(src[i:])
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, fakeToken{kind: fakeTokenString, text: s, start: i, end: i + n})
+			i += n
+		case c == '`':
+			var b strings.Builder
+			j := i + 1
+			for ; ; j++ {
+				if j >= len(src) {
+					return nil, fakeSyntaxError(src[i:])
+				}
+				if src[j] == '`' {
+					if j+1 < len(src) && src[j+1] == '`' {
+						b.WriteByte('`')
+						j++
+						continue
+					}
+					break
+				}
+				b.WriteByte(src[j])
+			}
+			toks = append(toks, fakeToken{kind: fakeTokenQuoted, text: b.String(), start: i, end: j + 1})
+			i = j + 1
+		case isDigit(c) || c == '.' && i+1 < len(src) && isDigit(src[i+1]):
+			j := i
+			for j < len(src) && (isDigit(src[j]) || src[j] == '.') {
+				j++
+			}
+			if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+				k := j + 1
+				if k < len(src) && (src[k] == '+' || src[k] == '-') {
+					k++
+				}
+				if k < len(src) && isDigit(src[k]) {
+					for j = k; j < len(src) && isDigit(src[j]); j++ {
+					}
+				}
+			}
+			toks = append(toks, fakeToken{kind: fakeTokenNumber, text: src[i:j], start: i, end: j})
+			i = j
+		case isWord(c):
+			j := i
+			for j < len(src) && isWord(src[j]) {
+				j++
+			}
+			toks = append(toks, fakeToken{kind: fakeTokenWord, text: src[i:j], start: i, end: j})
+			i = j
+		default:
+			op := src[i : i+1]
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "<=", ">=", "<>", "!=":
+					op = two
+				}
+			}
+			if !strings.Contains("(),;.=<>+-*/%?", op) && len(op) == 1 {
+				return nil, fakeSyntaxError(src[i:])
+			}
+			kind := fakeTokenOp
+			if op == "?" {
+				kind = fakeTokenParam
+			}
+			toks = append(toks, fakeToken{kind: kind, text: op, start: i, end: i + len(op)})
+			i += len(op)
+		}
+	}
+	return append(toks, fakeToken{kind: fakeTokenEOF, start: len(src), end: len(src)}), nil
+}
+
+// fakeUnquote reads the string literal at the start of s and returns its
+// value and length, quotes are escaped by doubling or with a backslash
+func fakeUnquote(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == quote && i+1 < len(s) && s[i+1] == quote:
+			b.WriteByte(quote)
+			i++
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch e := s[i]; e {
+			case '0':
+				b.WriteByte(0)
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'b':
+				b.WriteByte('\b')
+			case 'Z':
+				b.WriteByte(26)
+			case '%', '_':
+				// kept escaped for LIKE
+				b.WriteByte('\\')
+				b.WriteByte(e)
+			default:
+				b.WriteByte(e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fakeSyntaxError(s)
+}
+
+// fakeParse parses a single statement and returns it with the number of
+// its placeholders
+func fakeParse(src string) (fakeStatement, int, error) {
+	toks, err := fakeTokenize(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	return fakeParseTokens(src, toks)
+}
+
+func fakeParseTokens(src string, toks []fakeToken) (fakeStatement, int, error) {
+	p := &fakeParser{src: src, toks: toks}
+	st, err := p.statement()
+	if err != nil {
+		return nil, 0, err
+	}
+	p.acceptOp(";")
+	if p.peek().kind != fakeTokenEOF {
+		return nil, 0, p.syntaxError()
+	}
+	return st, p.params, nil
+}
+
+// fakeSplitScript splits the tokens of a script into statements
+func fakeSplitScript(toks []fakeToken) (statements [][]fakeToken) {
+	current := make([]fakeToken, 0)
+	for _, t := range toks {
+		if t.kind == fakeTokenEOF || t.kind == fakeTokenOp && t.text == ";" {
+			if len(current) > 0 {
+				statements = append(statements, append(current, fakeToken{kind: fakeTokenEOF, start: t.start, end: t.start}))
+			}
+			current = make([]fakeToken, 0)
+			continue
+		}
+		current = append(current, t)
+	}
+	return statements
+}
+
+func fakeSyntaxError(near string) error {
+	if len(near) > 80 {
+		near = near[:80]
+	}
+	return fakeError(1064, "You have an error in your SQL syntax; check the manual that corresponds to your MySQL server version for the right syntax to use near '%s'", near)
+}
+
+func (p *fakeParser) peek() fakeToken {
+	return p.toks[p.pos]
+}
+
+func (p *fakeParser) next() fakeToken {
+	t := p.toks[p.pos]
+	if t.kind != fakeTokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *fakeParser) syntaxError() error {
+	return fakeSyntaxError(p.src[p.peek().start:])
+}
+
+func (p *fakeParser) isKeyword(i int, kw string) bool {
+	return i < len(p.toks) && p.toks[i].kind == fakeTokenWord && strings.EqualFold(p.toks[i].text, kw)
+}
+
+// accept consumes the keywords when they come next
+func (p *fakeParser) accept(kws ...string) bool {
+	for i, kw := range kws {
+		if !p.isKeyword(p.pos+i, kw) {
+			return false
+		}
+	}
+	p.pos += len(kws)
+	return true
+}
+
+func (p *fakeParser) expect(kws ...string) error {
+	if !p.accept(kws...) {
+		return p.syntaxError()
+	}
+	return nil
+}
+
+func (p *fakeParser) acceptOp(op string) bool {
+	if t := p.peek(); t.kind == fakeTokenOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *fakeParser) expectOp(op string) error {
+	if !p.acceptOp(op) {
+		return p.syntaxError()
+	}
+	return nil
+}
+
+func (p *fakeParser) name() (string, error) {
+	if t := p.peek(); t.kind == fakeTokenWord || t.kind == fakeTokenQuoted {
+		p.pos++
+		return t.text, nil
+	}
+	return "", p.syntaxError()
+}
+
+// qualifiedName reads schema.table or table.column
+func (p *fakeParser) qualifiedName() (string, error) {
+	name, err := p.name()
+	for err == nil && p.acceptOp(".") {
+		var part string
+		part, err = p.name()
+		name += "." + part
+	}
+	return name, err
+}
+
+func (p *fakeParser) nameList() (names []string, err error) {
+	if err = p.expectOp("("); err != nil {
+		return nil, err
+	}
+	for {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	return names, p.expectOp(")")
+}
+
+func (p *fakeParser) statement() (fakeStatement, error) {
+	switch {
+	case p.accept("SELECT"):
+		return p.selectStatement()
+	case p.accept("INSERT", "INTO"):
+		return p.insertStatement()
+	case p.accept("UPDATE"):
+		return p.updateStatement()
+	case p.accept("DELETE", "FROM"):
+		st := &fakeDelete{}
+		var err error
+		if st.table, err = p.name(); err != nil {
+			return nil, err
+		}
+		st.where, err = p.where()
+		return st, err
+	case p.accept("CREATE", "TABLE"):
+		return p.createStatement()
+	case p.accept("CREATE", "UNIQUE", "INDEX"):
+		return p.createIndex(fakeIndex{unique: true})
+	case p.accept("CREATE", "FULLTEXT", "INDEX"):
+		return p.createIndex(fakeIndex{fulltext: true})
+	case p.accept("CREATE", "INDEX"):
+		return p.createIndex(fakeIndex{})
+	case p.accept("ALTER", "TABLE"):
+		st := &fakeAddColumn{}
+		var err error
+		if st.table, err = p.name(); err != nil {
+			return nil, err
+		}
+		if err = p.expect("ADD"); err != nil {
+			return nil, err
+		}
+		p.accept("COLUMN")
+		t := &fakeTable{}
+		if err = p.columnDefinition(t); err != nil {
+			return nil, err
+		}
+		st.column = t.cols[0]
+		return st, nil
+	case p.accept("DROP", "TABLE"):
+		st := &fakeDrop{ifExists: p.accept("IF", "EXISTS")}
+		for {
+			table, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			st.tables = append(st.tables, table)
+			if !p.acceptOp(",") {
+				return st, nil
+			}
+		}
+	case p.accept("SHOW", "TABLES"):
+		return fakeShowTables{}, nil
+	case p.accept("SHOW", "FULL", "COLUMNS", "FROM"), p.accept("SHOW", "COLUMNS", "FROM"):
+		table, err := p.name()
+		return fakeShowColumns{table: table}, err
+	case p.accept("SET"):
+		for p.peek().kind != fakeTokenEOF && !(p.peek().kind == fakeTokenOp && p.peek().text == ";") {
+			p.pos++
+		}
+		return fakeSet{}, nil
+	}
+	return nil, p.syntaxError()
+}
+
+func (p *fakeParser) where() (fakeExpr, error) {
+	if p.accept("WHERE") {
+		return p.expr()
+	}
+	return nil, nil
+}
+
+func (p *fakeParser) selectStatement() (st *fakeSelect, err error) {
+	st = &fakeSelect{}
+	if p.acceptOp("*") {
+		st.star = true
+	} else {
+		for {
+			start := p.peek().start
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			item := fakeSelectItem{expr: e, name: p.src[start:p.toks[p.pos-1].end]}
+			if c, ok := e.(fakeColumnRef); ok {
+				item.name = c.name
+			}
+			if p.accept("AS") {
+				if item.name, err = p.name(); err != nil {
+					return nil, err
+				}
+			}
+			st.items = append(st.items, item)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if p.accept("FROM") {
+		if st.from, err = p.qualifiedName(); err != nil {
+			return nil, err
+		}
+	}
+	if st.where, err = p.where(); err != nil {
+		return nil, err
+	}
+	if p.accept("GROUP", "BY") {
+		if st.groupBy, err = p.exprList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("ORDER", "BY") {
+		for {
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			o := fakeOrder{expr: e}
+			if p.accept("DESC") {
+				o.desc = true
+			} else {
+				p.accept("ASC")
+			}
+			st.orderBy = append(st.orderBy, o)
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	if p.accept("LIMIT") {
+		if st.limit, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if p.acceptOp(",") {
+			st.offset = st.limit
+			st.limit, err = p.expr()
+		} else if p.accept("OFFSET") {
+			st.offset, err = p.expr()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	st.forUpdate = p.accept("FOR", "UPDATE")
+	return st, nil
+}
+
+func (p *fakeParser) insertStatement() (st *fakeInsert, err error) {
+	st = &fakeInsert{}
+	if st.table, err = p.name(); err != nil {
+		return nil, err
+	}
+	if p.peek().kind == fakeTokenOp && p.peek().text == "(" {
+		if st.cols, err = p.nameList(); err != nil {
+			return nil, err
+		}
+	}
+	if !p.accept("VALUES") && !p.accept("VALUE") {
+		return nil, p.syntaxError()
+	}
+	for {
+		if err = p.expectOp("("); err != nil {
+			return nil, err
+		}
+		row, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		st.rows = append(st.rows, row)
+		if !p.acceptOp(",") {
+			return st, nil
+		}
+	}
+}
+
+func (p *fakeParser) updateStatement() (st *fakeUpdate, err error) {
+	st = &fakeUpdate{}
+	if st.table, err = p.name(); err != nil {
+		return nil, err
+	}
+	if err = p.expect("SET"); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.qualifiedName()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expectOp("="); err != nil {
+			return nil, err
+		}
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		st.sets = append(st.sets, fakeAssignment{col: fakeLastPart(col), expr: e})
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	st.where, err = p.where()
+	return st, err
+}
+
+func (p *fakeParser) createStatement() (st *fakeCreate, err error) {
+	st = &fakeCreate{ifNotExists: p.accept("IF", "NOT", "EXISTS"), table: &fakeTable{}}
+	t := st.table
+	if t.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if err = p.expectOp("("); err != nil {
+		return nil, err
+	}
+	for {
+		if err = p.tableElement(t); err != nil {
+			return nil, err
+		}
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	if err = p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	// table options, only the first auto increment value matters
+	for p.peek().kind != fakeTokenEOF && !(p.peek().kind == fakeTokenOp && p.peek().text == ";") {
+		if p.accept("AUTO_INCREMENT") {
+			p.acceptOp("=")
+			if n, err := strconv.ParseInt(p.peek().text, 10, 64); err == nil {
+				t.autoIncrement = n - 1
+			}
+		}
+		p.next()
+	}
+	for _, name := range t.pk {
+		if i, ok := t.colIndex(name); ok {
+			t.cols[i].null = false
+		} else {
+			return nil, fakeError(1072, "Key column '%s' doesn't exist in table", name)
+		}
+	}
+	return st, nil
+}
+
+func (p *fakeParser) createIndex(idx fakeIndex) (st *fakeCreateIndex, err error) {
+	st = &fakeCreateIndex{index: idx}
+	if st.index.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if err = p.expect("ON"); err != nil {
+		return nil, err
+	}
+	if st.table, err = p.name(); err != nil {
+		return nil, err
+	}
+	st.index.cols, err = p.indexColumns()
+	return st, err
+}
+
+// indexColumns reads (a, b(10) DESC), prefix lengths and order are ignored
+func (p *fakeParser) indexColumns() (cols []string, err error) {
+	if err = p.expectOp("("); err != nil {
+		return nil, err
+	}
+	for {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if p.acceptOp("(") {
+			p.next()
+			if err = p.expectOp(")"); err != nil {
+				return nil, err
+			}
+		}
+		if !p.accept("ASC") {
+			p.accept("DESC")
+		}
+		cols = append(cols, name)
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	return cols, p.expectOp(")")
+}
+
+// optionalName reads the name of an index if one is given
+func (p *fakeParser) optionalName() string {
+	if t := p.peek(); t.kind == fakeTokenQuoted || t.kind == fakeTokenWord && !strings.EqualFold(t.text, "USING") {
+		p.pos++
+		return t.text
+	}
+	return ""
+}
+
+func (p *fakeParser) tableElement(t *fakeTable) (err error) {
+	switch {
+	case p.accept("PRIMARY", "KEY"):
+		p.optionalName()
+		t.pk, err = p.indexColumns()
+		return err
+	case p.accept("UNIQUE"):
+		if !p.accept("KEY") {
+			p.accept("INDEX")
+		}
+		idx := fakeIndex{name: p.optionalName(), unique: true}
+		idx.cols, err = p.indexColumns()
+		t.addIndex(idx)
+		return err
+	case p.accept("FULLTEXT"):
+		if !p.accept("KEY") {
+			p.accept("INDEX")
+		}
+		idx := fakeIndex{name: p.optionalName(), fulltext: true}
+		idx.cols, err = p.indexColumns()
+		t.addIndex(idx)
+		return err
+	case p.accept("KEY"), p.accept("INDEX"):
+		idx := fakeIndex{name: p.optionalName()}
+		idx.cols, err = p.indexColumns()
+		t.addIndex(idx)
+		return err
+	case p.isKeyword(p.pos, "CONSTRAINT"), p.isKeyword(p.pos, "FOREIGN"):
+		fk := fakeForeignKey{}
+		if p.accept("CONSTRAINT") && !p.isKeyword(p.pos, "FOREIGN") {
+			fk.name, _ = p.name()
+		}
+		if err = p.expect("FOREIGN", "KEY"); err != nil {
+			return err
+		}
+		if name := p.optionalName(); fk.name == "" {
+			fk.name = name
+		}
+		if fk.cols, err = p.indexColumns(); err != nil {
+			return err
+		}
+		if err = p.expect("REFERENCES"); err != nil {
+			return err
+		}
+		if fk.refTable, err = p.name(); err != nil {
+			return err
+		}
+		if fk.refCols, err = p.indexColumns(); err != nil {
+			return err
+		}
+		for p.accept("ON") {
+			update := p.accept("UPDATE")
+			if !update {
+				if err = p.expect("DELETE"); err != nil {
+					return err
+				}
+			}
+			var action string
+			switch {
+			case p.accept("CASCADE"):
+				action = "CASCADE"
+			case p.accept("SET", "NULL"):
+				action = "SET NULL"
+			case p.accept("RESTRICT"), p.accept("NO", "ACTION"):
+				action = "RESTRICT"
+			default:
+				return p.syntaxError()
+			}
+			if !update {
+				fk.onDelete = action
+			}
+		}
+		if fk.name == "" {
+			fk.name = fmt.Sprintf("%s_ibfk_%d", t.name, len(t.fks)+1)
+		}
+		t.fks = append(t.fks, fk)
+		return nil
+	}
+	return p.columnDefinition(t)
+}
+
+func (p *fakeParser) columnDefinition(t *fakeTable) (err error) {
+	c := fakeColumn{null: true}
+	if c.name, err = p.name(); err != nil {
+		return err
+	}
+	base, err := p.name()
+	if err != nil {
+		return err
+	}
+	c.base = strings.ToLower(base)
+	if c.base == "double" {
+		p.accept("PRECISION")
+	}
+	c.typ = c.base
+	if c.base == "bool" || c.base == "boolean" {
+		// MySQL keeps BOOLEAN as tinyint(1)
+		c.base, c.typ = "tinyint", "tinyint(1)"
+	}
+	if p.acceptOp("(") {
+		args := make([]string, 0)
+		for {
+			a := p.next()
+			switch a.kind {
+			case fakeTokenNumber:
+				args = append(args, a.text)
+			case fakeTokenString:
+				c.values = append(c.values, a.text)
+				args = append(args, "'"+strings.ReplaceAll(a.text, "'", "''")+"'")
+			default:
+				return fakeSyntaxError(p.src[a.start:])
+			}
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+		if err = p.expectOp(")"); err != nil {
+			return err
+		}
+		c.typ += "(" + strings.Join(args, ",") + ")"
+		switch c.base {
+		case "char", "varchar", "binary", "varbinary":
+			c.size, _ = strconv.Atoi(args[0])
+		case "decimal", "numeric", "dec", "fixed":
+			if len(args) > 1 {
+				c.scale, _ = strconv.Atoi(args[1])
+			}
+		}
+	}
+	for {
+		switch {
+		case p.accept("UNSIGNED"):
+			c.typ += " unsigned"
+		case p.accept("SIGNED"), p.accept("ZEROFILL"):
+		case p.accept("NOT", "NULL"):
+			c.null = false
+		case p.accept("NULL"):
+		case p.accept("DEFAULT"):
+			c.hasDefault = true
+			e, err := p.unary()
+			if err != nil {
+				return err
+			}
+			if c.def, err = e.eval(&fakeEnv{}); err != nil {
+				return err
+			}
+			if _, ok := e.(fakeNow); ok {
+				c.def = fakeNow{}
+			}
+		case p.accept("AUTO_INCREMENT"):
+			c.autoIncrement = true
+		case p.accept("PRIMARY", "KEY"):
+			t.pk = []string{c.name}
+		case p.accept("UNIQUE"):
+			p.accept("KEY")
+			t.addIndex(fakeIndex{cols: []string{c.name}, unique: true})
+		case p.accept("COMMENT"):
+			if tok := p.next(); tok.kind != fakeTokenString {
+				return fakeSyntaxError(p.src[tok.start:])
+			} else {
+				c.comment = tok.text
+			}
+		case p.accept("ON", "UPDATE"):
+			e, err := p.primary()
+			if err != nil {
+				return err
+			}
+			if _, ok := e.(fakeNow); !ok {
+				return p.syntaxError()
+			}
+			c.onUpdateNow = true
+		case p.accept("CHARACTER", "SET"), p.accept("CHARSET"), p.accept("COLLATE"):
+			p.next()
+		default:
+			t.cols = append(t.cols, c)
+			return nil
+		}
+	}
+}
+
+func (p *fakeParser) exprList() (list []fakeExpr, err error) {
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if !p.acceptOp(",") {
+			return list, nil
+		}
+	}
+}
+
+func (p *fakeParser) expr() (fakeExpr, error) {
+	l, err := p.and()
+	for err == nil && p.accept("OR") {
+		var r fakeExpr
+		if r, err = p.and(); err == nil {
+			l = fakeBinary{op: "OR", l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *fakeParser) and() (fakeExpr, error) {
+	l, err := p.not()
+	for err == nil && p.accept("AND") {
+		var r fakeExpr
+		if r, err = p.not(); err == nil {
+			l = fakeBinary{op: "AND", l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *fakeParser) not() (fakeExpr, error) {
+	if p.accept("NOT") {
+		x, err := p.not()
+		return fakeNot{x: x}, err
+	}
+	return p.comparison()
+}
+
+func (p *fakeParser) comparison() (fakeExpr, error) {
+	l, err := p.additive()
+	for err == nil {
+		t := p.peek()
+		switch {
+		case t.kind == fakeTokenOp && strings.Contains(" = <> != < <= > >= ", " "+t.text+" "):
+			p.pos++
+			var r fakeExpr
+			if r, err = p.additive(); err == nil {
+				l = fakeBinary{op: t.text, l: l, r: r}
+			}
+		case p.accept("IS"):
+			not := p.accept("NOT")
+			err = p.expect("NULL")
+			l = fakeIsNull{x: l, not: not}
+		default:
+			start := p.pos
+			not := p.accept("NOT")
+			switch {
+			case p.accept("IN"):
+				if err = p.expectOp("("); err != nil {
+					return nil, err
+				}
+				var list []fakeExpr
+				if list, err = p.exprList(); err == nil {
+					err = p.expectOp(")")
+				}
+				l = fakeIn{x: l, list: list, not: not}
+			case p.accept("LIKE"):
+				like := fakeLike{x: l, not: not}
+				if like.pattern, err = p.additive(); err == nil && p.accept("ESCAPE") {
+					like.escape, err = p.primary()
+				}
+				l = like
+			default:
+				p.pos = start
+				return l, nil
+			}
+		}
+	}
+	return l, err
+}
+
+func (p *fakeParser) additive() (fakeExpr, error) {
+	l, err := p.multiplicative()
+	for err == nil {
+		t := p.peek()
+		if t.kind != fakeTokenOp || t.text != "+" && t.text != "-" {
+			break
+		}
+		p.pos++
+		var r fakeExpr
+		if r, err = p.multiplicative(); err == nil {
+			l = fakeBinary{op: t.text, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *fakeParser) multiplicative() (fakeExpr, error) {
+	l, err := p.unary()
+	for err == nil {
+		t := p.peek()
+		if t.kind != fakeTokenOp || t.text != "*" && t.text != "/" && t.text != "%" {
+			break
+		}
+		p.pos++
+		var r fakeExpr
+		if r, err = p.unary(); err == nil {
+			l = fakeBinary{op: t.text, l: l, r: r}
+		}
+	}
+	return l, err
+}
+
+func (p *fakeParser) unary() (fakeExpr, error) {
+	switch {
+	case p.acceptOp("-"):
+		x, err := p.unary()
+		return fakeBinary{op: "-", l: fakeLiteral{v: int64(0)}, r: x}, err
+	case p.acceptOp("+"):
+		return p.unary()
+	}
+	return p.primary()
+}
+
+func (p *fakeParser) primary() (fakeExpr, error) {
+	t := p.peek()
+	switch t.kind {
+	case fakeTokenNumber:
+		p.pos++
+		if n, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return fakeLiteral{v: n}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fakeSyntaxError(p.src[t.start:])
+		}
+		return fakeLiteral{v: f}, nil
+	case fakeTokenString:
+		p.pos++
+		return fakeLiteral{v: t.text}, nil
+	case fakeTokenParam:
+		p.pos++
+		p.params++
+		return fakeParamRef{n: p.params - 1}, nil
+	case fakeTokenQuoted:
+		name, err := p.qualifiedName()
+		return fakeColumnRef{name: fakeLastPart(name)}, err
+	case fakeTokenOp:
+		if !p.acceptOp("(") {
+			break
+		}
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expectOp(")")
+	case fakeTokenWord:
+		switch {
+		case p.accept("NULL"):
+			return fakeLiteral{}, nil
+		case p.accept("TRUE"):
+			return fakeLiteral{v: int64(1)}, nil
+		case p.accept("FALSE"):
+			return fakeLiteral{v: int64(0)}, nil
+		case p.accept("CURRENT_TIMESTAMP"):
+			if p.acceptOp("(") {
+				p.acceptOp(")")
+			}
+			return fakeNow{}, nil
+		case p.accept("MATCH"):
+			return p.match()
+		}
+		if next := p.toks[p.pos+1]; next.kind == fakeTokenOp && next.text == "(" {
+			return p.call()
+		}
+		name, err := p.qualifiedName()
+		return fakeColumnRef{name: fakeLastPart(name)}, err
+	}
+	return nil, p.syntaxError()
+}
+
+func (p *fakeParser) call() (fakeExpr, error) {
+	c := fakeCall{name: strings.ToUpper(p.next().text)}
+	p.next()
+	if c.name == "NOW" {
+		return fakeNow{}, p.expectOp(")")
+	}
+	switch {
+	case p.acceptOp("*"):
+		c.star = true
+	case p.acceptOp(")"):
+		return c, nil
+	default:
+		var err error
+		if c.args, err = p.exprList(); err != nil {
+			return nil, err
+		}
+	}
+	return c, p.expectOp(")")
+}
+
+// match reads MATCH (a, b) AGAINST (term IN NATURAL LANGUAGE MODE)
+func (p *fakeParser) match() (fakeExpr, error) {
+	m := fakeMatch{}
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	for {
+		name, err := p.qualifiedName()
+		if err != nil {
+			return nil, err
+		}
+		m.cols = append(m.cols, fakeColumnRef{name: fakeLastPart(name)})
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	if err := p.expect("AGAINST"); err != nil {
+		return nil, err
+	}
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	var err error
+	if m.against, err = p.additive(); err != nil {
+		return nil, err
+	}
+	p.accept("IN", "NATURAL", "LANGUAGE", "MODE")
+	return m, p.expectOp(")")
+}
+
+func fakeLastPart(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// Expressions evaluate to nil for NULL, int64, float64, string or []byte,
+// conditions give int64 1 or 0 like MySQL does.
+
+type (
+	fakeEnv struct {
+		db   *fakeDB
+		cols map[string]int
+		row  []interface{}
+		// group holds the rows an aggregate is computed over
+		group [][]interface{}
+		args  []driver.Value
+	}
+
+	fakeExpr interface {
+		eval(env *fakeEnv) (interface{}, error)
+	}
+
+	fakeLiteral struct {
+		v interface{}
+	}
+	fakeParamRef struct {
+		n int
+	}
+	fakeColumnRef struct {
+		name string
+	}
+	fakeNow    struct{}
+	fakeBinary struct {
+		op   string
+		l, r fakeExpr
+	}
+	fakeNot struct {
+		x fakeExpr
+	}
+	fakeIsNull struct {
+		x   fakeExpr
+		not bool
+	}
+	fakeIn struct {
+		x    fakeExpr
+		list []fakeExpr
+		not  bool
+	}
+	fakeLike struct {
+		x, pattern, escape fakeExpr
+		not                bool
+	}
+	fakeCall struct {
+		name string
+		args []fakeExpr
+		star bool
+	}
+	fakeMatch struct {
+		cols    []fakeColumnRef
+		against fakeExpr
+	}
+)
+
+const fakeDatetimeLayout = "2006-01-02 15:04:05"
+
+func (e fakeLiteral) eval(*fakeEnv) (interface{}, error) {
+	return e.v, nil
+}
+
+func (e fakeParamRef) eval(env *fakeEnv) (interface{}, error) {
+	if e.n >= len(env.args) {
+		return nil, fakeError(1210, "Incorrect arguments to mysqld_stmt_execute")
+	}
+	switch v := env.args[e.n].(type) {
+	case bool:
+		return int64(boolToInt(v)), nil
+	case time.Time:
+		return v.Format(fakeDatetimeLayout), nil
+	default:
+		return v, nil
+	}
+}
+
+func (e fakeColumnRef) eval(env *fakeEnv) (interface{}, error) {
+	i, ok := env.cols[strings.ToLower(e.name)]
+	if !ok {
+		return nil, fakeError(1054, "Unknown column '%s' in 'field list'", e.name)
+	}
+	return env.row[i], nil
+}
+
+func (fakeNow) eval(*fakeEnv) (interface{}, error) {
+	return time.Now().Format(fakeDatetimeLayout), nil
+}
+
+func (e fakeBinary) eval(env *fakeEnv) (interface{}, error) {
+	l, err := e.l.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case "AND", "OR":
+		lt, lnull := fakeTruth(l)
+		if e.op == "AND" && !lnull && !lt || e.op == "OR" && lt {
+			return int64(boolToInt(lt)), nil
+		}
+		r, err := e.r.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		rt, rnull := fakeTruth(r)
+		if e.op == "AND" && !rnull && !rt || e.op == "OR" && rt {
+			return int64(boolToInt(rt)), nil
+		}
+		if lnull || rnull {
+			return nil, nil
+		}
+		return int64(boolToInt(lt)), nil
+	}
+	r, err := e.r.eval(env)
+	if err != nil || l == nil || r == nil {
+		return nil, err
+	}
+	switch e.op {
+	case "=", "<>", "!=", "<", "<=", ">", ">=":
+		c := fakeCompare(l, r)
+		var ok bool
+		switch e.op {
+		case "=":
+			ok = c == 0
+		case "<>", "!=":
+			ok = c != 0
+		case "<":
+			ok = c < 0
+		case "<=":
+			ok = c <= 0
+		case ">":
+			ok = c > 0
+		case ">=":
+			ok = c >= 0
+		}
+		return int64(boolToInt(ok)), nil
+	}
+	li, lint := l.(int64)
+	ri, rint := r.(int64)
+	if lint && rint && e.op != "/" {
+		switch e.op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		case "%":
+			if ri == 0 {
+				return nil, nil
+			}
+			return li % ri, nil
+		}
+	}
+	lf, rf := fakeFloat(l), fakeFloat(r)
+	switch e.op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, nil
+		}
+		return lf / rf, nil
+	case "%":
+		if rf == 0 {
+			return nil, nil
+		}
+		return math.Mod(lf, rf), nil
+	}
+	return nil, fmt.Errorf("fakedb: unknown operator %s", e.op)
+}
+
+func (e fakeNot) eval(env *fakeEnv) (interface{}, error) {
+	v, err := e.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	t, null := fakeTruth(v)
+	if null {
+		return nil, nil
+	}
+	return int64(boolToInt(!t)), nil
+}
+
+func (e fakeIsNull) eval(env *fakeEnv) (interface{}, error) {
+	v, err := e.x.eval(env)
+	return int64(boolToInt((v == nil) != e.not)), err
+}
+
+func (e fakeIn) eval(env *fakeEnv) (interface{}, error) {
+	v, err := e.x.eval(env)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	null := false
+	for _, item := range e.list {
+		w, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if w == nil {
+			null = true
+		} else if fakeCompare(v, w) == 0 {
+			return int64(boolToInt(!e.not)), nil
+		}
+	}
+	if null {
+		return nil, nil
+	}
+	return int64(boolToInt(e.not)), nil
+}
+
+func (e fakeLike) eval(env *fakeEnv) (interface{}, error) {
+	v, err := e.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := e.pattern.eval(env)
+	if err != nil || v == nil || pattern == nil {
+		return nil, err
+	}
+	escape := "\\"
+	if e.escape != nil {
+		esc, err := e.escape.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		escape = fakeString(esc)
+	}
+	re, err := fakeLikeRegexp(fakeString(pattern), escape)
+	if err != nil {
+		return nil, err
+	}
+	return int64(boolToInt(re.MatchString(fakeString(v)) != e.not)), nil
+}
+
+// fakeLikeRegexp translates a LIKE pattern, matching is case insensitive
+// as with the default collation of MySQL
+func fakeLikeRegexp(pattern, escape string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case escape != "" && string(r) == escape && i+1 < len(runes):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+var fakeAggregates = map[string]bool{"COUNT": true, "MIN": true, "MAX": true, "SUM": true, "AVG": true}
+
+func (e fakeCall) eval(env *fakeEnv) (interface{}, error) {
+	if fakeAggregates[e.name] {
+		return e.aggregate(env)
+	}
+	args := make([]interface{}, len(e.args))
+	for i, a := range e.args {
+		v, err := a.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	switch {
+	case e.name == "DATABASE" && len(args) == 0:
+		if env.db == nil {
+			return nil, nil
+		}
+		return env.db.name, nil
+	case (e.name == "LOWER" || e.name == "UPPER") && len(args) == 1:
+		if args[0] == nil {
+			return nil, nil
+		}
+		if e.name == "LOWER" {
+			return strings.ToLower(fakeString(args[0])), nil
+		}
+		return strings.ToUpper(fakeString(args[0])), nil
+	case e.name == "COALESCE" || e.name == "IFNULL":
+		for _, v := range args {
+			if v != nil {
+				return v, nil
+			}
+		}
+		return nil, nil
+	}
+	return nil, fakeError(1305, "FUNCTION %s does not exist", e.name)
+}
+
+func (e fakeCall) aggregate(env *fakeEnv) (interface{}, error) {
+	if env.group == nil {
+		return nil, fakeError(1111, "Invalid use of group function")
+	}
+	if e.star {
+		if e.name != "COUNT" {
+			return nil, fakeSyntaxError("*)")
+		}
+		return int64(len(env.group)), nil
+	}
+	if len(e.args) != 1 {
+		return nil, fakeError(1582, "Incorrect parameter count in the call to native function '%s'", e.name)
+	}
+	values := make([]interface{}, 0, len(env.group))
+	for _, row := range env.group {
+		v, err := e.args[0].eval(&fakeEnv{db: env.db, cols: env.cols, row: row, args: env.args})
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			values = append(values, v)
+		}
+	}
+	if e.name == "COUNT" {
+		return int64(len(values)), nil
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	switch e.name {
+	case "MIN", "MAX":
+		best := values[0]
+		for _, v := range values[1:] {
+			if c := fakeCompare(v, best); e.name == "MIN" && c < 0 || e.name == "MAX" && c > 0 {
+				best = v
+			}
+		}
+		return best, nil
+	}
+	var isum int64
+	var fsum float64
+	ints := true
+	for _, v := range values {
+		if i, ok := v.(int64); ok && ints {
+			isum += i
+		} else {
+			ints = false
+		}
+		fsum += fakeFloat(v)
+	}
+	if e.name == "AVG" {
+		return fsum / float64(len(values)), nil
+	}
+	if ints {
+		return isum, nil
+	}
+	return fsum, nil
+}
+
+// fakeMinWord is the shortest word indexed by InnoDB full-text indexes
+const fakeMinWord = 3
+
+func (e fakeMatch) eval(env *fakeEnv) (interface{}, error) {
+	term, err := e.against.eval(env)
+	if err != nil || term == nil {
+		return nil, err
+	}
+	words := make(map[string]struct{})
+	for _, c := range e.cols {
+		v, err := c.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range fakeWords(fakeString(v)) {
+			words[w] = struct{}{}
+		}
+	}
+	// the relevance is the number of words of the term found in the row
+	var score float64
+	for _, w := range fakeWords(fakeString(term)) {
+		if _, ok := words[w]; ok && len([]rune(w)) >= fakeMinWord {
+			score++
+		}
+	}
+	return score, nil
+}
+
+func fakeWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// fakeHasAggregate tells whether the expression computes over a group
+func fakeHasAggregate(e fakeExpr) bool {
+	switch e := e.(type) {
+	case fakeCall:
+		if fakeAggregates[e.name] {
+			return true
+		}
+		for _, a := range e.args {
+			if fakeHasAggregate(a) {
+				return true
+			}
+		}
+	case fakeBinary:
+		return fakeHasAggregate(e.l) || fakeHasAggregate(e.r)
+	case fakeNot:
+		return fakeHasAggregate(e.x)
+	case fakeIsNull:
+		return fakeHasAggregate(e.x)
+	}
+	return false
+}
+
+// fakeTruth tells whether a value is true, null is neither true nor false
+func fakeTruth(v interface{}) (truth, null bool) {
+	if v == nil {
+		return false, true
+	}
+	return fakeFloat(v) != 0, false
+}
+
+func fakeString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// fakeFloat converts like MySQL does in numeric context: the leading
+// number of a string, or 0
+func fakeFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	s := strings.TrimSpace(fakeString(v))
+	for end := len(s); end > 0; end-- {
+		if f, err := strconv.ParseFloat(s[:end], 64); err == nil {
+			return f
+		}
+	}
+	return 0
+}
+
+// fakeCompare orders two non-null values, strings compare case
+// insensitively and numbers win over strings
+func fakeCompare(a, b interface{}) int {
+	ai, aint := a.(int64)
+	bi, bint := b.(int64)
+	switch {
+	case aint && bint:
+		switch {
+		case ai < bi:
+			return -1
+		case ai > bi:
+			return 1
+		}
+		return 0
+	case fakeIsNumber(a) || fakeIsNumber(b):
+		af, bf := fakeFloat(a), fakeFloat(b)
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(strings.ToLower(fakeString(a)), strings.ToLower(fakeString(b)))
+}
+
+func fakeIsNumber(v interface{}) bool {
+	switch v.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
+
+func TestFakeTokenize(t *testing.T) {
+	toks, err := fakeTokenize("SELECT `a``b`, 'it''s', 1.5, ? -- comment\n<= /* block */ !=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []fakeToken{
+		{kind: fakeTokenWord, text: "SELECT"},
+		{kind: fakeTokenQuoted, text: "a`b"},
+		{kind: fakeTokenOp, text: ","},
+		{kind: fakeTokenString, text: "it's"},
+		{kind: fakeTokenOp, text: ","},
+		{kind: fakeTokenNumber, text: "1.5"},
+		{kind: fakeTokenOp, text: ","},
+		{kind: fakeTokenParam, text: "?"},
+		{kind: fakeTokenOp, text: "<="},
+		{kind: fakeTokenOp, text: "!="},
+		{kind: fakeTokenEOF},
+	}
+	for i := range toks {
+		toks[i].start, toks[i].end = 0, 0
+	}
+	if !reflect.DeepEqual(toks, expected) {
+		t.Errorf("expected %+v, got %+v", expected, toks)
+	}
+	if _, err = fakeTokenize("SELECT 'open"); err == nil {
+		t.Errorf("an unterminated string must fail")
+	}
+}
+
+func TestFakeParse(t *testing.T) {
+	st, params, err := fakeParse("SELECT `id`, title AS t FROM `golang`.`items` WHERE id > ? AND title LIKE ? ORDER BY id DESC LIMIT ?, 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel, ok := st.(*fakeSelect)
+	if !ok {
+		t.Fatalf("expected a select, got %T", st)
+	}
+	if params != 3 || sel.from != "golang.items" || len(sel.items) != 2 || sel.items[1].name != "t" {
+		t.Errorf("unexpected select %d %+v", params, sel)
+	}
+	if len(sel.orderBy) != 1 || !sel.orderBy[0].desc || !reflect.DeepEqual(sel.offset, fakeParamRef{n: 2}) {
+		t.Errorf("unexpected order and limit %+v", sel)
+	}
+	if !reflect.DeepEqual(sel.limit, fakeLiteral{v: int64(10)}) {
+		t.Errorf("expected limit 10, got %#v", sel.limit)
+	}
+
+	st, params, err = fakeParse("INSERT INTO items (`title`, description) VALUES (?, ''), ('x', NULL);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins := st.(*fakeInsert)
+	expected := &fakeInsert{
+		table: "items",
+		cols:  []string{"title", "description"},
+		rows: [][]fakeExpr{
+			{fakeParamRef{n: 0}, fakeLiteral{v: ""}},
+			{fakeLiteral{v: "x"}, fakeLiteral{}},
+		},
+	}
+	if params != 1 || !reflect.DeepEqual(ins, expected) {
+		t.Errorf("expected %+v, got %d %+v", expected, params, ins)
+	}
+
+	st, _, err = fakeParse("CREATE TABLE t (id int(10) unsigned NOT NULL AUTO_INCREMENT, flag BOOLEAN DEFAULT FALSE, PRIMARY KEY (id))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tab := st.(*fakeCreate).table
+	if len(tab.pk) != 1 || tab.pk[0] != "id" || len(tab.cols) != 2 {
+		t.Fatalf("unexpected table %+v", tab)
+	}
+	if id := tab.cols[0]; id.typ != "int(10) unsigned" || id.null || !id.autoIncrement {
+		t.Errorf("unexpected id column %+v", id)
+	}
+	if flag := tab.cols[1]; flag.typ != "tinyint(1)" || !flag.hasDefault || flag.def != int64(0) {
+		t.Errorf("BOOLEAN must be tinyint(1), got %+v", flag)
+	}
+
+	st, _, err = fakeParse("CREATE UNIQUE INDEX users_login ON users (login)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx := st.(*fakeCreateIndex); idx.table != "users" || !reflect.DeepEqual(idx.index, fakeIndex{name: "users_login", cols: []string{"login"}, unique: true}) {
+		t.Errorf("unexpected index %+v", idx)
+	}
+	st, _, err = fakeParse("ALTER TABLE items ADD COLUMN priority int DEFAULT NULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if add := st.(*fakeAddColumn); add.table != "items" || add.column.name != "priority" || !add.column.null {
+		t.Errorf("unexpected column %+v", add)
+	}
+
+	for _, src := range []string{
+		"SELECT * FROM",
+		"SELECT 1 2",
+		"INSERT INTO items VALUES (1",
+		"UPDATE items SET",
+		"DROP items",
+	} {
+		_, _, err := fakeParse(src)
+		if e, ok := err.(*mysql.MySQLError); !ok || e.Number != 1064 {
+			t.Errorf("%s: expected a syntax error, got %v", src, err)
+		}
+	}
+}
+
+func TestFakeEval(t *testing.T) {
+	env := &fakeEnv{
+		cols: map[string]int{"n": 0, "s": 1},
+		row:  []interface{}{int64(5), "Hello"},
+		args: []driver.Value{"h%"},
+	}
+	cases := []struct {
+		expr string
+		want interface{}
+	}{
+		{"n + 1", int64(6)},
+		{"n / 2", 2.5},
+		{"n > 3 AND s = 'hello'", int64(1)},
+		{"NULL = NULL", nil},
+		{"NULL IS NULL", int64(1)},
+		{"n IN (1, 5)", int64(1)},
+		{"n NOT IN (1, NULL)", nil},
+		{"s LIKE ?", int64(1)},
+		{"s LIKE 'h!%' ESCAPE '!'", int64(0)},
+		{"LOWER(s)", "hello"},
+		{"? = 'H%'", int64(1)},
+		{"NOT (n < 1 OR NULL)", nil},
+	}
+	for _, c := range cases {
+		st, _, err := fakeParse("SELECT " + c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		got, err := st.(*fakeSelect).items[0].expr.eval(env)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: expected %#v, got %#v %v", c.expr, c.want, got, err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	errorInvalidKey    = errors.New("invalid key")
+	errorNoPrimaryKey  = errors.New("table has no primary key")
+	errorReadOnlyTable = errors.New("table is read-only")
+)
+
+// keyPathSegment selects the query string key form: /$table/_key?col=value
+const keyPathSegment = "_key"
+
+func (t Table) pkColumns() []Col {
+	cols := make([]Col, 0, len(t.PK))
+	for _, name := range t.PK {
+		if c, ok := t.column(name); ok {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+// autoIncrementPK returns the primary key column filled in by the database
+func (t Table) autoIncrementPK() string {
+	for _, name := range t.PK {
+		if _, ok := t.AutoIncrement[name]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// parseRecordKey reads the primary key either from the path segment, where
+// composite key parts are separated by commas (/order_items/12,7), or from
+// the query string when the segment is _key (/order_items/_key?order_id=12&item_id=7).
+func (d *DbExplorer) parseRecordKey(r *http.Request, tab Table, segment string) (key []interface{}, err error) {
+	if len(tab.PK) == 0 {
+		return nil, errorNoPrimaryKey
+	}
+	var parts []string
+	if segment == keyPathSegment {
+		params := r.URL.Query()
+		for _, name := range tab.PK {
+			if _, ok := params[name]; !ok {
+				return nil, errorInvalidKey
+			}
+			parts = append(parts, params.Get(name))
+		}
+	} else {
+		parts = strings.Split(segment, ",")
+	}
+	return d.parseKeyParts(tab, parts)
+}
+
+func (d *DbExplorer) parseKeyParts(tab Table, parts []string) (key []interface{}, err error) {
+	if len(tab.PK) == 0 {
+		return nil, errorNoPrimaryKey
+	}
+	if len(parts) != len(tab.PK) {
+		return nil, errorInvalidKey
+	}
+	for i, c := range tab.pkColumns() {
+		v, err := d.types.lookup(c).Parse(c, parts[i])
+		if err != nil {
+			return nil, errorInvalidKey
+		}
+		key = append(key, v)
+	}
+	return key, nil
+}
+
+// keyConditions matches a single record by its primary key
+func (t Table) keyConditions(key []interface{}) []condition {
+	conds := make([]condition, 0, len(key))
+	for i, c := range t.pkColumns() {
+		conds = append(conds, condition{col: c, op: "eq", value: key[i]})
+	}
+	return conds
+}
+
+// insertedKey builds the key of a freshly inserted record, the auto
+// increment part comes from the database and the rest from the record
+func (t Table) insertedKey(record map[string]interface{}, lastId int64) map[string]interface{} {
+	key := make(map[string]interface{}, len(t.PK))
+	for _, name := range t.PK {
+		if _, ok := t.AutoIncrement[name]; ok {
+			key[name] = lastId
+			continue
+		}
+		key[name] = record[name]
+	}
+	return key
+}
+
+// keyset is the value of an "after" condition used by cursor pagination
+type keyset struct {
+	cols []Col
+	key  []interface{}
+}
+
+// afterKeySQL expands a keyset condition over a composite key:
+// (a, b) > (x, y) becomes (a > x OR (a = x AND b > y)), the outer
+// parentheses keep it whole next to the other conditions
+func (d *DbExplorer) afterKeySQL(cols []Col, key []interface{}, args *sqlArgs) string {
+	ors := make([]string, 0, len(cols))
+	for i := range cols {
+		ands := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, fmt.Sprintf("%s = %s", d.quote(cols[j].Name), args.add(key[j])))
+		}
+		ands = append(ands, fmt.Sprintf("%s > %s", d.quote(cols[i].Name), args.add(key[i])))
+		ors = append(ors, strings.Join(ands, " AND "))
+	}
+	if len(ors) == 1 {
+		return ors[0]
+	}
+	return "((" + strings.Join(ors, ") OR (") + "))"
+}
+
+func writeReadOnlyTable(w http.ResponseWriter) (err error) {
+	return writeError(w, errorReadOnlyTable)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRecordKeys(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, []string{
+			`CREATE TABLE order_items (
+  item_id int NOT NULL,
+  order_id int NOT NULL,
+  qty int NOT NULL,
+  PRIMARY KEY (order_id, item_id)
+);`,
+			`INSERT INTO order_items (order_id, item_id, qty) VALUES (12, 7, 1), (12, 8, 2), (13, 1, 3);`,
+			`CREATE TABLE tokens (
+  token varchar(36) NOT NULL PRIMARY KEY,
+  owner varchar(255) NOT NULL
+);`,
+			`INSERT INTO tokens (token, owner) VALUES ('8d5e9c4a-0000-4000-8000-000000000001', 'rvasily');`,
+			`CREATE TABLE logs (
+  message text NOT NULL,
+  level int NOT NULL
+);`,
+			`INSERT INTO logs (message, level) VALUES ('started', 1);`,
+		})
+		if pk := s.handler.columns["order_items"].PK; !reflect.DeepEqual(pk, []string{"order_id", "item_id"}) {
+			t.Fatalf("expected key in index order, got %v", pk)
+		}
+
+		s.run(t, []Case{
+			// composite keys go in primary key order
+			Case{
+				Path: "/order_items/12,7",
+				Result: CR{
+					"response": CR{
+						"record": CR{"order_id": 12, "item_id": 7, "qty": 1},
+					},
+				},
+			},
+			Case{
+				Path:  "/order_items/_key",
+				Query: "item_id=8&order_id=12",
+				Result: CR{
+					"response": CR{
+						"record": CR{"order_id": 12, "item_id": 8, "qty": 2},
+					},
+				},
+			},
+			Case{
+				Path:   "/order_items/12",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "invalid key", "code": "invalid_key"},
+			},
+			Case{
+				Path:   "/order_items/12,x",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "invalid key", "code": "invalid_key"},
+			},
+			Case{
+				Path:   "/order_items/",
+				Method: http.MethodPut,
+				Body:   CR{"order_id": 13, "item_id": 2, "qty": 5},
+				Result: CR{"response": CR{"order_id": 13, "item_id": 2}},
+			},
+			Case{
+				Path:   "/order_items/13,2",
+				Method: http.MethodPost,
+				Body:   CR{"qty": 6},
+				Result: CR{"response": CR{"updated": 1}},
+			},
+			Case{
+				Path:   "/order_items/13,2",
+				Method: http.MethodPost,
+				Status: http.StatusBadRequest,
+				Body:   CR{"item_id": 3},
+				Result: CR{"error": "field item_id have invalid type", "code": "invalid_type", "field": "item_id"},
+			},
+			Case{
+				Path:  "/order_items",
+				Query: "cursor=&limit=2&fields=qty",
+				Result: CR{
+					"response": CR{
+						"records":     []CR{CR{"qty": 1}, CR{"qty": 2}},
+						"next_cursor": encodeCursor(12, 8),
+					},
+				},
+			},
+			Case{
+				Path:  "/order_items",
+				Query: "limit=2&fields=qty&cursor=" + encodeCursor(12, 8),
+				Result: CR{
+					"response": CR{
+						"records":     []CR{CR{"qty": 3}, CR{"qty": 6}},
+						"next_cursor": nil,
+					},
+				},
+			},
+			// the keyset condition does not escape the filters
+			Case{
+				Path:  "/order_items",
+				Query: "where[qty][gt]=2&fields=qty&cursor=" + encodeCursor(12, 7),
+				Result: CR{
+					"response": CR{
+						"records":     []CR{CR{"qty": 3}, CR{"qty": 6}},
+						"next_cursor": nil,
+					},
+				},
+			},
+			Case{
+				Path:   "/order_items/_key?order_id=13&item_id=2",
+				Method: http.MethodDelete,
+				Result: CR{"response": CR{"deleted": 1}},
+			},
+
+			// string keys
+			Case{
+				Path: "/tokens/8d5e9c4a-0000-4000-8000-000000000001",
+				Result: CR{
+					"response": CR{
+						"record": CR{"token": "8d5e9c4a-0000-4000-8000-000000000001", "owner": "rvasily"},
+					},
+				},
+			},
+			Case{
+				Path:   "/tokens/",
+				Method: http.MethodPut,
+				Body:   CR{"token": "8d5e9c4a-0000-4000-8000-000000000002", "owner": "qwerty"},
+				Result: CR{"response": CR{"token": "8d5e9c4a-0000-4000-8000-000000000002"}},
+			},
+			Case{
+				Path:   "/tokens/8d5e9c4a-0000-4000-8000-000000000002",
+				Method: http.MethodDelete,
+				Result: CR{"response": CR{"deleted": 1}},
+			},
+
+			// tables without a primary key are read-only
+			Case{
+				Path: "/logs",
+				Result: CR{
+					"response": CR{
+						"records": []CR{CR{"message": "started", "level": 1}},
+					},
+				},
+			},
+			Case{
+				Path:   "/logs/1",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "table has no primary key", "code": "invalid_key"},
+			},
+			Case{
+				Path:   "/logs/",
+				Method: http.MethodPut,
+				Status: http.StatusMethodNotAllowed,
+				Body:   CR{"message": "stopped", "level": 1},
+				Result: CR{"error": "table is read-only", "code": "read_only"},
+			},
+			Case{
+				Path:   "/logs/1",
+				Method: http.MethodDelete,
+				Status: http.StatusMethodNotAllowed,
+				Result: CR{"error": "table is read-only", "code": "read_only"},
+			},
+			Case{
+				Path:   "/logs",
+				Query:  "cursor=",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "cursor pagination requires a primary key", "code": "bad_request"},
+			},
+		})
+	})
+}
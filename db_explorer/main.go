@@ -8,9 +8,13 @@ import (
 	"net/http"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
+	// Driver это драйвер database/sql, по нему выбирается диалект: mysql, postgres, sqlite3
+	Driver = "mysql"
 	// DSN это соединение с базой
 	// вы можете изменить этот на тот который вам нужен
 	// docker run -p 3306:3306 -v $(PWD):/docker-entrypoint-initdb.d -e MYSQL_ROOT_PASSWORD=1234 -e MYSQL_DATABASE=golang -d mysql
@@ -20,14 +24,19 @@ var (
 )
 
 func main() {
-	db, err := sql.Open("mysql", DSN)
+	db, err := sql.Open(Driver, DSN)
 	err = db.Ping() // вот тут будет первое подключение к базе
 	defer db.Close()
 	if err != nil {
 		panic(err)
 	}
 
-	handler, err := NewDbExplorer(db)
+	dialect, err := DialectByDriver(Driver)
+	if err != nil {
+		panic(err)
+	}
+
+	handler, err := NewDbExplorer(db, WithDialect(dialect))
 	if err != nil {
 		panic(err)
 	}
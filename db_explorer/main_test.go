@@ -11,6 +11,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -85,12 +86,25 @@ func CleanupTestApis(db *sql.DB) {
 	}
 }
 
-func TestApis(t *testing.T) {
-	db, err := sql.Open("mysql", DSN)
-	err = db.Ping()
+// openTestDB connects to the MySQL server of MYSQL_DSN when it is set and
+// to an in-process fakedb seeded from sample_db.sql otherwise
+func openTestDB(t *testing.T) *sql.DB {
+	driverName, dsn := "fakedb", t.Name()+"?seed=docker-entrypoint-initdb.d/sample_db.sql"
+	if mysqlDSN := os.Getenv("MYSQL_DSN"); mysqlDSN != "" {
+		driverName, dsn = "mysql", mysqlDSN
+	}
+	db, err := sql.Open(driverName, dsn)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
 	}
+	if err = db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestApis(t *testing.T) {
+	db := openTestDB(t)
 
 	PrepareTestApis(db)
 
@@ -104,7 +118,12 @@ func TestApis(t *testing.T) {
 
 	ts := httptest.NewServer(handler)
 
-	cases := []Case{
+	runCases(t, ts, db, apiCases())
+}
+
+// apiCases are shared by every dialect, the schema differs only in DDL
+func apiCases() []Case {
+	return []Case{
 		Case{
 			Path: "/", // список таблиц
 			Result: CR{
@@ -118,6 +137,7 @@ func TestApis(t *testing.T) {
 			Status: http.StatusNotFound,
 			Result: CR{
 				"error": "unknown table",
+				"code":  "unknown_table",
 			},
 		},
 		Case{
@@ -191,6 +211,7 @@ func TestApis(t *testing.T) {
 			Status: http.StatusNotFound,
 			Result: CR{
 				"error": "record not found",
+				"code":  "not_found",
 			},
 		},
 
@@ -315,6 +336,8 @@ func TestApis(t *testing.T) {
 			},
 			Result: CR{
 				"error": "field id have invalid type",
+				"code":  "invalid_type",
+				"field": "id",
 			},
 		},
 		Case{
@@ -326,6 +349,8 @@ func TestApis(t *testing.T) {
 			},
 			Result: CR{
 				"error": "field title have invalid type",
+				"code":  "invalid_type",
+				"field": "title",
 			},
 		},
 		Case{
@@ -337,6 +362,8 @@ func TestApis(t *testing.T) {
 			},
 			Result: CR{
 				"error": "field title have invalid type",
+				"code":  "invalid_type",
+				"field": "title",
 			},
 		},
 
@@ -349,6 +376,8 @@ func TestApis(t *testing.T) {
 			},
 			Result: CR{
 				"error": "field updated have invalid type",
+				"code":  "invalid_type",
+				"field": "updated",
 			},
 		},
 
@@ -376,6 +405,7 @@ func TestApis(t *testing.T) {
 			Status: http.StatusNotFound,
 			Result: CR{
 				"error": "record not found",
+				"code":  "not_found",
 			},
 		},
 
@@ -434,6 +464,8 @@ func TestApis(t *testing.T) {
 			},
 			Result: CR{
 				"error": "field user_id have invalid type",
+				"code":  "invalid_type",
+				"field": "user_id",
 			},
 		},
 		// не забываем про sql-инъекции
@@ -496,8 +528,6 @@ func TestApis(t *testing.T) {
 			},
 		},
 	}
-
-	runCases(t, ts, db, cases)
 }
 
 func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
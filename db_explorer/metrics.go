@@ -0,0 +1,312 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsPath serves the metrics in the Prometheus text format: GET /_metrics
+const metricsPath = "_metrics"
+
+// latencyBuckets are the upper bounds of the latency histograms, in seconds
+var latencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type (
+	histogram struct {
+		// counts are per bucket, the exposition adds them up
+		counts []uint64
+		count  uint64
+		sum    float64
+	}
+
+	requestLabels struct {
+		table, method string
+		status        int
+	}
+
+	// metrics counts the requests and times them and the queries sent to
+	// the database
+	metrics struct {
+		mu       sync.Mutex
+		requests map[requestLabels]uint64
+		latency  map[requestLabels]*histogram
+		queries  map[string]*histogram
+	}
+
+	// accessLog writes a JSON line for every request
+	accessLog struct {
+		mu sync.Mutex
+		w  io.Writer
+	}
+
+	accessEntry struct {
+		Time       string  `json:"time"`
+		Remote     string  `json:"remote"`
+		Principal  string  `json:"principal"`
+		Method     string  `json:"method"`
+		Path       string  `json:"path"`
+		Table      string  `json:"table,omitempty"`
+		Status     int     `json:"status"`
+		Bytes      int     `json:"bytes"`
+		DurationMs float64 `json:"duration_ms"`
+	}
+
+	// responseRecorder keeps the status and the size of the response
+	responseRecorder struct {
+		http.ResponseWriter
+		status int
+		bytes  int
+	}
+
+	// timedQueryer times the queries of a queryer
+	timedQueryer struct {
+		queryer
+		metrics *metrics
+	}
+)
+
+// WithMetrics counts requests by table, method and status, times them and
+// the queries sent to the database, and serves it all at GET /_metrics to
+// admins.
+func WithMetrics() Option {
+	return func(d *DbExplorer) {
+		d.metrics = &metrics{
+			requests: make(map[requestLabels]uint64),
+			latency:  make(map[requestLabels]*histogram),
+			queries:  make(map[string]*histogram),
+		}
+	}
+}
+
+// WithAccessLog writes a JSON line to w for every request served
+func WithAccessLog(w io.Writer) Option {
+	return func(d *DbExplorer) {
+		d.accessLog = nil
+		if w != nil {
+			d.accessLog = &accessLog{w: w}
+		}
+	}
+}
+
+func (h *histogram) observe(seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (m *metrics) observeRequest(l requestLabels, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[l]++
+	h, ok := m.latency[l]
+	if !ok {
+		h = &histogram{}
+		m.latency[l] = h
+	}
+	h.observe(elapsed.Seconds())
+}
+
+func (m *metrics) observeQuery(op string, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.queries[op]
+	if !ok {
+		h = &histogram{}
+		m.queries[op] = h
+	}
+	h.observe(elapsed.Seconds())
+}
+
+// timeQueries makes q report how long its queries take, q itself without
+// metrics
+func (m *metrics) timeQueries(q queryer) queryer {
+	if m == nil {
+		return q
+	}
+	return timedQueryer{queryer: q, metrics: m}
+}
+
+func (tq timedQueryer) Query(query string, args ...interface{}) (rows *sql.Rows, err error) {
+	defer tq.metrics.since("query", time.Now())
+	return tq.queryer.Query(query, args...)
+}
+
+// since records a query of the op started at start
+func (m *metrics) since(op string, start time.Time) {
+	if m != nil {
+		m.observeQuery(op, time.Since(start))
+	}
+}
+
+// labelValue escapes a value of a label in the text format
+func labelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	labels = strings.TrimSuffix(labels, ",")
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// write renders the metrics in the Prometheus text format, sorted so that
+// scrapes can be compared
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	labels := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.table != b.table {
+			return a.table < b.table
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	format := func(l requestLabels) string {
+		return fmt.Sprintf("table=\"%s\",method=\"%s\",status=\"%d\",", labelValue(l.table), l.method, l.status)
+	}
+
+	fmt.Fprintln(w, "# HELP db_explorer_requests_total Requests served, by table, method and status.")
+	fmt.Fprintln(w, "# TYPE db_explorer_requests_total counter")
+	for _, l := range labels {
+		fmt.Fprintf(w, "db_explorer_requests_total{%s} %d\n", strings.TrimSuffix(format(l), ","), m.requests[l])
+	}
+	fmt.Fprintln(w, "# HELP db_explorer_request_duration_seconds Time to serve requests, by table, method and status.")
+	fmt.Fprintln(w, "# TYPE db_explorer_request_duration_seconds histogram")
+	for _, l := range labels {
+		writeHistogram(w, "db_explorer_request_duration_seconds", format(l), m.latency[l])
+	}
+
+	ops := make([]string, 0, len(m.queries))
+	for op := range m.queries {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	fmt.Fprintln(w, "# HELP db_explorer_db_query_duration_seconds Time of the statements sent to the database, by kind.")
+	fmt.Fprintln(w, "# TYPE db_explorer_db_query_duration_seconds histogram")
+	for _, op := range ops {
+		writeHistogram(w, "db_explorer_db_query_duration_seconds", fmt.Sprintf("op=\"%s\",", op), m.queries[op])
+	}
+}
+
+func (l *accessLog) write(e accessEntry) {
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(bs, '\n'))
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += n
+	return n, err
+}
+
+// Flush lets the change feed stream through the recorder
+func (rr *responseRecorder) Flush() {
+	if f, ok := rr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// reservedPaths are the endpoints that are not tables
+var reservedPaths = map[string]bool{
+	openAPIPath: true, auditPath: true, cachePath: true, changesPath: true,
+	searchPath: true, batchPath: true, schemaPath: true, metricsPath: true,
+}
+
+// metricsTable is the table label of a request, empty for paths that are
+// neither a table nor an endpoint so that clients can't make up labels
+func (d *DbExplorer) metricsTable(r *http.Request) string {
+	arr := extractPartsOfPath(r)
+	if len(arr) == 0 {
+		return ""
+	}
+	if _, ok := d.columns[arr[0]]; ok || reservedPaths[arr[0]] {
+		return arr[0]
+	}
+	return ""
+}
+
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodPut, http.MethodPost, http.MethodPatch, http.MethodDelete:
+		return method
+	}
+	return "OTHER"
+}
+
+// observe counts and logs the request once it has been served
+func (d *DbExplorer) observe(rr *responseRecorder, r *http.Request, start time.Time) {
+	elapsed := time.Since(start)
+	table := d.metricsTable(r)
+	if d.metrics != nil {
+		d.metrics.observeRequest(requestLabels{table: table, method: metricsMethod(r.Method), status: rr.status}, elapsed)
+	}
+	if d.accessLog != nil {
+		d.accessLog.write(accessEntry{
+			Time:       start.UTC().Format(time.RFC3339Nano),
+			Remote:     remoteHost(r),
+			Principal:  principalFrom(r).Name,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Table:      table,
+			Status:     rr.status,
+			Bytes:      rr.bytes,
+			DurationMs: float64(elapsed.Microseconds()) / 1000,
+		})
+	}
+}
+
+func (d *DbExplorer) getMetrics(w http.ResponseWriter, r *http.Request) (err error) {
+	if d.metrics == nil {
+		return errorUnknownPath
+	}
+	if !d.policy.canAdmin(principalFrom(r)) {
+		return writeForbidden(w)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	d.metrics.write(w)
+	return
+}
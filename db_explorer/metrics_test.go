@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is written by the server and read by the test
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestMetricsHistogram(t *testing.T) {
+	d := &DbExplorer{}
+	WithMetrics()(d)
+	d.metrics.observeQuery("exec", 3*time.Millisecond)
+	d.metrics.observeQuery("exec", 200*time.Millisecond)
+	d.metrics.observeQuery("exec", time.Minute)
+
+	var b bytes.Buffer
+	d.metrics.write(&b)
+	for _, line := range []string{
+		`db_explorer_db_query_duration_seconds_bucket{op="exec",le="0.001"} 0`,
+		`db_explorer_db_query_duration_seconds_bucket{op="exec",le="0.005"} 1`,
+		`db_explorer_db_query_duration_seconds_bucket{op="exec",le="0.25"} 2`,
+		`db_explorer_db_query_duration_seconds_bucket{op="exec",le="10"} 2`,
+		`db_explorer_db_query_duration_seconds_bucket{op="exec",le="+Inf"} 3`,
+		`db_explorer_db_query_duration_seconds_sum{op="exec"} 60.203`,
+		`db_explorer_db_query_duration_seconds_count{op="exec"} 3`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("expected %s in\n%s", line, b.String())
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		logs := &syncBuffer{}
+		ts := b.serve(t, nil,
+			WithAuthenticator(APIKeyAuthenticator{
+				"admin-key": Principal{Name: "root", Roles: []string{"admin"}},
+			}),
+			WithPolicy(Policy{
+				Tables: map[string]TableRule{"items": TableRule{Read: []string{anyone}, Write: []string{anyone}}},
+				Admin:  []string{"admin"},
+			}),
+			WithMetrics(),
+			WithAccessLog(logs),
+		)
+
+		doWithHeaders(t, http.MethodGet, ts.URL+"/items/1", nil, nil)
+		doWithHeaders(t, http.MethodGet, ts.URL+"/items/100500", nil, nil)
+		doWithHeaders(t, http.MethodGet, ts.URL+"/no_such_table", nil, nil)
+		doWithHeaders(t, http.MethodPost, ts.URL+"/items/1", nil, CR{"title": "metered"})
+		if resp, _ := doWithHeaders(t, http.MethodGet, ts.URL+"/_metrics", nil, nil); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("metrics are for admins, got %d", resp.StatusCode)
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/_metrics", nil)
+		req.Header.Set("X-API-Key", "admin-key")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		bs, _ := ioutil.ReadAll(resp.Body)
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			t.Errorf("expected the text format, got %s", ct)
+		}
+		body := string(bs)
+		for _, line := range []string{
+			`db_explorer_requests_total{table="items",method="GET",status="200"} 1`,
+			`db_explorer_requests_total{table="items",method="GET",status="404"} 1`,
+			`db_explorer_requests_total{table="items",method="POST",status="200"} 1`,
+			`db_explorer_requests_total{table="",method="GET",status="404"} 1`,
+			`db_explorer_requests_total{table="_metrics",method="GET",status="403"} 1`,
+			`db_explorer_request_duration_seconds_count{table="items",method="GET",status="200"} 1`,
+			`# TYPE db_explorer_db_query_duration_seconds histogram`,
+			`db_explorer_db_query_duration_seconds_count{op="exec"}`,
+			`db_explorer_db_query_duration_seconds_count{op="query"}`,
+		} {
+			if !strings.Contains(body, line) {
+				t.Errorf("expected %s in\n%s", line, body)
+			}
+		}
+
+		lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+		if len(lines) != 6 {
+			t.Fatalf("expected a log line per request, got %v", lines)
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[5]), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry["principal"] != "root" || entry["path"] != "/_metrics" || entry["status"] != float64(200) || entry["remote"] != "127.0.0.1" {
+			t.Errorf("unexpected log entry %v", entry)
+		}
+		if _, ok := entry["duration_ms"].(float64); !ok {
+			t.Errorf("expected the duration in the log, got %v", entry)
+		}
+	})
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// openAPIPath serves the OpenAPI document: GET /_openapi.json
+const openAPIPath = "_openapi.json"
+
+// obj is a JSON object of the OpenAPI document
+type obj map[string]interface{}
+
+// columnSchema describes the JSON value of a column as processSelectRows
+// returns it and createRecord accepts it
+func (d *DbExplorer) columnSchema(c Col) obj {
+	s := obj{}
+	t := d.types.lookup(c)
+	if kind := t.Kind(); kind != "" {
+		s["type"] = kind
+	}
+	if f, ok := t.(formatter); ok && f.Format() != "" {
+		s["format"] = f.Format()
+	}
+	if e, ok := t.(enumType); ok && !e.set {
+		s["enum"] = c.typeArgs()
+	}
+	if s["type"] == "integer" && c.unsigned() {
+		s["minimum"] = 0
+	}
+	if c.Null {
+		s["nullable"] = true
+	}
+	return s
+}
+
+func ref(name string) obj {
+	return obj{"$ref": "#/components/schemas/" + name}
+}
+
+// recordSchemaName and writeSchemaName name the schemas of a table apart
+// from Error and the schemas of other tables
+func recordSchemaName(table string) string {
+	return "Table_" + table
+}
+
+func writeSchemaName(table string) string {
+	return "TableWrite_" + table
+}
+
+// envelope wraps a schema the way writeResponse does
+func envelope(s obj) obj {
+	return obj{
+		"type":       "object",
+		"properties": obj{"response": s},
+	}
+}
+
+func jsonContent(s obj) obj {
+	return obj{"application/json": obj{"schema": s}}
+}
+
+func okResponse(description string, s obj) obj {
+	return obj{"description": description, "content": jsonContent(envelope(s))}
+}
+
+// withResponses sets the 200 response of an operation and the error
+// responses it may return
+func withResponses(op obj, ok obj, errs ...int) obj {
+	responses := obj{"200": ok}
+	for _, status := range errs {
+		responses[strconv.Itoa(status)] = obj{
+			"description": http.StatusText(status),
+			"content":     jsonContent(ref("Error")),
+		}
+	}
+	op["responses"] = responses
+	return op
+}
+
+// limitSchema is the schema of the limit param, bounded by WithMaxLimit
+func (d *DbExplorer) limitSchema() obj {
+	s := obj{"type": "integer", "minimum": 0}
+	if d.maxLimit > 0 {
+		s["maximum"] = d.maxLimit
+	}
+	return s
+}
+
+func queryParam(name, description string, s obj) obj {
+	return obj{"name": name, "in": "query", "description": description, "schema": s}
+}
+
+// tableSchemas returns the record schema, every column the principal can
+// see, and the write schema without the columns filled in by the database
+func (d *DbExplorer) tableSchemas(p Principal, table string) (record, write obj) {
+	tab := d.visibleTable(p, table)
+	props := obj{}
+	writeProps := obj{}
+	required := make([]string, 0)
+	for _, c := range tab.Columns {
+		props[c.Name] = d.columnSchema(c)
+		if !c.Null {
+			required = append(required, c.Name)
+		}
+		if _, ok := tab.AutoIncrement[c.Name]; ok {
+			continue
+		}
+		if d.policy.columnWritable(p, table, c.Name) {
+			writeProps[c.Name] = d.columnSchema(c)
+		}
+	}
+	record = obj{"type": "object", "properties": props}
+	if len(required) > 0 {
+		record["required"] = required
+	}
+	return record, obj{"type": "object", "properties": writeProps}
+}
+
+func (d *DbExplorer) keySchema(tab Table) obj {
+	props := obj{}
+	for _, c := range tab.pkColumns() {
+		props[c.Name] = d.columnSchema(c)
+	}
+	return obj{"type": "object", "properties": props, "required": tab.PK}
+}
+
+func (d *DbExplorer) keyParam(tab Table) (name string, param obj) {
+	if len(tab.PK) == 1 {
+		c, _ := tab.column(tab.PK[0])
+		s := d.columnSchema(c)
+		delete(s, "nullable")
+		return c.Name, obj{"name": c.Name, "in": "path", "required": true, "schema": s}
+	}
+	return "key", obj{
+		"name":        "key",
+		"in":          "path",
+		"required":    true,
+		"description": "primary key parts separated by commas: " + strings.Join(tab.PK, ","),
+		"schema":      obj{"type": "string"},
+	}
+}
+
+func (d *DbExplorer) openAPI(p Principal) obj {
+	schemas := obj{
+		"Error": obj{
+			"type": "object",
+			"properties": obj{
+				"error": obj{"type": "string"},
+				"code":  obj{"type": "string"},
+				"field": obj{"type": "string"},
+			},
+			"required": []string{"error", "code"},
+		},
+	}
+	listParams := []obj{
+		{
+			"name":        "where",
+			"in":          "query",
+			"description": "filters as where[column]=value or where[column][op]=value, op is eq, ne, gt, gte, lt, lte, like, in with comma separated values or null with a boolean",
+			"style":       "deepObject",
+			"explode":     true,
+			"schema":      obj{"type": "object", "additionalProperties": obj{}},
+		},
+		queryParam("limit", "number of records, 5 by default", d.limitSchema()),
+		queryParam("offset", "number of records to skip", obj{"type": "integer", "minimum": 0}),
+		queryParam("fields", "comma separated columns to return", obj{"type": "string"}),
+		queryParam("order", "comma separated columns, - for descending order", obj{"type": "string"}),
+		queryParam("cursor", "keyset pagination, empty for the first page", obj{"type": "string"}),
+		queryParam("total", "add the total number of records", obj{"type": "boolean"}),
+		queryParam("expand", "comma separated relations to embed", obj{"type": "string"}),
+		queryParam("with_deleted", "include soft deleted records", obj{"type": "boolean"}),
+		queryParam("q", "search text columns for the term", obj{"type": "string"}),
+		queryParam("format", "export all matching records as csv or ndjson", obj{"type": "string", "enum": []string{formatCSV, formatNDJSON}}),
+	}
+
+	aggregateParams := []obj{
+		queryParam("agg", "comma separated count(*), count, min, max, sum or avg of columns", obj{"type": "string"}),
+		queryParam("group", "comma separated columns to group by", obj{"type": "string"}),
+	}
+	childParams := []obj{
+		queryParam("via", "the relation to the parent when there are several", obj{"type": "string"}),
+	}
+	for _, param := range listParams {
+		switch param["name"] {
+		case "where", "limit", "offset", "order", "with_deleted", "q":
+			aggregateParams = append(aggregateParams, param)
+		}
+		if param["name"] != "format" {
+			childParams = append(childParams, param)
+		}
+	}
+
+	paths := obj{}
+	for _, table := range d.tables {
+		if !d.policy.canRead(p, table) {
+			continue
+		}
+		tab := d.columns[table]
+		record, write := d.tableSchemas(p, table)
+		recordName, writeName := recordSchemaName(table), writeSchemaName(table)
+		schemas[recordName] = record
+		schemas[writeName] = write
+
+		collection := obj{
+			"get": withResponses(obj{
+				"summary":    "list " + table,
+				"tags":       []string{table},
+				"parameters": listParams,
+			}, d.listResponse(table), http.StatusBadRequest, http.StatusForbidden),
+		}
+		paths["/"+table] = collection
+		paths["/"+table+"/"+aggregatePath] = obj{
+			"get": withResponses(obj{
+				"summary":    "aggregate " + table,
+				"tags":       []string{table},
+				"parameters": aggregateParams,
+			}, okResponse("groups", obj{
+				"type": "object",
+				"properties": obj{
+					"groups": obj{"type": "array", "items": obj{"type": "object"}},
+				},
+			}), http.StatusBadRequest, http.StatusForbidden),
+		}
+		if len(tab.PK) == 0 {
+			continue
+		}
+
+		keyName, keyParam := d.keyParam(tab)
+		item := obj{
+			"parameters": []obj{keyParam},
+			"get": withResponses(obj{
+				"summary": "get " + table + " record",
+				"tags":    []string{table},
+				"parameters": []obj{
+					queryParam("expand", "comma separated relations to embed", obj{"type": "string"}),
+					queryParam("with_deleted", "get the record even if it is soft deleted", obj{"type": "boolean"}),
+				},
+			}, okResponse("record", obj{
+				"type":       "object",
+				"properties": obj{"record": ref(recordName)},
+			}), http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound),
+		}
+		if d.policy.canWrite(p, table) {
+			collection["put"] = withResponses(obj{
+				"summary": "create " + table + " records, one, an array in one transaction or an import",
+				"tags":    []string{table},
+				"requestBody": obj{"required": true, "content": obj{
+					"application/json": obj{"schema": obj{"oneOf": []obj{
+						ref(writeName),
+						{"type": "array", "items": ref(writeName)},
+					}}},
+					"text/csv":             obj{"schema": obj{"type": "string"}},
+					"application/x-ndjson": obj{"schema": obj{"type": "string"}},
+				}},
+			}, okResponse("key of the new record, keys of the new records or the import report", obj{"oneOf": []obj{
+				d.keySchema(tab),
+				{
+					"type": "object",
+					"properties": obj{
+						"inserted": obj{"type": "integer"},
+						"keys":     obj{"type": "array", "items": d.keySchema(tab)},
+					},
+				},
+				{
+					"type": "object",
+					"properties": obj{
+						"inserted": obj{"type": "integer"},
+						"errors": obj{"type": "array", "items": obj{
+							"type": "object",
+							"properties": obj{
+								"line":  obj{"type": "integer"},
+								"error": obj{"type": "string"},
+								"code":  obj{"type": "string"},
+								"field": obj{"type": "string"},
+							},
+						}},
+					},
+				},
+			}}), http.StatusBadRequest, http.StatusForbidden, http.StatusConflict, http.StatusTooManyRequests)
+			item["post"] = withResponses(obj{
+				"summary":     "update " + table + " record",
+				"tags":        []string{table},
+				"requestBody": obj{"required": true, "content": jsonContent(ref(writeName))},
+			}, okResponse("number of updated records", obj{
+				"type":       "object",
+				"properties": obj{"updated": obj{"type": "integer"}},
+			}), http.StatusBadRequest, http.StatusForbidden, http.StatusPreconditionFailed)
+			item["put"] = withResponses(obj{
+				"summary":     "replace " + table + " record, omitted columns are reset",
+				"tags":        []string{table},
+				"requestBody": obj{"required": true, "content": jsonContent(ref(writeName))},
+			}, okResponse("number of updated records", obj{
+				"type":       "object",
+				"properties": obj{"updated": obj{"type": "integer"}},
+			}), http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusPreconditionFailed)
+			item["patch"] = withResponses(obj{
+				"summary": "patch " + table + " record",
+				"tags":    []string{table},
+				"requestBody": obj{"required": true, "content": obj{
+					mergePatchType: obj{"schema": ref(writeName)},
+					jsonPatchType:  obj{"schema": obj{"type": "array", "items": obj{"type": "object"}}},
+				}},
+			}, okResponse("number of updated records", obj{
+				"type":       "object",
+				"properties": obj{"updated": obj{"type": "integer"}},
+			}), http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed)
+			item["delete"] = withResponses(obj{
+				"summary": "delete " + table + " record",
+				"tags":    []string{table},
+			}, okResponse("number of deleted records", obj{
+				"type":       "object",
+				"properties": obj{"deleted": obj{"type": "integer"}},
+			}), http.StatusBadRequest, http.StatusForbidden, http.StatusPreconditionFailed)
+			if tab.softDelete != nil {
+				paths["/"+table+"/{"+keyName+"}/"+restorePath] = obj{
+					"parameters": []obj{keyParam},
+					"post": withResponses(obj{
+						"summary": "restore soft deleted " + table + " record",
+						"tags":    []string{table},
+					}, okResponse("number of restored records", obj{
+						"type":       "object",
+						"properties": obj{"restored": obj{"type": "integer"}},
+					}), http.StatusBadRequest, http.StatusForbidden),
+				}
+			}
+		}
+		paths["/"+table+"/{"+keyName+"}"] = item
+
+		for _, child := range d.tables {
+			if !d.policy.canRead(p, child) || !d.referencesTable(child, table) {
+				continue
+			}
+			paths["/"+table+"/{"+keyName+"}/"+child] = obj{
+				"parameters": []obj{keyParam},
+				"get": withResponses(obj{
+					"summary":    "list " + child + " records referencing the " + table + " record",
+					"tags":       []string{table, child},
+					"parameters": childParams,
+				}, d.listResponse(child), http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound),
+			}
+		}
+	}
+
+	paths["/"] = obj{
+		"get": withResponses(obj{"summary": "list tables"}, okResponse("tables", obj{
+			"type": "object",
+			"properties": obj{
+				"tables": obj{"type": "array", "items": obj{"type": "string"}},
+			},
+		})),
+	}
+	paths["/"+openAPIPath] = obj{
+		"get": withResponses(obj{"summary": "this document, as the principal sees the API"}, obj{
+			"description": "OpenAPI document",
+			"content":     jsonContent(obj{"type": "object"}),
+		}),
+	}
+	paths["/"+batchPath] = obj{
+		"post": withResponses(obj{
+			"summary": "create, update and delete records across tables in one transaction",
+			"requestBody": obj{"required": true, "content": jsonContent(obj{
+				"type": "array",
+				"items": obj{
+					"type": "object",
+					"properties": obj{
+						"op":     obj{"type": "string", "enum": []string{"create", "update", "delete"}},
+						"table":  obj{"type": "string"},
+						"key":    obj{"description": "the primary key, an object of its columns for composite keys"},
+						"record": obj{"type": "object"},
+					},
+					"required": []string{"op", "table"},
+				},
+			})},
+		}, okResponse("the result of every operation", obj{
+			"type": "object",
+			"properties": obj{
+				"results": obj{"type": "array", "items": obj{"type": "object"}},
+			},
+		}), http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusTooManyRequests),
+	}
+	if d.policy.canAdmin(p) {
+		paths["/"+schemaPath] = obj{
+			"post": withResponses(obj{
+				"summary": "reload the schema of the database",
+			}, okResponse("tables", obj{
+				"type": "object",
+				"properties": obj{
+					"tables": obj{"type": "array", "items": obj{"type": "string"}},
+				},
+			}), http.StatusForbidden),
+		}
+	}
+	if d.audit != nil && d.policy.canAdmin(p) {
+		paths["/"+auditPath] = obj{
+			"get": withResponses(obj{
+				"summary": "browse the audit log, the newest entries first",
+				"parameters": []obj{
+					queryParam("table", "entries of the table", obj{"type": "string"}),
+					queryParam("pk", "entries of the record, requires table", obj{"type": "string"}),
+					queryParam("limit", "number of entries, 5 by default", d.limitSchema()),
+					queryParam("offset", "number of entries to skip", obj{"type": "integer", "minimum": 0}),
+				},
+			}, okResponse("entries", obj{
+				"type": "object",
+				"properties": obj{
+					"entries": obj{"type": "array", "items": obj{
+						"type": "object",
+						"properties": obj{
+							"time":      obj{"type": "string", "format": "date-time"},
+							"principal": obj{"type": "string"},
+							"op":        obj{"type": "string", "enum": []string{AuditInsert, AuditUpdate, AuditDelete, AuditRestore}},
+							"table":     obj{"type": "string"},
+							"pk":        obj{"type": "string"},
+							"before":    obj{"type": "object", "nullable": true},
+							"after":     obj{"type": "object", "nullable": true},
+						},
+					}},
+				},
+			}), http.StatusBadRequest, http.StatusForbidden),
+		}
+	}
+	if d.cache != nil && d.policy.canAdmin(p) {
+		paths["/"+cachePath] = obj{
+			"get": withResponses(obj{
+				"summary": "counters of the record cache",
+			}, okResponse("counters", obj{
+				"type": "object",
+				"properties": obj{
+					"hits":    obj{"type": "integer"},
+					"misses":  obj{"type": "integer"},
+					"entries": obj{"type": "integer"},
+					"size":    obj{"type": "integer"},
+				},
+			}), http.StatusForbidden),
+		}
+	}
+	if d.metrics != nil && d.policy.canAdmin(p) {
+		paths["/"+metricsPath] = obj{
+			"get": withResponses(obj{
+				"summary": "request and query metrics in the Prometheus text format",
+			}, obj{
+				"description": "metrics",
+				"content":     obj{"text/plain": obj{"schema": obj{"type": "string"}}},
+			}, http.StatusForbidden),
+		}
+	}
+	paths["/"+changesPath] = obj{
+		"get": withResponses(obj{
+			"summary": "stream changes as server-sent events",
+			"parameters": []obj{
+				queryParam("tables", "comma separated tables, all readable tables by default", obj{"type": "string"}),
+				queryParam("last_event_id", "resume after the event, like the Last-Event-ID header", obj{"type": "string"}),
+			},
+		}, obj{
+			"description": "change events with table, op and pk, reset events when changes were missed",
+			"content":     obj{"text/event-stream": obj{"schema": obj{"type": "string"}}},
+		}, http.StatusForbidden, http.StatusNotFound),
+	}
+	paths["/"+searchPath] = obj{
+		"get": withResponses(obj{
+			"summary": "search all tables",
+			"parameters": []obj{
+				queryParam("q", "the term to search text columns for", obj{"type": "string"}),
+				queryParam("limit", "number of records of each table, 5 by default", d.limitSchema()),
+			},
+		}, okResponse("records with their tables", obj{
+			"type": "object",
+			"properties": obj{
+				"results": obj{"type": "array", "items": obj{
+					"type": "object",
+					"properties": obj{
+						"table":  obj{"type": "string"},
+						"record": obj{"type": "object"},
+					},
+				}},
+			},
+		}), http.StatusBadRequest),
+	}
+	return obj{
+		"openapi": "3.0.3",
+		"info": obj{
+			"title":   "db_explorer",
+			"version": "1.0.0",
+		},
+		"paths":      paths,
+		"components": obj{"schemas": schemas},
+	}
+}
+
+// listResponse is the page of records GET /$table and the child lists return
+func (d *DbExplorer) listResponse(table string) obj {
+	return okResponse("records", obj{
+		"type": "object",
+		"properties": obj{
+			"records":     obj{"type": "array", "items": ref(recordSchemaName(table))},
+			"next_cursor": obj{"type": "string", "nullable": true},
+			"total":       obj{"type": "integer"},
+		},
+	})
+}
+
+// referencesTable tells whether the child has a foreign key to the parent
+func (d *DbExplorer) referencesTable(child, parent string) bool {
+	for _, fk := range d.columns[child].ForeignKeys {
+		if fk.RefTable == parent {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *DbExplorer) getOpenAPI(w http.ResponseWriter, r *http.Request) (err error) {
+	bs, err := json.Marshal(d.openAPI(principalFrom(r)))
+	if err != nil {
+		return errorInternal
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bs)
+	return
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func getOpenAPIDocument(t *testing.T, ts *httptest.Server) map[string]interface{} {
+	resp, err := client.Get(ts.URL + "/_openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected http status 200, got %d", resp.StatusCode)
+	}
+	var doc map[string]interface{}
+	if err = json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// lookup follows the keys through nested objects of a JSON document
+func lookup(v interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		m, _ := v.(map[string]interface{})
+		v = m[k]
+	}
+	return v
+}
+
+func TestOpenAPI(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, nil,
+			WithAuthenticator(APIKeyAuthenticator{"admin-key": Principal{Name: "root"}}),
+			WithPolicy(Policy{Tables: map[string]TableRule{
+				"items": TableRule{Read: []string{anyone}, Write: []string{"root"}},
+				"users": TableRule{Read: []string{"root"}, Write: []string{"root"}},
+			}}),
+		)
+
+		doc := getOpenAPIDocument(t, s.as(t, "X-API-Key", "admin-key").Server)
+
+		if doc["openapi"] != "3.0.3" {
+			t.Errorf("unexpected openapi version %v", doc["openapi"])
+		}
+		paths := doc["paths"].(map[string]interface{})
+		for _, path := range []string{"/", "/items", "/items/{id}", "/users", "/users/{user_id}"} {
+			if _, ok := paths[path]; !ok {
+				t.Errorf("path %s is missing", path)
+			}
+		}
+		for _, method := range []string{"get", "put"} {
+			if _, ok := paths["/items"].(map[string]interface{})[method]; !ok {
+				t.Errorf("%s /items is missing", method)
+			}
+		}
+
+		schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+		items := schemas["Table_items"].(map[string]interface{})
+		expected := map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"id":          map[string]interface{}{"type": "integer"},
+				"title":       map[string]interface{}{"type": "string"},
+				"description": map[string]interface{}{"type": "string"},
+				"updated":     map[string]interface{}{"type": "string", "nullable": true},
+			},
+			"required": []interface{}{"id", "title", "description"},
+		}
+		if !reflect.DeepEqual(items, expected) {
+			t.Errorf("items schema:\nexpected %#v\ngot %#v", expected, items)
+		}
+		write := schemas["TableWrite_items"].(map[string]interface{})["properties"].(map[string]interface{})
+		if _, ok := write["id"]; ok {
+			t.Errorf("auto increment id must not be writable")
+		}
+
+		doc = getOpenAPIDocument(t, s.Server)
+		paths = doc["paths"].(map[string]interface{})
+		if _, ok := paths["/users"]; ok {
+			t.Errorf("anonymous document describes the users table")
+		}
+		if _, ok := paths["/items"].(map[string]interface{})["put"]; ok {
+			t.Errorf("anonymous document allows creating items")
+		}
+	})
+}
+
+func TestColumnSchema(t *testing.T) {
+	d := &DbExplorer{types: defaultTypes()}
+	cases := []struct {
+		col    Col
+		schema obj
+	}{
+		{Col{Type: "longblob"}, obj{"type": "string", "format": "byte"}},
+		{Col{Type: "mediumblob"}, obj{"type": "string", "format": "byte"}},
+		{Col{Type: "varbinary", Def: "varbinary(16)", Null: true}, obj{"type": "string", "format": "byte", "nullable": true}},
+		{Col{Type: "datetime"}, obj{"type": "string", "format": "date-time"}},
+		{Col{Type: "timestamp"}, obj{"type": "string", "format": "date-time"}},
+		{Col{Type: "date"}, obj{"type": "string", "format": "date"}},
+		{Col{Type: "time"}, obj{"type": "string", "format": "time"}},
+		{Col{Type: "decimal", Def: "decimal(10,2)"}, obj{"type": "string", "format": "decimal"}},
+		{Col{Type: "enum", Def: "enum('S','M')"}, obj{"type": "string", "enum": []string{"S", "M"}}},
+		{Col{Type: "set", Def: "set('a','b')"}, obj{"type": "string"}},
+		{Col{Type: "int", Def: "int(10) unsigned"}, obj{"type": "integer", "minimum": 0}},
+		{Col{Type: "geometry"}, obj{}},
+	}
+	for _, c := range cases {
+		if s := d.columnSchema(c.col); !reflect.DeepEqual(s, c.schema) {
+			t.Errorf("[%s] expected %v, got %v", c.col.Type, c.schema, s)
+		}
+	}
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, []string{
+			`CREATE TABLE posts (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  author_id int DEFAULT NULL,
+  title varchar(255) NOT NULL,
+  FOREIGN KEY (author_id) REFERENCES users (user_id)
+);`,
+			`CREATE TABLE Error (id INTEGER PRIMARY KEY AUTOINCREMENT, message text NOT NULL);`,
+		},
+			WithAuthenticator(APIKeyAuthenticator{"admin-key": Principal{Name: "root"}}),
+			WithPolicy(Policy{
+				Tables: map[string]TableRule{anyone: TableRule{Read: []string{anyone}, Write: []string{anyone}}},
+				Admin:  []string{"root"},
+			}),
+		)
+
+		doc := getOpenAPIDocument(t, s.as(t, "X-API-Key", "admin-key").Server)
+		for _, path := range []string{"/_openapi.json", "/_batch", "/_schema", "/users/{user_id}/posts"} {
+			if lookup(doc, "paths", path) == nil {
+				t.Errorf("path %s is missing", path)
+			}
+		}
+		if lookup(doc, "paths", "/items/{id}/posts") != nil {
+			t.Errorf("posts do not reference items")
+		}
+
+		names := map[interface{}]bool{}
+		params, _ := lookup(doc, "paths", "/users/{user_id}/posts", "get", "parameters").([]interface{})
+		for _, param := range params {
+			names[lookup(param, "name")] = true
+		}
+		for _, name := range []string{"via", "where", "order", "fields", "cursor", "q"} {
+			if !names[name] {
+				t.Errorf("child list parameter %s is missing", name)
+			}
+		}
+		names = map[interface{}]bool{}
+		params, _ = lookup(doc, "paths", "/items", "get", "parameters").([]interface{})
+		for _, param := range params {
+			names[lookup(param, "name")] = true
+			if lookup(param, "name") == "where" && lookup(param, "style") != "deepObject" {
+				t.Errorf("where must be a deepObject parameter, got %v", param)
+			}
+		}
+		for _, name := range []string{"where", "order", "fields", "cursor", "q"} {
+			if !names[name] {
+				t.Errorf("list parameter %s is missing", name)
+			}
+		}
+
+		content := lookup(doc, "paths", "/items", "put", "requestBody", "content")
+		for _, typ := range []string{"application/json", "text/csv", "application/x-ndjson"} {
+			if lookup(content, typ) == nil {
+				t.Errorf("PUT /items does not accept %s", typ)
+			}
+		}
+
+		// a table named Error does not replace the error schema
+		schemas := lookup(doc, "components", "schemas")
+		if lookup(schemas, "Error", "properties", "code") == nil {
+			t.Errorf("the Error schema was overwritten: %v", lookup(schemas, "Error"))
+		}
+		if lookup(schemas, "Table_Error", "properties", "message") == nil {
+			t.Errorf("the schema of the Error table is missing")
+		}
+
+		// only admins see the schema reload
+		if lookup(getOpenAPIDocument(t, s.Server), "paths", "/_schema") != nil {
+			t.Errorf("anonymous document describes /_schema")
+		}
+	})
+}
+
+func TestOpenAPISoftDelete(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, []string{
+			`CREATE TABLE notes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  body text NOT NULL,
+  deleted_at datetime DEFAULT NULL
+);`,
+		}, WithAuditFile(filepath.Join(t.TempDir(), "audit.jsonl")))
+		doc := getOpenAPIDocument(t, s.Server)
+
+		if lookup(doc, "paths", "/notes/{id}/_restore", "post") == nil {
+			t.Errorf("POST /notes/{id}/_restore is missing")
+		}
+		found := false
+		params, _ := lookup(doc, "paths", "/notes/{id}", "get", "parameters").([]interface{})
+		for _, param := range params {
+			found = found || lookup(param, "name") == "with_deleted"
+		}
+		if !found {
+			t.Errorf("GET /notes/{id} misses with_deleted")
+		}
+		entries := lookup(doc, "paths", "/_audit", "get", "responses", "200", "content", "application/json", "schema", "properties", "response", "properties", "entries")
+		ops := lookup(entries, "items", "properties", "op", "enum")
+		if !reflect.DeepEqual(ops, []interface{}{AuditInsert, AuditUpdate, AuditDelete, AuditRestore}) {
+			t.Errorf("unexpected audit ops %v", ops)
+		}
+	})
+}
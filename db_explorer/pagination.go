@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+var (
+	errorInvalidCursor = &apiError{status: http.StatusBadRequest, code: CodeBadRequest, msg: "invalid cursor"}
+)
+
+// encodeCursor makes an opaque cursor out of the primary key of the last row
+func encodeCursor(key ...interface{}) string {
+	parts := make([]string, len(key))
+	for i, v := range key {
+		parts[i] = fmt.Sprint(v)
+	}
+	bs, _ := json.Marshal(parts)
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func (d *DbExplorer) decodeCursor(cols []Col, cursor string) (key []interface{}, err error) {
+	bs, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errorInvalidCursor
+	}
+	var parts []string
+	if err = json.Unmarshal(bs, &parts); err != nil || len(parts) != len(cols) {
+		return nil, errorInvalidCursor
+	}
+	for i, c := range cols {
+		v, err := d.types.parse(c, parts[i])
+		if err != nil {
+			return nil, errorInvalidCursor
+		}
+		key = append(key, v)
+	}
+	return key, nil
+}
+
+// parsePagination enables keyset pagination when the cursor parameter is
+// present, an empty cursor requests the first page.
+func (d *DbExplorer) parsePagination(r *http.Request, tab Table, lq *listQuery) (err error) {
+	params := r.URL.Query()
+	if total := params.Get("total"); total != "" {
+		lq.total, err = strconv.ParseBool(total)
+		if err != nil {
+			return badRequest("total expects a boolean")
+		}
+	}
+	if _, ok := params["cursor"]; !ok {
+		return nil
+	}
+	if len(tab.PK) == 0 {
+		return badRequest("cursor pagination requires a primary key")
+	}
+	if len(lq.order) > 0 {
+		return badRequest("order is not supported with cursor pagination")
+	}
+	pks := tab.pkColumns()
+	lq.cursor = true
+	lq.offset = 0
+	if cursor := params.Get("cursor"); cursor != "" {
+		key, err := d.decodeCursor(pks, cursor)
+		if err != nil {
+			return err
+		}
+		lq.after = key
+		lq.where = append(lq.where, condition{op: "after", value: keyset{cols: pks, key: key}})
+	}
+	for _, c := range pks {
+		lq.order = append(lq.order, orderBy{col: c})
+	}
+	return nil
+}
+
+func (d *DbExplorer) countRows(table string, where []condition) (total int, err error) {
+	args := &sqlArgs{dialect: d.dialect}
+	q := fmt.Sprintf("SELECT COUNT(*) FROM %s", d.quote(table)) + d.whereSQL(where, args)
+	rows, err := d.reader().Query(q, args.args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		err = rows.Scan(&total)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return total, rows.Err()
+}
+
+func (d *DbExplorer) listPage(table string, lq listQuery) (response map[string]interface{}, err error) {
+	tab := d.columns[table]
+	page := lq
+	// primary key columns added to the projection only to build the cursor
+	extra := make([]string, 0)
+	if lq.cursor {
+		// one extra row tells whether there is a next page
+		page.limit = lq.limit + 1
+		if len(lq.fields) > 0 {
+			page.fields = append([]Col{}, lq.fields...)
+			for _, pk := range tab.pkColumns() {
+				requested := false
+				for _, c := range lq.fields {
+					requested = requested || c.Name == pk.Name
+				}
+				if !requested {
+					page.fields = append(page.fields, pk)
+					extra = append(extra, pk.Name)
+				}
+			}
+		}
+	}
+
+	result, err := d.selectList(table, page)
+	if err != nil {
+		return nil, err
+	}
+	response = map[string]interface{}{"records": result}
+
+	if lq.cursor {
+		var next interface{}
+		if len(result) > lq.limit {
+			result = result[:lq.limit]
+			if lq.limit > 0 {
+				last := result[len(result)-1]
+				key := make([]interface{}, len(tab.PK))
+				for i, name := range tab.PK {
+					key[i] = last[name]
+				}
+				next = encodeCursor(key...)
+			}
+		}
+		for _, rec := range result {
+			for _, name := range extra {
+				delete(rec, name)
+			}
+		}
+		response["records"] = result
+		response["next_cursor"] = next
+	}
+
+	if lq.total {
+		where := lq.where
+		if lq.after != nil {
+			// the cursor condition is always appended last
+			where = where[:len(where)-1]
+		}
+		total, err := d.countRows(table, where)
+		if err != nil {
+			return nil, err
+		}
+		response["total"] = total
+	}
+	return response, nil
+}
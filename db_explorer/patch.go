@@ -0,0 +1,515 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+var (
+	errorPatchPath = &apiError{status: http.StatusConflict, code: CodePatchConflict, msg: "path not found"}
+	errorPatchTest = &apiError{status: http.StatusConflict, code: CodePatchConflict, msg: "test failed"}
+	errorMediaType = &apiError{status: http.StatusUnsupportedMediaType, code: CodeUnsupportedMedia, msg: "unsupported content type"}
+)
+
+// jsonPatchOp is one operation of an RFC 6902 JSON Patch document, Value
+// is kept raw to tell an explicit null from a missing value
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+func invalidPatch(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusBadRequest, code: CodeInvalidPatch, msg: fmt.Sprintf(format, args...)}
+}
+
+// writeTarget resolves /$table/$id of a request that changes a record
+func (d *DbExplorer) writeTarget(r *http.Request) (table string, key []interface{}, err error) {
+	arr := extractPartsOfPath(r)
+	if len(arr) != 2 {
+		return "", nil, errorUnknownPath
+	}
+	table = arr[0]
+	tab, ok := d.columns[table]
+	if !ok {
+		return "", nil, errorUnknownTable
+	}
+	if len(tab.PK) == 0 {
+		return "", nil, errorReadOnlyTable
+	}
+	if !d.policy.canWrite(principalFrom(r), table) {
+		return "", nil, errorForbidden
+	}
+	key, err = d.parseRecordKey(r, tab, arr[1])
+	return table, key, err
+}
+
+// mergePatch applies an RFC 7396 merge patch to a JSON value
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// pointerTokens splits an RFC 6901 JSON pointer, the whole record can't be
+// replaced so the empty pointer is rejected
+func pointerTokens(pointer string) ([]string, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, invalidPatch("invalid path %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses an array token, "-" and len(arr) are only valid when
+// adding
+func arrayIndex(arr []interface{}, token string, adding bool) (int, error) {
+	if adding && token == "-" {
+		return len(arr), nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > len(arr) || (i == len(arr) && !adding) {
+		return 0, errorPatchPath
+	}
+	return i, nil
+}
+
+func getAt(node interface{}, tokens []string) (interface{}, error) {
+	for _, token := range tokens {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			v, ok := n[token]
+			if !ok {
+				return nil, errorPatchPath
+			}
+			node = v
+		case []interface{}:
+			i, err := arrayIndex(n, token, false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, errorPatchPath
+		}
+	}
+	return node, nil
+}
+
+// updateAt replaces the parent of the last token with what fn returns,
+// arrays are rebuilt on the way up
+func updateAt(node interface{}, tokens []string, fn func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return fn(node, tokens[0])
+	}
+	child, err := getAt(node, tokens[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = updateAt(child, tokens[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	switch n := node.(type) {
+	case map[string]interface{}:
+		n[tokens[0]] = child
+	case []interface{}:
+		i, _ := arrayIndex(n, tokens[0], false)
+		n[i] = child
+	}
+	return node, nil
+}
+
+func addAt(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	return updateAt(doc, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			p[token] = value
+			return p, nil
+		case []interface{}:
+			i, err := arrayIndex(p, token, true)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = value
+			return p, nil
+		}
+		return nil, errorPatchPath
+	})
+}
+
+func removeAt(doc interface{}, tokens []string) (interface{}, error) {
+	return updateAt(doc, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := p[token]; !ok {
+				return nil, errorPatchPath
+			}
+			delete(p, token)
+			return p, nil
+		case []interface{}:
+			i, err := arrayIndex(p, token, false)
+			if err != nil {
+				return nil, err
+			}
+			return append(p[:i], p[i+1:]...), nil
+		}
+		return nil, errorPatchPath
+	})
+}
+
+// deepCopy copies a decoded JSON value so copy doesn't alias the source
+func deepCopy(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = deepCopy(item)
+		}
+		return m
+	case []interface{}:
+		arr := make([]interface{}, len(v))
+		for i, item := range v {
+			arr[i] = deepCopy(item)
+		}
+		return arr
+	}
+	return v
+}
+
+// applyPatchOp applies one JSON Patch operation to a record. Columns can't
+// disappear from a record, removing one sets it to null.
+func applyPatchOp(doc map[string]interface{}, op jsonPatchOp) (err error) {
+	tokens, err := pointerTokens(op.Path)
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return invalidPatch("value is required for %s", op.Op)
+		}
+		if err = decodeJSON(op.Value, &value); err != nil {
+			return errorInvalidJSON
+		}
+	case "move", "copy":
+		from, err := pointerTokens(op.From)
+		if err != nil {
+			return err
+		}
+		if value, err = getAt(doc, from); err != nil {
+			return err
+		}
+		value = deepCopy(value)
+		if op.Op == "move" {
+			if err = removeColumnAt(doc, from); err != nil {
+				return err
+			}
+		}
+	case "remove":
+		return removeColumnAt(doc, tokens)
+	default:
+		return invalidPatch("unknown operation %s", op.Op)
+	}
+
+	switch op.Op {
+	case "test":
+		current, err := getAt(doc, tokens)
+		if err != nil {
+			return err
+		}
+		if !jsonEqual(current, value) {
+			return errorPatchTest
+		}
+		return nil
+	case "replace":
+		if _, err = getAt(doc, tokens); err != nil {
+			return err
+		}
+	}
+	_, err = addAt(doc, tokens, value)
+	return err
+}
+
+func removeColumnAt(doc map[string]interface{}, tokens []string) error {
+	if len(tokens) == 1 {
+		if _, ok := doc[tokens[0]]; !ok {
+			return errorPatchPath
+		}
+		doc[tokens[0]] = nil
+		return nil
+	}
+	_, err := removeAt(doc, tokens)
+	return err
+}
+
+// jsonEqual compares decoded JSON values, numbers are compared by value
+func jsonEqual(a, b interface{}) bool {
+	abs, _ := json.Marshal(a)
+	bbs, _ := json.Marshal(b)
+	var av, bv interface{}
+	json.Unmarshal(abs, &av)
+	json.Unmarshal(bbs, &bv)
+	return reflect.DeepEqual(av, bv)
+}
+
+// parsePatch reads a merge patch or a JSON Patch depending on the content
+// type, plain application/json is treated as a merge patch
+func parsePatch(r *http.Request) (apply func(doc map[string]interface{}) error, err error) {
+	mediaType := mergePatchType
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mediaType, _, err = mime.ParseMediaType(ct); err != nil {
+			return nil, errorMediaType
+		}
+	}
+	defer r.Body.Close()
+	bs, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, errorInternal
+	}
+
+	switch mediaType {
+	case mergePatchType, "application/json":
+		var patch interface{}
+		if err = decodeJSON(bs, &patch); err != nil {
+			return nil, errorInvalidJSON
+		}
+		obj, ok := patch.(map[string]interface{})
+		if !ok {
+			return nil, errorObjectExpected
+		}
+		return func(doc map[string]interface{}) error {
+			for k, v := range obj {
+				if v == nil {
+					doc[k] = nil
+					continue
+				}
+				doc[k] = mergePatch(doc[k], v)
+			}
+			return nil
+		}, nil
+	case jsonPatchType:
+		var ops []jsonPatchOp
+		if err = decodeJSON(bs, &ops); err != nil {
+			return nil, errorInvalidJSON
+		}
+		return func(doc map[string]interface{}) error {
+			for i, op := range ops {
+				if err := applyPatchOp(doc, op); err != nil {
+					return fmt.Errorf("operation %d: %w", i, err)
+				}
+			}
+			return nil
+		}, nil
+	}
+	return nil, errorMediaType
+}
+
+// patchDocument is the record as the principal sees it, with values
+// in their JSON form so patches work on what GET returns
+func (d *DbExplorer) patchDocument(p Principal, table string, row map[string]interface{}) (doc map[string]interface{}, err error) {
+	visible := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		visible[k] = v
+	}
+	d.hideColumns(p, table, []map[string]interface{}{visible})
+	bs, err := json.Marshal(visible)
+	if err != nil {
+		return nil, err
+	}
+	err = decodeJSON(bs, &doc)
+	return doc, err
+}
+
+// modifyRecord applies a patch to the current record and updates the
+// columns it changed, validated the same way createRecord does
+func (d *DbExplorer) modifyRecord(p Principal, table string, key []interface{}, ifMatch string, apply func(doc map[string]interface{}) error) (updated int, etag string, err error) {
+	tx, err := d.begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	rows, err := d.lockRow(tx, table, key)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(rows) == 0 {
+		if ifMatch != "" {
+			return 0, "", errorPreconditionFailed
+		}
+		return 0, "", errorRecordNotFound
+	}
+	current := rows[0]
+	if d.columns[table].isDeleted(current) {
+		return 0, "", errorRecordNotFound
+	}
+	etag = d.recordETag(table, current)
+	if ifMatch != "" && !etagMatches(ifMatch, etag) {
+		return 0, "", errorPreconditionFailed
+	}
+
+	before, err := d.patchDocument(p, table, current)
+	if err != nil {
+		return 0, "", err
+	}
+	doc, err := d.patchDocument(p, table, current)
+	if err != nil {
+		return 0, "", err
+	}
+	if err = apply(doc); err != nil {
+		return 0, "", err
+	}
+	rawRecord := make(map[string]interface{})
+	for k, v := range doc {
+		if old, ok := before[k]; !ok || !reflect.DeepEqual(old, v) {
+			rawRecord[k] = v
+		}
+	}
+	// primary key can't be changed for an existing record
+	for _, c := range d.columns[table].pkColumns() {
+		if _, ok := rawRecord[c.Name]; ok {
+			return 0, "", fieldError(c, errorInvalidType)
+		}
+	}
+	// only DELETE and _restore change the soft delete column
+	if c := d.columns[table].softDelete; c != nil {
+		delete(rawRecord, c.Name)
+	}
+	record, err := d.createRecord(p, table, rawRecord)
+	if err != nil {
+		return 0, "", err
+	}
+	if !d.columns[table].setsColumns(record) {
+		return 0, etag, nil
+	}
+
+	updated, etag, err = d.updateVersion(tx, table, key, record, current)
+	if err != nil {
+		return 0, "", err
+	}
+	return updated, etag, tx.Commit()
+}
+
+// patchRecord serves PATCH /$table/$id
+func (d *DbExplorer) patchRecord(w http.ResponseWriter, r *http.Request) (err error) {
+	table, key, err := d.writeTarget(r)
+	if err != nil {
+		return err
+	}
+	apply, err := parsePatch(r)
+	if err != nil {
+		return err
+	}
+	updated, etag, err := d.modifyRecord(principalFrom(r), table, key, r.Header.Get("If-Match"), apply)
+	if err != nil {
+		return d.dbError(err)
+	}
+	w.Header().Set("ETag", etag)
+	writeResponse(w, finalResponse{Response: map[string]interface{}{"updated": updated}})
+	return
+}
+
+// replaceRecord serves PUT /$table/$id: columns missing from the body are
+// reset to null or, for NOT NULL columns, to the zero value of their type
+func (d *DbExplorer) replaceRecord(w http.ResponseWriter, r *http.Request) (err error) {
+	table, key, err := d.writeTarget(r)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	bs, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorInternal
+	}
+	var rawRecord map[string]interface{}
+	if err = decodeJSON(bs, &rawRecord); err != nil {
+		return errorInvalidJSON
+	}
+
+	p := principalFrom(r)
+	tab := d.columns[table]
+	// the key may be repeated in the body but not changed
+	for i, c := range tab.pkColumns() {
+		if v, ok := rawRecord[c.Name]; ok {
+			if fmt.Sprint(v) != fmt.Sprint(key[i]) {
+				return fieldError(c, errorInvalidType)
+			}
+			delete(rawRecord, c.Name)
+		}
+	}
+	record, err := d.createRecord(p, table, rawRecord)
+	if err != nil {
+		return err
+	}
+	for _, c := range tab.Columns {
+		if _, ok := record[c.Name]; ok || c.PK || c.Name == d.version {
+			continue
+		}
+		// only DELETE and _restore change the soft delete column
+		if tab.softDelete != nil && c.Name == tab.softDelete.Name {
+			continue
+		}
+		if _, ok := tab.AutoIncrement[c.Name]; ok {
+			continue
+		}
+		// columns the principal can't change are left as they are
+		if !d.policy.columnReadable(p, table, c.Name) || !d.policy.columnWritable(p, table, c.Name) {
+			continue
+		}
+		if c.Null {
+			record[c.Name] = nil
+			continue
+		}
+		v, ok := d.types.lookup(c).Zero(c)
+		if !ok {
+			return fieldError(c, errorInvalidType)
+		}
+		record[c.Name] = v
+	}
+	if !tab.setsColumns(record) {
+		return errorNothingToUpdate
+	}
+
+	updated, etag, err := d.updateRecord(table, key, record, r.Header.Get("If-Match"))
+	if err != nil {
+		return d.dbError(err)
+	}
+	if etag == "" {
+		return errorRecordNotFound
+	}
+	w.Header().Set("ETag", etag)
+	writeResponse(w, finalResponse{Response: map[string]interface{}{"updated": updated}})
+	return
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// jsonRoundTrip makes expected and actual results comparable, the same
+// way runCases does
+func jsonRoundTrip(v interface{}) (result interface{}) {
+	bs, _ := json.Marshal(v)
+	json.Unmarshal(bs, &result)
+	return result
+}
+
+func TestPatch(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, []string{
+			`CREATE TABLE profiles (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name varchar(255) NOT NULL,
+  nick varchar(255) DEFAULT NULL,
+  settings JSON DEFAULT NULL,
+  score int NOT NULL DEFAULT 0
+);`,
+			`INSERT INTO profiles (name, nick, settings) VALUES ('alice', 'al', '{"theme": "dark", "tags": ["a", "b"]}');`,
+		})
+
+		mergePatch := map[string]string{"Content-Type": mergePatchType}
+		jsonPatch := map[string]string{"Content-Type": jsonPatchType}
+		cases := []struct {
+			method  string
+			path    string
+			headers map[string]string
+			body    interface{}
+			status  int
+			result  CR
+		}{
+			// null removes a value, nested objects of json columns are merged
+			{http.MethodPatch, "/profiles/1", mergePatch, CR{"nick": nil, "settings": CR{"theme": nil, "lang": "ru"}}, http.StatusOK,
+				CR{"response": CR{"updated": 1}}},
+			{http.MethodPatch, "/profiles/1", mergePatch, CR{"name": nil}, http.StatusBadRequest,
+				CR{"error": "field name have invalid type", "code": "invalid_type", "field": "name"}},
+			{http.MethodPatch, "/profiles/1", nil, CR{"id": 2}, http.StatusBadRequest,
+				CR{"error": "field id have invalid type", "code": "invalid_type", "field": "id"}},
+			{http.MethodPatch, "/profiles/1", jsonPatch, []CR{
+				CR{"op": "test", "path": "/name", "value": "alice"},
+				CR{"op": "add", "path": "/settings/tags/-", "value": "c"},
+				CR{"op": "replace", "path": "/score", "value": 5},
+				CR{"op": "copy", "from": "/name", "path": "/nick"},
+			}, http.StatusOK, CR{"response": CR{"updated": 1}}},
+			{http.MethodPatch, "/profiles/1", jsonPatch, []CR{
+				CR{"op": "test", "path": "/score", "value": 4},
+				CR{"op": "replace", "path": "/score", "value": 0},
+			}, http.StatusConflict, CR{"error": "operation 0: test failed", "code": "patch_conflict"}},
+			{http.MethodPatch, "/profiles/1", jsonPatch, []CR{
+				CR{"op": "replace", "path": "/settings/missing", "value": 1},
+			}, http.StatusConflict, CR{"error": "operation 0: path not found", "code": "patch_conflict"}},
+			{http.MethodPatch, "/profiles/1", jsonPatch, []CR{
+				CR{"op": "rename", "path": "/name"},
+			}, http.StatusBadRequest, CR{"error": "operation 0: unknown operation rename", "code": "invalid_patch"}},
+			{http.MethodPatch, "/profiles/1", map[string]string{"Content-Type": "text/plain"}, CR{}, http.StatusUnsupportedMediaType,
+				CR{"error": "unsupported content type", "code": "unsupported_media_type"}},
+			{http.MethodPatch, "/profiles/100500", nil, CR{"name": "nobody"}, http.StatusNotFound,
+				CR{"error": "record not found", "code": "not_found"}},
+		}
+		for i, c := range cases {
+			resp, result := doWithHeaders(t, c.method, s.URL+c.path, c.headers, c.body)
+			if resp.StatusCode != c.status || !reflect.DeepEqual(jsonRoundTrip(result), jsonRoundTrip(c.result)) {
+				t.Errorf("case %d [%s %s]: expected %d %v, got %d %v", i, c.method, c.path, c.status, c.result, resp.StatusCode, result)
+			}
+		}
+
+		s.run(t, []Case{
+			Case{
+				Path: "/profiles/1",
+				Result: CR{
+					"response": CR{
+						"record": CR{
+							"id":       1,
+							"name":     "alice",
+							"nick":     "alice",
+							"settings": CR{"lang": "ru", "tags": []string{"a", "b", "c"}},
+							"score":    5,
+						},
+					},
+				},
+			},
+			// PUT replaces the whole record, omitted columns are reset
+			Case{
+				Path:   "/profiles/1",
+				Method: http.MethodPut,
+				Body:   CR{"id": 1, "name": "bob"},
+				Result: CR{"response": CR{"updated": 1}},
+			},
+			Case{
+				Path: "/profiles/1",
+				Result: CR{
+					"response": CR{
+						"record": CR{"id": 1, "name": "bob", "nick": nil, "settings": nil, "score": 0},
+					},
+				},
+			},
+			Case{
+				Path:   "/profiles/1",
+				Method: http.MethodPut,
+				Status: http.StatusBadRequest,
+				Body:   CR{"id": 2, "name": "eve"},
+				Result: CR{"error": "field id have invalid type", "code": "invalid_type", "field": "id"},
+			},
+			Case{
+				Path:   "/profiles/100500",
+				Method: http.MethodPut,
+				Status: http.StatusNotFound,
+				Body:   CR{"name": "nobody"},
+				Result: CR{"error": "record not found", "code": "not_found"},
+			},
+		})
+	})
+}
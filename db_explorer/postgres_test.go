@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// PreparePostgresTestApis connects to the Postgres server of POSTGRES_DSN,
+// the test is skipped without it
+func PreparePostgresTestApis(t *testing.T) *sql.DB {
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	qs := []string{
+		`DROP TABLE IF EXISTS items, users, shirts;`,
+		`DROP TYPE IF EXISTS shirt_size;`,
+
+		`CREATE TABLE items (
+  id SERIAL PRIMARY KEY,
+  title varchar(255) NOT NULL,
+  description text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`,
+
+		`INSERT INTO items (id, title, description, updated) VALUES
+(1,	'database/sql',	'Рассказать про базы данных',	'rvasily'),
+(2,	'memcache',	'Рассказать про мемкеш с примером использования',	NULL);`,
+
+		// rows inserted with their ids do not move the sequence
+		`SELECT setval(pg_get_serial_sequence('items', 'id'), 2);`,
+
+		`CREATE TABLE users (
+  user_id SERIAL PRIMARY KEY,
+  login varchar(255) NOT NULL,
+  password varchar(255) NOT NULL,
+  email varchar(255) NOT NULL,
+  info text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`,
+
+		`INSERT INTO users (user_id, login, password, email, info, updated) VALUES
+(1,	'rvasily',	'love',	'rvasily@example.com',	'none',	NULL);`,
+
+		`SELECT setval(pg_get_serial_sequence('users', 'user_id'), 1);`,
+
+		`CREATE TYPE shirt_size AS ENUM ('S', 'M', 'it''s L');`,
+
+		`CREATE TABLE shirts (
+  id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  size shirt_size NOT NULL,
+  price numeric(10,2) NOT NULL,
+  code char(3) DEFAULT NULL
+);`,
+	}
+	for _, q := range qs {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		db.Exec(`DROP TABLE IF EXISTS items, users, shirts;`)
+		db.Exec(`DROP TYPE IF EXISTS shirt_size;`)
+		db.Close()
+	})
+	return db
+}
+
+func TestApisPostgres(t *testing.T) {
+	db := PreparePostgresTestApis(t)
+
+	handler, err := NewDbExplorer(db, WithDialect(postgresDialect{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	runCases(t, ts, db, apiCases())
+}
+
+func TestPostgresIntrospection(t *testing.T) {
+	db := PreparePostgresTestApis(t)
+
+	tab, err := postgresDialect{}.Columns(db, "shirts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tab.PK, []string{"id"}) {
+		t.Errorf("expected pk [id], got %v", tab.PK)
+	}
+	if _, ok := tab.AutoIncrement["id"]; !ok {
+		t.Errorf("expected the identity id to be auto increment")
+	}
+	want := []Col{
+		{Name: "id", Type: "int", Def: "integer", PK: true},
+		{Name: "size", Type: "enum", Def: "enum('S','M','it''s L')"},
+		{Name: "price", Type: "decimal", Def: "decimal(10,2)"},
+		{Name: "code", Type: "char", Def: "char(3)", Null: true},
+	}
+	if !reflect.DeepEqual(tab.Columns, want) {
+		t.Errorf("expected columns %+v, got %+v", want, tab.Columns)
+	}
+	if args := tab.Columns[1].typeArgs(); !reflect.DeepEqual(args, []string{"S", "M", "it's L"}) {
+		t.Errorf("expected the enum labels, got %v", args)
+	}
+
+	tab, err = postgresDialect{}.Columns(db, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := tab.column("login"); c.Def != "varchar(255)" {
+		t.Errorf("expected varchar(255), got %s", c.Def)
+	}
+}
+
+func TestPostgresSQL(t *testing.T) {
+	d := &DbExplorer{
+		dialect: postgresDialect{},
+		types:   defaultTypes(),
+		columns: map[string]Table{
+			"items": Table{
+				Columns: []Col{
+					Col{Name: "id", Type: "int", PK: true},
+					Col{Name: "title", Type: "varchar"},
+					Col{Name: "we\"ird", Type: "varchar", Null: true},
+				},
+				PK:            []string{"id"},
+				AutoIncrement: map[string]struct{}{"id": struct{}{}},
+			},
+			"tags": Table{
+				Columns: []Col{
+					Col{Name: "item_id", Type: "int", PK: true},
+					Col{Name: "tag", Type: "varchar", PK: true},
+				},
+				PK:            []string{"item_id", "tag"},
+				AutoIncrement: map[string]struct{}{},
+			},
+		},
+	}
+
+	q, vals, returning := d.insertSQL("items", map[string]interface{}{"title": "go", "we\"ird": "x"})
+	expected := `INSERT INTO "items"("title", "we""ird") VALUES ($1, $2) RETURNING "id"`
+	if q != expected || !returning {
+		t.Errorf("expected %s with RETURNING, got %s (%v)", expected, q, returning)
+	}
+	if !reflect.DeepEqual(vals, []interface{}{"go", "x"}) {
+		t.Errorf("unexpected args %v", vals)
+	}
+
+	// without an auto increment key there is no id to return
+	q, _, returning = d.insertSQL("tags", map[string]interface{}{"item_id": 1, "tag": "db"})
+	expected = `INSERT INTO "tags"("item_id", "tag") VALUES ($1, $2)`
+	if q != expected || returning {
+		t.Errorf("expected %s without RETURNING, got %s (%v)", expected, q, returning)
+	}
+
+	// placeholders are numbered across the whole statement
+	tags := d.columns["tags"]
+	args := &sqlArgs{dialect: d.dialect}
+	q = d.whereSQL([]condition{
+		condition{col: tags.Columns[1], op: "in", value: []interface{}{"a", "b"}},
+		condition{op: "after", value: keyset{cols: tags.Columns, key: []interface{}{1, "c"}}},
+	}, args)
+	expected = ` WHERE "tag" IN ($1, $2) AND (("item_id" > $3) OR ("item_id" = $4 AND "tag" > $5))`
+	if q != expected {
+		t.Errorf("expected %s, got %s", expected, q)
+	}
+	if !reflect.DeepEqual(args.args, []interface{}{"a", "b", 1, 1, "c"}) {
+		t.Errorf("unexpected args %v", args.args)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	condition struct {
+		col   Col
+		op    string
+		value interface{}
+	}
+	orderBy struct {
+		col  Col
+		desc bool
+	}
+	listQuery struct {
+		limit  int
+		offset int
+		fields []Col
+		where  []condition
+		order  []orderBy
+		// keyset pagination by primary key, after is the last seen key
+		cursor bool
+		after  []interface{}
+		total  bool
+	}
+	// sqlArgs collects bind arguments and hands out dialect placeholders for them
+	sqlArgs struct {
+		dialect Dialect
+		args    []interface{}
+	}
+)
+
+func (a *sqlArgs) add(v interface{}) string {
+	a.args = append(a.args, v)
+	return a.dialect.Placeholder(len(a.args))
+}
+
+func (t Table) column(name string) (Col, bool) {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Col{}, false
+}
+
+// parseWhereKey splits "where[col][op]" into column and operator, op defaults to eq
+func parseWhereKey(key string) (col, op string, ok bool) {
+	if !strings.HasPrefix(key, "where[") || !strings.HasSuffix(key, "]") {
+		return
+	}
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(key, "where["), "]"), "][")
+	switch len(parts) {
+	case 1:
+		return parts[0], "eq", parts[0] != ""
+	case 2:
+		return parts[0], parts[1], parts[0] != "" && parts[1] != ""
+	}
+	return
+}
+
+func (d *DbExplorer) parseCondition(tab Table, name, op, raw string) (cond condition, err error) {
+	col, ok := tab.column(name)
+	if !ok {
+		return cond, badRequest("unknown column %s", name)
+	}
+	cond = condition{col: col, op: op}
+	switch op {
+	case "eq", "ne", "gt", "gte", "lt", "lte":
+		cond.value, err = d.types.parse(col, raw)
+	case "like":
+		if d.types.lookup(col).Kind() != "string" {
+			return cond, badRequest("operator like is not supported for field %s", name)
+		}
+		cond.value = raw
+	case "in":
+		vals := make([]interface{}, 0)
+		for _, s := range strings.Split(raw, ",") {
+			v, err := d.types.parse(col, s)
+			if err != nil {
+				return cond, err
+			}
+			vals = append(vals, v)
+		}
+		cond.value = vals
+	case "null":
+		isNull, err := strconv.ParseBool(raw)
+		if err != nil {
+			return cond, badRequest("operator null expects a boolean for field %s", name)
+		}
+		cond.value = isNull
+	default:
+		return cond, badRequest("unknown operator %s", op)
+	}
+	return
+}
+
+func (d *DbExplorer) parseListQuery(r *http.Request, tab Table) (lq listQuery, err error) {
+	if lq.limit, err = d.readLimit(r, 5); err != nil {
+		return
+	}
+	lq.offset = readParam(r, "offset", 0)
+	params := r.URL.Query()
+
+	if fields := params.Get("fields"); fields != "" {
+		seen := make(map[string]struct{})
+		for _, name := range strings.Split(fields, ",") {
+			col, ok := tab.column(name)
+			if !ok {
+				return lq, badRequest("unknown column %s", name)
+			}
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			lq.fields = append(lq.fields, col)
+		}
+	}
+
+	if order := params.Get("order"); order != "" {
+		for _, name := range strings.Split(order, ",") {
+			o := orderBy{}
+			if strings.HasPrefix(name, "-") {
+				o.desc = true
+				name = name[1:]
+			}
+			col, ok := tab.column(name)
+			if !ok {
+				return lq, badRequest("unknown column %s", name)
+			}
+			o.col = col
+			lq.order = append(lq.order, o)
+		}
+	}
+
+	// map iteration order is random, keep the generated sql stable
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "where[") {
+			continue
+		}
+		name, op, ok := parseWhereKey(key)
+		if !ok {
+			return lq, badRequest("invalid filter %s", key)
+		}
+		for _, raw := range params[key] {
+			cond, err := d.parseCondition(tab, name, op, raw)
+			if err != nil {
+				return lq, err
+			}
+			lq.where = append(lq.where, cond)
+		}
+	}
+	lq.where = append(lq.where, notDeleted(r, tab)...)
+	if term := params.Get("q"); term != "" {
+		cond, err := searchCondition(tab, term)
+		if err != nil {
+			return lq, err
+		}
+		lq.where = append(lq.where, cond)
+	}
+	err = d.parsePagination(r, tab, &lq)
+	return lq, err
+}
+
+func (d *DbExplorer) whereSQL(conds []condition, args *sqlArgs) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	ops := map[string]string{"eq": "=", "ne": "<>", "gt": ">", "gte": ">=", "lt": "<", "lte": "<=", "like": "LIKE"}
+	parts := make([]string, 0, len(conds))
+	for _, c := range conds {
+		name := d.quote(c.col.Name)
+		switch c.op {
+		case "in":
+			vals := c.value.([]interface{})
+			phs := make([]string, len(vals))
+			for i, v := range vals {
+				phs[i] = args.add(v)
+			}
+			parts = append(parts, fmt.Sprintf("%s IN (%s)", name, strings.Join(phs, ", ")))
+		case "after":
+			ks := c.value.(keyset)
+			parts = append(parts, d.afterKeySQL(ks.cols, ks.key, args))
+		case "search":
+			parts = append(parts, d.searchSQL(c.value.(search), args))
+		case "null":
+			if c.value.(bool) {
+				parts = append(parts, name+" IS NULL")
+			} else {
+				parts = append(parts, name+" IS NOT NULL")
+			}
+		default:
+			parts = append(parts, fmt.Sprintf("%s %s %s", name, ops[c.op], args.add(c.value)))
+		}
+	}
+	return " WHERE " + strings.Join(parts, " AND ")
+}
+
+func (d *DbExplorer) orderSQL(order []orderBy) string {
+	if len(order) == 0 {
+		return ""
+	}
+	parts := make([]string, len(order))
+	for i, o := range order {
+		parts[i] = d.quote(o.col.Name)
+		if o.desc {
+			parts[i] += " DESC"
+		}
+	}
+	return " ORDER BY " + strings.Join(parts, ", ")
+}
+
+func (d *DbExplorer) columnsSQL(cols []Col) string {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = d.quote(c.Name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// limiterSweepInterval is how often buckets of idle clients are dropped
+const limiterSweepInterval = time.Minute
+
+type (
+	tokenBucket struct {
+		tokens float64
+		last   time.Time
+	}
+
+	// rateLimiter keeps a token bucket per client, each is refilled with
+	// rate tokens a second up to burst
+	rateLimiter struct {
+		mu      sync.Mutex
+		rate    float64
+		burst   float64
+		buckets map[string]*tokenBucket
+		swept   time.Time
+	}
+)
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// take removes n tokens from the bucket of the key, when there are not
+// enough it returns how long until there are
+func (l *rateLimiter) take(key string, n float64, now time.Time) (wait time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) >= limiterSweepInterval {
+		l.sweep(now)
+	}
+	b, found := l.buckets[key]
+	if !found {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens >= n {
+		b.tokens -= n
+		return 0, true
+	}
+	if l.rate <= 0 {
+		return limiterSweepInterval, false
+	}
+	return time.Duration((n - b.tokens) / l.rate * float64(time.Second)), false
+}
+
+func (l *rateLimiter) refill(b *tokenBucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.rate
+	if tokens > l.burst {
+		tokens = l.burst
+	}
+	return tokens
+}
+
+// sweep drops the buckets that are full again, a new one is the same
+func (l *rateLimiter) sweep(now time.Time) {
+	l.swept = now
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// WithMaxLimit rejects requests asking for more than max records at once,
+// with limit in lists, searches and the audit log. Exports and aggregates
+// without limit that would return more than max rows are rejected too.
+func WithMaxLimit(max int) Option {
+	return func(d *DbExplorer) {
+		d.maxLimit = max
+	}
+}
+
+// WithRateLimit allows each client rate requests a second on average and
+// up to burst at once, the rest get 429 Too Many Requests. Clients are told
+// apart by the name of the authenticated principal, anonymous ones and
+// failed logins by their remote address.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(d *DbExplorer) {
+		d.limiter = newRateLimiter(rate, burst)
+	}
+}
+
+// WithWriteQuota allows each client up to writes requests changing the
+// table within per, no quota if either is 0. Every operation of a batch and
+// every record created with PUT /$table or imported counts.
+func WithWriteQuota(table string, writes int, per time.Duration) Option {
+	return func(d *DbExplorer) {
+		if d.quotas == nil {
+			d.quotas = make(map[string]*rateLimiter)
+		}
+		if writes <= 0 || per <= 0 {
+			delete(d.quotas, table)
+			return
+		}
+		d.quotas[table] = newRateLimiter(float64(writes)/per.Seconds(), writes)
+	}
+}
+
+// clientKey tells apart the clients sharing the limits
+func clientKey(r *http.Request) string {
+	if p := principalFrom(r); p.Name != "anonymous" {
+		return "principal:" + p.Name
+	}
+	return "addr:" + remoteHost(r)
+}
+
+// remoteHost is the address of the client without the port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// tooManyRequests answers 429 with Retry-After in whole seconds
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return &apiError{status: http.StatusTooManyRequests, code: CodeTooManyRequests, msg: msg}
+}
+
+// checkRateLimit charges the request to the client
+func (d *DbExplorer) checkRateLimit(w http.ResponseWriter, r *http.Request) error {
+	if d.limiter == nil {
+		return nil
+	}
+	if wait, ok := d.limiter.take(clientKey(r), 1, time.Now()); !ok {
+		return tooManyRequests(w, wait, "too many requests")
+	}
+	return nil
+}
+
+// checkWriteQuota charges writes of the client to the quota of the table
+func (d *DbExplorer) checkWriteQuota(w http.ResponseWriter, r *http.Request, table string, writes int) error {
+	quota, ok := d.quotas[table]
+	if !ok {
+		return nil
+	}
+	if float64(writes) > quota.burst {
+		return badRequest("%d writes exceed the write quota of table %s", writes, table)
+	}
+	if wait, ok := quota.take(clientKey(r), float64(writes), time.Now()); !ok {
+		return tooManyRequests(w, wait, fmt.Sprintf("write quota of table %s exceeded", table))
+	}
+	return nil
+}
+
+// readLimit reads the limit param, it can't go over WithMaxLimit and the
+// default is cut down to it
+func (d *DbExplorer) readLimit(r *http.Request, defaultValue int) (int, error) {
+	if d.maxLimit > 0 && defaultValue > d.maxLimit {
+		defaultValue = d.maxLimit
+	}
+	limit := readParam(r, "limit", defaultValue)
+	if d.maxLimit > 0 && limit > d.maxLimit {
+		return 0, badRequest("limit must be at most %d", d.maxLimit)
+	}
+	return limit, nil
+}
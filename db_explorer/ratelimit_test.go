@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, 3)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if _, ok := l.take("a", 1, now); !ok {
+			t.Fatalf("request %d must fit into the burst", i)
+		}
+	}
+	wait, ok := l.take("a", 1, now)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("expected to wait 500ms, got %v %v", wait, ok)
+	}
+	if _, ok = l.take("b", 1, now); !ok {
+		t.Errorf("clients must not share buckets")
+	}
+	if _, ok = l.take("a", 1, now.Add(500*time.Millisecond)); !ok {
+		t.Errorf("the bucket must be refilled")
+	}
+
+	// buckets left alone long enough are full again and go away
+	l.take("c", 1, now.Add(limiterSweepInterval))
+	if _, found := l.buckets["a"]; found {
+		t.Errorf("idle buckets must be swept, got %v", l.buckets)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		ts := b.serve(t, nil,
+			WithAuthenticator(APIKeyAuthenticator{
+				"editor-key": Principal{Name: "bob", Roles: []string{"editor"}},
+			}),
+			WithMaxLimit(10),
+			WithRateLimit(0.001, 4),
+			WithWriteQuota("items", 2, time.Hour),
+		)
+
+		resp, result := doWithHeaders(t, http.MethodGet, ts.URL+"/items?limit=1000000", nil, nil)
+		if resp.StatusCode != http.StatusBadRequest || result["error"] != "limit must be at most 10" {
+			t.Errorf("expected the limit to be rejected, got %d %v", resp.StatusCode, result)
+		}
+
+		// the editor has its own bucket, anonymous requests share the address
+		editor := map[string]string{"X-API-Key": "editor-key"}
+		resp, result = doWithHeaders(t, http.MethodPost, ts.URL+"/items/1", editor, CR{"title": "first"})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("first write: %d %v", resp.StatusCode, result)
+		}
+		resp, result = doWithHeaders(t, http.MethodPost, ts.URL+"/_batch", editor, []CR{
+			CR{"op": "update", "table": "items", "key": 1, "record": CR{"title": "second"}},
+			CR{"op": "update", "table": "items", "key": 2, "record": CR{"title": "third"}},
+		})
+		if resp.StatusCode != http.StatusTooManyRequests || result["code"] != CodeTooManyRequests {
+			t.Errorf("the batch goes over the write quota, got %d %v", resp.StatusCode, result)
+		}
+		if retry := resp.Header.Get("Retry-After"); retry != "1800" {
+			t.Errorf("expected Retry-After 1800, got %q", retry)
+		}
+		resp, result = doWithHeaders(t, http.MethodPost, ts.URL+"/_batch", editor, []CR{
+			CR{"op": "update", "table": "items", "key": 1, "record": CR{"title": "second"}},
+			CR{"op": "update", "table": "items", "key": 2, "record": CR{"title": "third"}},
+			CR{"op": "update", "table": "items", "key": 3, "record": CR{"title": "fourth"}},
+		})
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("a batch larger than the quota can never pass, got %d %v", resp.StatusCode, result)
+		}
+		doWithHeaders(t, http.MethodGet, ts.URL+"/items/1", editor, nil)
+		resp, _ = doWithHeaders(t, http.MethodGet, ts.URL+"/items/1", editor, nil)
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("the editor has used up its requests, got %d", resp.StatusCode)
+		}
+
+		for i := 1; i < 4; i++ {
+			if resp, result = doWithHeaders(t, http.MethodGet, ts.URL+"/items/1", nil, nil); resp.StatusCode != http.StatusOK {
+				t.Fatalf("request %d: %d %v", i, resp.StatusCode, result)
+			}
+		}
+		resp, result = doWithHeaders(t, http.MethodGet, ts.URL+"/", nil, nil)
+		if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+			t.Errorf("expected 429 with Retry-After, got %d %v", resp.StatusCode, result)
+		}
+	})
+}
+
+func TestRateLimitFailedLogins(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		ts := b.serve(t, nil,
+			WithAuthenticator(APIKeyAuthenticator{
+				"editor-key": Principal{Name: "bob", Roles: []string{"editor"}},
+			}),
+			WithRateLimit(0.001, 3),
+		)
+
+		wrong := map[string]string{"X-API-Key": "wrong-key"}
+		for i := 0; i < 3; i++ {
+			if resp, result := doWithHeaders(t, http.MethodGet, ts.URL+"/items", wrong, nil); resp.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("login %d: expected 401, got %d %v", i, resp.StatusCode, result)
+			}
+		}
+		resp, result := doWithHeaders(t, http.MethodGet, ts.URL+"/items", wrong, nil)
+		if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+			t.Errorf("guessing keys must be throttled, got %d %v", resp.StatusCode, result)
+		}
+		// the principal has a bucket of its own
+		if resp, result = doWithHeaders(t, http.MethodGet, ts.URL+"/items", map[string]string{"X-API-Key": "editor-key"}, nil); resp.StatusCode != http.StatusOK {
+			t.Errorf("expected the editor to pass, got %d %v", resp.StatusCode, result)
+		}
+	})
+}
+
+func TestWriteQuotaBulk(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		ts := b.serve(t, nil, WithWriteQuota("items", 3, time.Hour))
+
+		two := []CR{CR{"title": "a", "description": ""}, CR{"title": "b", "description": ""}}
+		if resp, result := doWithHeaders(t, http.MethodPut, ts.URL+"/items/", nil, two); resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected the records to be created, got %d %v", resp.StatusCode, result)
+		}
+		if resp, result := doWithHeaders(t, http.MethodPut, ts.URL+"/items/", nil, two); resp.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("every record counts, expected 429, got %d %v", resp.StatusCode, result)
+		}
+		resp, result := doWithHeaders(t, http.MethodPut, ts.URL+"/items/", nil, append(two, two...))
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("more records than the quota can never pass, got %d %v", resp.StatusCode, result)
+		}
+
+		req, _ := http.NewRequest(http.MethodPut, ts.URL+"/items/", strings.NewReader("title,description\nc,\nd,\n"))
+		req.Header.Set("Content-Type", "text/csv")
+		if resp, err := client.Do(req); err != nil {
+			t.Fatal(err)
+		} else {
+			bs, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			var got interface{}
+			if err = decodeJSON(bs, &got); err != nil {
+				t.Fatal(err)
+			}
+			expected := CR{"response": CR{"inserted": 1, "errors": []CR{
+				CR{"line": 3, "error": "write quota of table items exceeded", "code": "too_many_requests"},
+			}}}
+			if !reflect.DeepEqual(jsonRoundTrip(got), jsonRoundTrip(expected)) {
+				t.Errorf("the import stops at the quota, expected %v, got %d %s", expected, resp.StatusCode, bs)
+			}
+		}
+	})
+}
+
+func TestMaxLimitWithoutLimit(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		ts := b.serve(t, nil, WithMaxLimit(1))
+
+		// rows over the max limit are refused rather than dropped
+		ts.run(t, []Case{
+			Case{
+				Path:   "/items",
+				Query:  "format=ndjson",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "more than 1 rows to export, use limit and offset", "code": "bad_request"},
+			},
+			Case{
+				Path:   "/items/_aggregate",
+				Query:  "group=id&agg=count(*)",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "more than 1 groups, use limit and offset", "code": "bad_request"},
+			},
+			Case{
+				Path:  "/items/_aggregate",
+				Query: "group=id&agg=count(*)&where[id]=1",
+				Result: CR{
+					"response": CR{
+						"groups": []CR{CR{"id": 1, "count(*)": 1}},
+					},
+				},
+			},
+		})
+
+		for _, query := range []string{"format=csv&fields=id&where[id]=2", "format=csv&fields=id&limit=1&offset=1"} {
+			resp, err := client.Get(ts.URL + "/items?" + query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bs, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || string(bs) != "id\n2\n" {
+				t.Errorf("%s: exports within the max limit go through, got %d %q", query, resp.StatusCode, bs)
+			}
+		}
+	})
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RelationName is how clients refer to the foreign key in ?expand= and
+// ?via=: the column for single column keys, the constraint name otherwise.
+func (fk ForeignKey) RelationName() string {
+	if len(fk.Columns) == 1 {
+		return fk.Columns[0]
+	}
+	return fk.Name
+}
+
+// resolveForeignKeys fills in implicit references to the parent primary
+// key and drops references to tables DbExplorer doesn't know about
+func resolveForeignKeys(columns map[string]Table) {
+	for name, tab := range columns {
+		fks := make([]ForeignKey, 0, len(tab.ForeignKeys))
+		for _, fk := range tab.ForeignKeys {
+			ref, ok := columns[fk.RefTable]
+			if !ok {
+				continue
+			}
+			if len(fk.RefColumns) == 0 {
+				fk.RefColumns = ref.PK
+			}
+			if len(fk.RefColumns) != len(fk.Columns) {
+				continue
+			}
+			fks = append(fks, fk)
+		}
+		tab.ForeignKeys = fks
+		columns[name] = tab
+	}
+}
+
+func (d *DbExplorer) relations() []ForeignKey {
+	result := make([]ForeignKey, 0)
+	for _, table := range d.tables {
+		result = append(result, d.columns[table].ForeignKeys...)
+	}
+	return result
+}
+
+func (t Table) relation(name string) (ForeignKey, bool) {
+	for _, fk := range t.ForeignKeys {
+		if fk.RelationName() == name {
+			return fk, true
+		}
+	}
+	return ForeignKey{}, false
+}
+
+// parseExpand validates ?expand=a,b against the foreign keys of the table
+func (d *DbExplorer) parseExpand(p Principal, r *http.Request, tab Table, lq *listQuery) (fks []ForeignKey, err error) {
+	expand := r.URL.Query().Get("expand")
+	if expand == "" {
+		return nil, nil
+	}
+	for _, name := range strings.Split(expand, ",") {
+		fk, ok := tab.relation(name)
+		for _, colName := range fk.Columns {
+			// a relation through a hidden column is not there for the principal
+			_, visible := tab.column(colName)
+			ok = ok && visible
+		}
+		if !ok {
+			return nil, badRequest("unknown relation %s", name)
+		}
+		if !d.policy.canRead(p, fk.RefTable) {
+			return nil, errorForbidden
+		}
+		fks = append(fks, fk)
+		if lq == nil || len(lq.fields) == 0 {
+			continue
+		}
+		// the foreign key columns are needed to look up related rows
+		for _, colName := range fk.Columns {
+			found := false
+			for _, c := range lq.fields {
+				found = found || c.Name == colName
+			}
+			if !found {
+				c, _ := tab.column(colName)
+				lq.fields = append(lq.fields, c)
+			}
+		}
+	}
+	return fks, nil
+}
+
+func tupleKey(record map[string]interface{}, cols []string) (string, bool) {
+	parts := make([]string, len(cols))
+	for i, c := range cols {
+		v := record[c]
+		if v == nil {
+			return "", false
+		}
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, "\x00"), true
+}
+
+// expand replaces foreign key values with the referenced rows, all rows
+// of one relation are fetched with a single query. Soft deleted rows are
+// expanded to null unless they were asked for.
+func (d *DbExplorer) expand(p Principal, r *http.Request, records []map[string]interface{}, fks []ForeignKey) (err error) {
+	for _, fk := range fks {
+		ref := d.columns[fk.RefTable]
+		refCols := make([]Col, len(fk.RefColumns))
+		for i, name := range fk.RefColumns {
+			refCols[i], _ = ref.column(name)
+		}
+
+		args := &sqlArgs{dialect: d.dialect}
+		ors := make([]string, 0)
+		seen := make(map[string]struct{})
+		for _, rec := range records {
+			key, ok := tupleKey(rec, fk.Columns)
+			if !ok {
+				continue
+			}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			ands := make([]string, len(refCols))
+			for i, c := range refCols {
+				v, err := d.types.parse(c, fmt.Sprint(rec[fk.Columns[i]]))
+				if err != nil {
+					return err
+				}
+				ands[i] = fmt.Sprintf("%s = %s", d.quote(c.Name), args.add(v))
+			}
+			ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+		}
+
+		related := make(map[string]map[string]interface{})
+		if len(ors) > 0 {
+			var rows *sql.Rows
+			q := fmt.Sprintf("SELECT %s FROM %s WHERE %s", ref.columnString, d.quote(fk.RefTable), strings.Join(ors, " OR "))
+			rows, err = d.reader().Query(q, args.args...)
+			if err != nil {
+				return
+			}
+			result, err := d.processSelectRows(ref.Columns, rows)
+			rows.Close()
+			if err != nil {
+				return err
+			}
+			for _, row := range result {
+				if ref.isDeleted(row) && !withDeleted(r) {
+					continue
+				}
+				if key, ok := tupleKey(row, fk.RefColumns); ok {
+					related[key] = row
+				}
+			}
+			d.hideColumns(p, fk.RefTable, result)
+		}
+
+		name := fk.RelationName()
+		for _, rec := range records {
+			key, ok := tupleKey(rec, fk.Columns)
+			if !ok {
+				rec[name] = nil
+				continue
+			}
+			if row, ok := related[key]; ok {
+				rec[name] = row
+			} else {
+				rec[name] = nil
+			}
+		}
+	}
+	return nil
+}
+
+// childRelation finds the foreign key from child to parent, via selects
+// one when there are several
+func (d *DbExplorer) childRelation(parent string, child Table, via string) (fk ForeignKey, err error) {
+	found := 0
+	for _, candidate := range child.ForeignKeys {
+		if candidate.RefTable != parent {
+			continue
+		}
+		if via != "" && candidate.RelationName() != via {
+			continue
+		}
+		fk = candidate
+		found++
+	}
+	switch {
+	case found == 0:
+		return fk, badRequest("unknown relation")
+	case found > 1:
+		return fk, badRequest("ambiguous relation, use via")
+	}
+	return fk, nil
+}
+
+// getRelated serves GET /$parent/$key/$child, the rows of child that
+// reference the parent record, with the usual list query parameters
+func (d *DbExplorer) getRelated(w http.ResponseWriter, r *http.Request, arr []string) (err error) {
+	parentName, childName := arr[0], arr[2]
+	parent, ok := d.columns[parentName]
+	if !ok {
+		return writeUnknownTable(w)
+	}
+	if _, ok = d.columns[childName]; !ok {
+		return writeUnknownTable(w)
+	}
+	p := principalFrom(r)
+	if !d.policy.canRead(p, parentName) || !d.policy.canRead(p, childName) {
+		return writeForbidden(w)
+	}
+	// the foreign key may be hidden, visibility only applies to the output
+	child := d.visibleTable(p, childName)
+
+	key, err := d.parseRecordKey(r, parent, arr[1])
+	if err != nil {
+		return writeError(w, err)
+	}
+	fk, err := d.childRelation(parentName, d.columns[childName], r.URL.Query().Get("via"))
+	if err != nil {
+		return writeError(w, err)
+	}
+	lq, err := d.parseListQuery(r, child)
+	if err != nil {
+		return writeError(w, err)
+	}
+	if len(lq.fields) == 0 && len(child.Columns) < len(d.columns[childName].Columns) {
+		lq.fields = child.Columns
+	}
+	expand, err := d.parseExpand(p, r, child, &lq)
+	if err != nil {
+		return writeError(w, err)
+	}
+
+	parentRows, err := d.selectByKey(parentName, key)
+	if err != nil {
+		return errorInternal
+	}
+	if len(parentRows) == 0 || parent.isDeleted(parentRows[0]) && !withDeleted(r) {
+		return writeRecordNotFound(w)
+	}
+
+	conds := make([]condition, 0, len(fk.Columns))
+	for i, name := range fk.Columns {
+		c, _ := d.columns[childName].column(name)
+		v := parentRows[0][fk.RefColumns[i]]
+		if v == nil {
+			writeResponse(w, finalResponse{Response: map[string]interface{}{"records": []interface{}{}}})
+			return
+		}
+		v, err = d.types.parse(c, fmt.Sprint(v))
+		if err != nil {
+			return errorInternal
+		}
+		conds = append(conds, condition{col: c, op: "eq", value: v})
+	}
+	// the cursor condition has to stay the last one
+	lq.where = append(conds, lq.where...)
+
+	response, err := d.listPage(childName, lq)
+	if err != nil {
+		return errorInternal
+	}
+	if records, ok := response["records"].([]map[string]interface{}); ok {
+		if err = d.expand(p, r, records, expand); err != nil {
+			return errorInternal
+		}
+	}
+	writeResponse(w, finalResponse{Response: response})
+	return
+}
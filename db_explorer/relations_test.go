@@ -0,0 +1,219 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRelations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, []string{
+			`CREATE TABLE posts (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  author_id int DEFAULT NULL,
+  title varchar(255) NOT NULL,
+  FOREIGN KEY (author_id) REFERENCES users (user_id)
+);`,
+			`INSERT INTO posts (id, author_id, title) VALUES (1, 1, 'first'), (2, NULL, 'anonymous'), (3, 1, 'second');`,
+			`CREATE TABLE messages (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  from_id int NOT NULL,
+  to_id int NOT NULL,
+  body text NOT NULL,
+  FOREIGN KEY (from_id) REFERENCES users (user_id),
+  FOREIGN KEY (to_id) REFERENCES users (user_id)
+);`,
+			`INSERT INTO messages (id, from_id, to_id, body) VALUES (1, 1, 1, 'note to self');`,
+		})
+
+		// SQLite does not name foreign keys, the dialect numbers them backwards
+		relations := []CR{
+			CR{"name": "messages_fk_0", "table": "messages", "columns": []string{"to_id"}, "ref_table": "users", "ref_columns": []string{"user_id"}},
+			CR{"name": "messages_fk_1", "table": "messages", "columns": []string{"from_id"}, "ref_table": "users", "ref_columns": []string{"user_id"}},
+			CR{"name": "posts_fk_0", "table": "posts", "columns": []string{"author_id"}, "ref_table": "users", "ref_columns": []string{"user_id"}},
+		}
+		if b.name != "sqlite" {
+			relations = []CR{
+				CR{"name": "messages_ibfk_1", "table": "messages", "columns": []string{"from_id"}, "ref_table": "users", "ref_columns": []string{"user_id"}},
+				CR{"name": "messages_ibfk_2", "table": "messages", "columns": []string{"to_id"}, "ref_table": "users", "ref_columns": []string{"user_id"}},
+				CR{"name": "posts_ibfk_1", "table": "posts", "columns": []string{"author_id"}, "ref_table": "users", "ref_columns": []string{"user_id"}},
+			}
+		}
+
+		rvasily := CR{
+			"user_id":  1,
+			"login":    "rvasily",
+			"password": "love",
+			"email":    "rvasily@example.com",
+			"info":     "none",
+			"updated":  nil,
+		}
+
+		s.run(t, []Case{
+			Case{
+				Path: "/",
+				Result: CR{
+					"response": CR{
+						"tables":    []string{"items", "messages", "posts", "users"},
+						"relations": relations,
+					},
+				},
+			},
+			Case{
+				Path:  "/posts/1",
+				Query: "expand=author_id",
+				Result: CR{
+					"response": CR{
+						"record": CR{"id": 1, "author_id": rvasily, "title": "first"},
+					},
+				},
+			},
+			Case{
+				Path:  "/posts",
+				Query: "fields=title&expand=author_id&limit=2",
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"title": "first", "author_id": rvasily},
+							CR{"title": "anonymous", "author_id": nil},
+						},
+					},
+				},
+			},
+			Case{
+				Path:   "/posts/1",
+				Query:  "expand=title",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "unknown relation title", "code": "bad_request"},
+			},
+			Case{
+				Path:  "/users/1/posts",
+				Query: "fields=id&order=-id",
+				Result: CR{
+					"response": CR{
+						"records": []CR{CR{"id": 3}, CR{"id": 1}},
+					},
+				},
+			},
+			Case{
+				Path:   "/users/100500/posts",
+				Status: http.StatusNotFound,
+				Result: CR{"error": "record not found", "code": "not_found"},
+			},
+			Case{
+				Path:   "/users/1/messages",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "ambiguous relation, use via", "code": "bad_request"},
+			},
+			Case{
+				Path:  "/users/1/messages",
+				Query: "via=to_id&fields=body",
+				Result: CR{
+					"response": CR{
+						"records": []CR{CR{"body": "note to self"}},
+					},
+				},
+			},
+			Case{
+				Path:   "/posts/1/users",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "unknown relation", "code": "bad_request"},
+			},
+		})
+	})
+}
+
+func TestRelationsHiddenAndDeleted(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, []string{
+			`ALTER TABLE users ADD COLUMN deleted_at datetime DEFAULT NULL;`,
+			`INSERT INTO users (user_id, login, password, email, info, deleted_at) VALUES (2, 'gone', '', '', '', '2020-01-01 00:00:00');`,
+			`CREATE TABLE posts (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  author_id int NOT NULL,
+  title varchar(255) NOT NULL,
+  deleted_at datetime DEFAULT NULL,
+  FOREIGN KEY (author_id) REFERENCES users (user_id)
+);`,
+			`INSERT INTO posts (id, author_id, title, deleted_at) VALUES
+(1, 1, 'first', NULL),
+(2, 2, 'by the deleted', NULL),
+(3, 1, 'deleted', '2020-01-01 00:00:00');`,
+		}, WithPolicy(Policy{Tables: map[string]TableRule{
+			"users": TableRule{Read: []string{anyone}},
+			"posts": TableRule{
+				Read: []string{anyone},
+				Columns: map[string]ColumnRule{
+					"author_id": ColumnRule{},
+				},
+			},
+		}}))
+
+		s.run(t, []Case{
+			Case{
+				Path: "/users/1/posts",
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"id": 1, "title": "first", "deleted_at": nil},
+						},
+					},
+				},
+			},
+			Case{
+				Path:   "/users/2/posts",
+				Status: http.StatusNotFound,
+				Result: CR{"error": "record not found", "code": "not_found"},
+			},
+			Case{
+				Path:  "/users/2/posts",
+				Query: "with_deleted=true",
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"id": 2, "title": "by the deleted", "deleted_at": nil},
+						},
+					},
+				},
+			},
+			Case{
+				Path:   "/posts/1",
+				Query:  "expand=author_id",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "unknown relation author_id", "code": "bad_request"},
+			},
+		})
+
+		b.serveDB(t, s.db).run(t, []Case{
+			Case{
+				Path:  "/posts/2",
+				Query: "expand=author_id",
+				Result: CR{
+					"response": CR{
+						"record": CR{"id": 2, "author_id": nil, "title": "by the deleted", "deleted_at": nil},
+					},
+				},
+			},
+			Case{
+				Path:  "/posts",
+				Query: "fields=id,author_id&expand=author_id&with_deleted=true&order=-id&limit=1",
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"id": 3, "author_id": CR{"user_id": 1, "login": "rvasily", "password": "love", "email": "rvasily@example.com", "info": "none", "updated": nil, "deleted_at": nil}},
+						},
+					},
+				},
+			},
+			Case{
+				Path:  "/posts/2",
+				Query: "expand=author_id&with_deleted=true",
+				Result: CR{
+					"response": CR{
+						"record": CR{"id": 2, "title": "by the deleted", "deleted_at": nil, "author_id": CR{"user_id": 2, "login": "gone", "password": "", "email": "", "info": "", "updated": nil, "deleted_at": "2020-01-01T00:00:00Z"}},
+					},
+				},
+			},
+		})
+	})
+}
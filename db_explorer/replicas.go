@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// lastWriteHeader carries the time of the last write of a client, in
+	// unix milliseconds. Responses to writes set it, reads sending it back
+	// within the replica lag are served by the primary.
+	lastWriteHeader = "X-Last-Write"
+
+	defaultReplicaLag    = 2 * time.Second
+	replicaCheckInterval = 5 * time.Second
+	replicaPingTimeout   = time.Second
+)
+
+type (
+	replica struct {
+		db      *sql.DB
+		healthy int32
+	}
+
+	// replicaSet spreads reads over the healthy replicas round-robin
+	replicaSet struct {
+		replicas []*replica
+		next     uint32
+		lag      time.Duration
+	}
+
+	// readRouter sends queries to a replica and falls back to the primary
+	readRouter struct {
+		d *DbExplorer
+	}
+
+	// stampWriter sets lastWriteHeader when the response to a write is sent,
+	// that is after the write was committed
+	stampWriter struct {
+		http.ResponseWriter
+		stamped bool
+	}
+)
+
+// WithReplicas sends reads of records and lists to the replicas, the
+// database given to NewDbExplorer stays the primary that takes every
+// write. Replicas are checked every few seconds and one failing a query is
+// left out until it answers again, meanwhile reads go to the primary.
+func WithReplicas(dbs ...*sql.DB) Option {
+	return func(d *DbExplorer) {
+		if d.replicas == nil {
+			d.replicas = &replicaSet{lag: defaultReplicaLag}
+		}
+		for _, db := range dbs {
+			d.replicas.replicas = append(d.replicas.replicas, &replica{db: db, healthy: 1})
+		}
+	}
+}
+
+// WithReplicaLag sets how long after a write, reported back by the client
+// in the X-Last-Write header, its reads go to the primary. It should cover
+// the replication lag.
+func WithReplicaLag(lag time.Duration) Option {
+	return func(d *DbExplorer) {
+		if d.replicas == nil {
+			d.replicas = &replicaSet{}
+		}
+		d.replicas.lag = lag
+	}
+}
+
+func (rs *replicaSet) pick() *replica {
+	n := uint32(len(rs.replicas))
+	if n == 0 {
+		return nil
+	}
+	start := atomic.AddUint32(&rs.next, 1)
+	for i := uint32(0); i < n; i++ {
+		if rep := rs.replicas[(start+i)%n]; atomic.LoadInt32(&rep.healthy) == 1 {
+			return rep
+		}
+	}
+	return nil
+}
+
+// check pings the replica and marks it as healthy or not
+func (rep *replica) check() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaPingTimeout)
+	defer cancel()
+	healthy := rep.db.PingContext(ctx) == nil
+	atomic.StoreInt32(&rep.healthy, int32(boolToInt(healthy)))
+	return healthy
+}
+
+func (d *DbExplorer) checkReplicas() {
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, rep := range d.replicas.replicas {
+				rep.check()
+			}
+		case <-d.state.done:
+			return
+		}
+	}
+}
+
+// recentWrite tells whether the last write of the client may not have
+// reached the replicas yet
+func (rs *replicaSet) recentWrite(header string) bool {
+	if header == "" {
+		return false
+	}
+	ms, err := strconv.ParseInt(header, 10, 64)
+	if err != nil {
+		return false
+	}
+	return time.Since(time.UnixMilli(ms)) < rs.lag
+}
+
+// readsPrimary tells whether the reads of the request go to the primary
+func (d *DbExplorer) readsPrimary() bool {
+	return d.replicas == nil || d.primaryReads
+}
+
+// reader returns where reads that may lag behind the writes go
+func (d *DbExplorer) reader() queryer {
+	if d.readsPrimary() {
+		return d.primary()
+	}
+	return d.metrics.timeQueries(readRouter{d: d})
+}
+
+func (rr readRouter) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	if rep := rr.d.replicas.pick(); rep != nil {
+		rows, err := rep.db.QueryContext(rr.d.ctx.statement(), query, args...)
+		if err == nil {
+			return rows, nil
+		}
+		// a query failing on a replica that still answers would fail on
+		// the primary as well
+		if rep.check() {
+			return nil, err
+		}
+	}
+	return dbQueryer{db: rr.d.db, ctx: rr.d.ctx}.Query(query, args...)
+}
+
+func (s *stampWriter) stamp() {
+	if !s.stamped {
+		s.stamped = true
+		s.Header().Set(lastWriteHeader, strconv.FormatInt(time.Now().UnixMilli(), 10))
+	}
+}
+
+func (s *stampWriter) WriteHeader(status int) {
+	s.stamp()
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *stampWriter) Write(b []byte) (int, error) {
+	s.stamp()
+	return s.ResponseWriter.Write(b)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReplicas(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		replica := b.replica(t)
+		// the replica lags behind, the primary has already changed the title
+		db := b.prepare(t, `UPDATE items SET title = 'primary' WHERE id = 1`)
+		ts := b.serveDB(t, db, WithReplicas(replica), WithReplicaLag(time.Minute))
+
+		title := func(headers map[string]string) interface{} {
+			resp, result := doWithHeaders(t, http.MethodGet, ts.URL+"/items/1", headers, nil)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("GET /items/1: %d %v", resp.StatusCode, result)
+			}
+			return result["response"].(map[string]interface{})["record"].(map[string]interface{})["title"]
+		}
+
+		if got := title(nil); got != "database/sql" {
+			t.Errorf("reads go to the replica: expected database/sql, got %v", got)
+		}
+		_, result := doWithHeaders(t, http.MethodGet, ts.URL+"/items?fields=title&limit=1&total=true", nil, nil)
+		if records := result["response"].(map[string]interface{})["records"].([]interface{}); records[0].(map[string]interface{})["title"] != "database/sql" {
+			t.Errorf("lists go to the replica, got %v", result)
+		}
+
+		resp, _ := doWithHeaders(t, http.MethodPost, ts.URL+"/items/2", nil, CR{"title": "written"})
+		lastWrite := resp.Header.Get(lastWriteHeader)
+		if _, err := strconv.ParseInt(lastWrite, 10, 64); err != nil {
+			t.Fatalf("writes must return %s, got %q", lastWriteHeader, lastWrite)
+		}
+		if got := title(map[string]string{lastWriteHeader: lastWrite}); got != "primary" {
+			t.Errorf("read after a write goes to the primary: expected primary, got %v", got)
+		}
+		old := strconv.FormatInt(time.Now().Add(-time.Hour).UnixMilli(), 10)
+		if got := title(map[string]string{lastWriteHeader: old}); got != "database/sql" {
+			t.Errorf("read long after a write goes to the replica: expected database/sql, got %v", got)
+		}
+
+		// a failing replica is left out
+		replica.Close()
+		if got := title(nil); got != "primary" {
+			t.Errorf("replica is down: expected primary, got %v", got)
+		}
+		if rep := ts.handler.replicas.pick(); rep != nil {
+			t.Errorf("the failed replica must be marked unhealthy")
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// schemaPath is the admin endpoint that reloads the schema: POST /_schema
+const schemaPath = "_schema"
+
+type (
+	// schema is an immutable snapshot of tables and their columns, a reload
+	// builds a new one instead of changing the current one
+	schema struct {
+		tables  []string
+		columns map[string]Table
+	}
+	// schemaState is shared by all the requests, DbExplorer is copied per
+	// request so it is kept behind a pointer
+	schemaState struct {
+		current atomic.Value
+		// reload makes concurrent reloads wait for each other
+		reload sync.Mutex
+		done   chan struct{}
+		once   sync.Once
+	}
+)
+
+// WithSchemaRefresh reloads the schema every interval in the background,
+// Close stops it.
+func WithSchemaRefresh(interval time.Duration) Option {
+	return func(d *DbExplorer) {
+		d.refresh = interval
+	}
+}
+
+func (d *DbExplorer) loadSchema() (s *schema, err error) {
+	s = &schema{}
+	s.tables, err = d.getAllTables()
+	if err != nil {
+		return nil, err
+	}
+	if d.audit != nil {
+		tables := make([]string, 0, len(s.tables))
+		for _, table := range s.tables {
+			if !d.hidesTable(table) {
+				tables = append(tables, table)
+			}
+		}
+		s.tables = tables
+	}
+	s.columns = make(map[string]Table, len(s.tables))
+	for _, table := range s.tables {
+		tab, err := d.getColumns(table)
+		if err != nil {
+			return nil, err
+		}
+		cols := make([]string, len(tab.Columns))
+		for i, c := range tab.Columns {
+			cols[i] = d.quote(c.Name)
+		}
+		tab.columnString = strings.Join(cols, ", ")
+		tab.ForeignKeys, err = d.dialect.ForeignKeys(d.primary(), table)
+		if err != nil {
+			return nil, err
+		}
+		tab.FullText, err = d.dialect.FullTextIndexes(d.primary(), table)
+		if err != nil {
+			return nil, err
+		}
+		s.columns[table] = tab
+	}
+	resolveForeignKeys(s.columns)
+	return s, nil
+}
+
+// ReloadSchema reads tables and columns from the database again and swaps
+// them in at once. Requests already being served keep the schema they
+// started with, on error the current schema stays in place.
+func (d *DbExplorer) ReloadSchema() (err error) {
+	d.state.reload.Lock()
+	defer d.state.reload.Unlock()
+	s, err := d.loadSchema()
+	if err != nil {
+		return err
+	}
+	d.state.current.Store(s)
+	d.cache.purge()
+	return nil
+}
+
+// snapshot returns a copy of d working with the current schema, it is
+// taken once per request
+func (d *DbExplorer) snapshot() *DbExplorer {
+	view := *d
+	s := d.state.current.Load().(*schema)
+	view.tables, view.columns = s.tables, s.columns
+	return &view
+}
+
+func (d *DbExplorer) pollSchema(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// a failed reload keeps the old schema, the next tick tries again
+			d.ReloadSchema()
+		case <-d.state.done:
+			return
+		}
+	}
+}
+
+// Close stops the background schema refresh, the database is left open
+func (d *DbExplorer) Close() error {
+	d.state.once.Do(func() {
+		close(d.state.done)
+	})
+	return nil
+}
+
+func (d *DbExplorer) postSchema(w http.ResponseWriter, r *http.Request) (err error) {
+	if !d.policy.canAdmin(principalFrom(r)) {
+		return writeForbidden(w)
+	}
+	if err = d.ReloadSchema(); err != nil {
+		return errorInternal
+	}
+	s := d.state.current.Load().(*schema)
+	writeResponse(w, finalResponse{Response: map[string]interface{}{"tables": s.tables}})
+	return
+}
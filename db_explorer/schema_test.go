@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchemaReload(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, nil,
+			WithAuthenticator(APIKeyAuthenticator{"admin-key": Principal{Name: "root"}}),
+			WithPolicy(Policy{
+				Tables: map[string]TableRule{anyone: TableRule{Read: []string{anyone}, Write: []string{anyone}}},
+				Admin:  []string{"root"},
+			}),
+		)
+
+		for _, q := range []string{
+			`CREATE TABLE tags (id INTEGER PRIMARY KEY AUTOINCREMENT, name varchar(255) NOT NULL);`,
+			`ALTER TABLE items ADD COLUMN priority int DEFAULT NULL;`,
+		} {
+			if _, err := s.db.Exec(b.ddl(q)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		s.run(t, []Case{
+			Case{
+				Path:   "/tags",
+				Status: http.StatusNotFound,
+				Result: CR{"error": "unknown table", "code": "unknown_table"},
+			},
+			Case{
+				Path:   "/_schema",
+				Method: http.MethodPost,
+				Status: http.StatusForbidden,
+				Result: CR{"error": "forbidden", "code": "forbidden"},
+			},
+		})
+		s.as(t, "X-API-Key", "admin-key").run(t, []Case{
+			Case{
+				Path:   "/_schema",
+				Method: http.MethodPost,
+				Result: CR{"response": CR{"tables": []string{"items", "tags", "users"}}},
+			},
+		})
+		s.run(t, []Case{
+			Case{
+				Path:   "/tags/",
+				Method: http.MethodPut,
+				Body:   CR{"name": "go"},
+				Result: CR{"response": CR{"id": 1}},
+			},
+			Case{
+				Path:   "/items/1",
+				Method: http.MethodPost,
+				Body:   CR{"priority": 3},
+				Result: CR{"response": CR{"updated": 1}},
+			},
+			Case{
+				Path:  "/items",
+				Query: "fields=id,priority&where[priority][gt]=1",
+				Result: CR{
+					"response": CR{
+						"records": []CR{CR{"id": 1, "priority": 3}},
+					},
+				},
+			},
+		})
+	})
+}
+
+func TestSchemaSnapshot(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		ts := b.serve(t, nil)
+		handler := ts.handler
+
+		// a request that started before the reload keeps its own schema
+		inFlight := handler.snapshot()
+		if _, err := ts.db.Exec(`ALTER TABLE items ADD COLUMN priority int DEFAULT NULL;`); err != nil {
+			t.Fatal(err)
+		}
+		if err := handler.ReloadSchema(); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := inFlight.columns["items"].column("priority"); ok {
+			t.Errorf("in-flight snapshot changed by reload")
+		}
+		if _, ok := handler.snapshot().columns["items"].column("priority"); !ok {
+			t.Errorf("new snapshot misses the added column")
+		}
+
+		var wg sync.WaitGroup
+		stop := make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := handler.ReloadSchema(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		for i := 0; i < 50; i++ {
+			resp, err := client.Get(ts.URL + "/items?fields=id,priority")
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("request %d during reload: status %d, body %s", i, resp.StatusCode, body)
+			}
+			var result CR
+			if err = json.Unmarshal(body, &result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		close(stop)
+		wg.Wait()
+	})
+}
+
+func TestSchemaRefresh(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		ts := b.serve(t, nil, WithSchemaRefresh(10*time.Millisecond))
+
+		q := `CREATE TABLE tags (id INTEGER PRIMARY KEY AUTOINCREMENT, name varchar(255) NOT NULL);`
+		if _, err := ts.db.Exec(b.ddl(q)); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for {
+			resp, err := client.Get(ts.URL + "/tags")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("table created after start is not served, status %d", resp.StatusCode)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
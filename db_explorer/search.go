@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const searchPath = "_search"
+
+var errorNothingToSearch = &apiError{status: http.StatusBadRequest, code: CodeBadRequest, msg: "table has no text columns to search"}
+
+// search is the value of a "search" condition: columns of full-text indexes
+// are matched with MATCH ... AGAINST, the other text columns with LIKE
+type search struct {
+	fulltext [][]Col
+	like     []Col
+	term     string
+}
+
+func isTextColumn(c Col) bool {
+	return strings.Contains(c.Type, "char") || strings.Contains(c.Type, "text")
+}
+
+// searchCondition searches the text columns of tab, an index covering a
+// column missing from tab is not used so hidden columns are never searched
+func searchCondition(tab Table, term string) (cond condition, err error) {
+	s := search{term: term}
+	indexed := make(map[string]struct{})
+	for _, names := range tab.FullText {
+		cols := make([]Col, 0, len(names))
+		for _, name := range names {
+			if c, ok := tab.column(name); ok {
+				cols = append(cols, c)
+			}
+		}
+		if len(cols) < len(names) {
+			continue
+		}
+		s.fulltext = append(s.fulltext, cols)
+		for _, name := range names {
+			indexed[name] = struct{}{}
+		}
+	}
+	for _, c := range tab.Columns {
+		if _, ok := indexed[c.Name]; !ok && isTextColumn(c) {
+			s.like = append(s.like, c)
+		}
+	}
+	if len(s.fulltext) == 0 && len(s.like) == 0 {
+		return cond, errorNothingToSearch
+	}
+	return condition{op: "search", value: s}, nil
+}
+
+// searchSQL matches rows having the term in any of the columns, LIKE is
+// case insensitive on every engine
+func (d *DbExplorer) searchSQL(s search, args *sqlArgs) string {
+	parts := make([]string, 0, len(s.fulltext)+len(s.like))
+	for _, cols := range s.fulltext {
+		parts = append(parts, fmt.Sprintf("MATCH (%s) AGAINST (%s IN NATURAL LANGUAGE MODE)", d.columnsSQL(cols), args.add(s.term)))
+	}
+	pattern := "%" + strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(strings.ToLower(s.term)) + "%"
+	for _, c := range s.like {
+		parts = append(parts, fmt.Sprintf("LOWER(%s) LIKE %s ESCAPE '!'", d.quote(c.Name), args.add(pattern)))
+	}
+	return "(" + strings.Join(parts, " OR ") + ")"
+}
+
+// searchTables handles GET /_search?q=, it searches every readable table
+// with text columns at once and returns up to limit records of each
+func (d *DbExplorer) searchTables(w http.ResponseWriter, r *http.Request) (err error) {
+	term := r.URL.Query().Get("q")
+	if term == "" {
+		return badRequest("q is required")
+	}
+	limit, err := d.readLimit(r, 5)
+	if err != nil {
+		return err
+	}
+	p := principalFrom(r)
+
+	tables := make([]string, 0, len(d.tables))
+	queries := make([]listQuery, 0, len(d.tables))
+	for _, table := range d.tables {
+		if !d.policy.canRead(p, table) {
+			continue
+		}
+		tab := d.visibleTable(p, table)
+		cond, err := searchCondition(tab, term)
+		if err != nil {
+			continue
+		}
+		tables = append(tables, table)
+		where := append(notDeleted(r, tab), cond)
+		queries = append(queries, listQuery{limit: limit, fields: tab.Columns, where: where})
+	}
+
+	found := make([][]map[string]interface{}, len(tables))
+	errs := make([]error, len(tables))
+	var wg sync.WaitGroup
+	for i := range tables {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			found[i], errs[i] = d.selectList(tables[i], queries[i])
+		}(i)
+	}
+	wg.Wait()
+
+	results := make([]map[string]interface{}, 0)
+	for i, table := range tables {
+		if errs[i] != nil {
+			return errorInternal
+		}
+		for _, record := range found[i] {
+			results = append(results, map[string]interface{}{"table": table, "record": record})
+		}
+	}
+	writeResponse(w, finalResponse{Response: map[string]interface{}{"results": results}})
+	return
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestSearchSQL(t *testing.T) {
+	d := &DbExplorer{dialect: mysqlDialect{}}
+	tab := Table{
+		Columns: []Col{
+			Col{Name: "id", Type: "int", PK: true},
+			Col{Name: "title", Type: "varchar"},
+			Col{Name: "body", Type: "text"},
+			Col{Name: "note", Type: "varchar"},
+		},
+		FullText: [][]string{{"title", "body"}, {"secret"}},
+	}
+	cond, err := searchCondition(tab, "50%_Off")
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := &sqlArgs{dialect: d.dialect}
+	q := d.whereSQL([]condition{cond}, args)
+	expected := " WHERE (MATCH (`title`, `body`) AGAINST (? IN NATURAL LANGUAGE MODE) OR LOWER(`note`) LIKE ? ESCAPE '!')"
+	if q != expected {
+		t.Errorf("expected %s, got %s", expected, q)
+	}
+	if !reflect.DeepEqual(args.args, []interface{}{"50%_Off", "%50!%!_off%"}) {
+		t.Errorf("unexpected args %v", args.args)
+	}
+
+	if _, err = searchCondition(Table{Columns: []Col{Col{Name: "id", Type: "int"}}}, "x"); err != errorNothingToSearch {
+		t.Errorf("expected %v, got %v", errorNothingToSearch, err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, nil, WithPolicy(Policy{Tables: map[string]TableRule{
+			"items": TableRule{Read: []string{anyone}},
+			"users": TableRule{
+				Read: []string{anyone},
+				Columns: map[string]ColumnRule{
+					"password": ColumnRule{},
+				},
+			},
+		}}))
+
+		s.run(t, []Case{
+			Case{
+				Path:  "/items",
+				Query: "q=MEM&fields=id,title",
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"id": 2, "title": "memcache"},
+						},
+					},
+				},
+			},
+			// LIKE wildcards in the term are matched literally
+			Case{
+				Path:  "/items",
+				Query: "q=%25",
+				Result: CR{
+					"response": CR{
+						"records": []CR{},
+					},
+				},
+			},
+			Case{
+				Path:  "/_search",
+				Query: "q=rvasily",
+				Result: CR{
+					"response": CR{
+						"results": []CR{
+							CR{"table": "items", "record": CR{"id": 1, "title": "database/sql", "description": "Рассказать про базы данных", "updated": "rvasily"}},
+							CR{"table": "users", "record": CR{"user_id": 1, "login": "rvasily", "email": "rvasily@example.com", "info": "none", "updated": nil}},
+						},
+					},
+				},
+			},
+			// hidden columns are not searched
+			Case{
+				Path:  "/_search",
+				Query: "q=love",
+				Result: CR{
+					"response": CR{
+						"results": []CR{},
+					},
+				},
+			},
+			Case{
+				Path:   "/_search",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "q is required", "code": "bad_request"},
+			},
+		})
+	})
+}
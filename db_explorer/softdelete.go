@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultSoftDeleteColumn marks soft deleted records unless
+	// WithSoftDeleteColumn says otherwise
+	defaultSoftDeleteColumn = "deleted_at"
+	restorePath             = "_restore"
+)
+
+// AuditRestore is recorded when a soft deleted record is restored
+const AuditRestore = "restore"
+
+// WithSoftDeleteColumn sets the column that turns deletes into soft deletes
+// in the tables that have it, deleted_at by default. DELETE sets it to the
+// current time, records where it is set are hidden from lists and gets and
+// POST /$table/$id/_restore clears it. An empty name turns soft deletes off.
+func WithSoftDeleteColumn(name string) Option {
+	return func(d *DbExplorer) {
+		d.softDelete = name
+	}
+}
+
+// withDeleted tells whether a list or get should include soft deleted records
+func withDeleted(r *http.Request) bool {
+	with, _ := strconv.ParseBool(r.URL.Query().Get("with_deleted"))
+	return with
+}
+
+// notDeleted hides soft deleted records unless they were asked for
+func notDeleted(r *http.Request, tab Table) []condition {
+	if tab.softDelete == nil || withDeleted(r) {
+		return nil
+	}
+	return []condition{{col: *tab.softDelete, op: "null", value: true}}
+}
+
+func (t Table) isDeleted(record map[string]interface{}) bool {
+	return t.softDelete != nil && record[t.softDelete.Name] != nil
+}
+
+// deletedAt is the current time as the column stores it
+func (d *DbExplorer) deletedAt(c Col) interface{} {
+	now := time.Now().UTC()
+	t := d.types.lookup(c)
+	if t.Kind() == "integer" {
+		return now.Unix()
+	}
+	if v, err := t.Encode(c, now.Format(time.RFC3339Nano)); err == nil {
+		return v
+	}
+	return now
+}
+
+// markDeleted soft deletes the record with the key or restores it, only
+// records in the opposite state are changed
+func (d *DbExplorer) markDeleted(ex execer, table string, key []interface{}, deleted bool, extra ...condition) (changed int, err error) {
+	tab := d.columns[table]
+	c := *tab.softDelete
+	args := &sqlArgs{dialect: d.dialect}
+	var value interface{}
+	op := AuditRestore
+	if deleted {
+		value, op = d.deletedAt(c), AuditDelete
+	}
+	sets := []string{fmt.Sprintf("%s = %s", d.quote(c.Name), args.add(value))}
+	if v, ok := d.versionColumn(table); ok && d.types.lookup(v).Kind() == "integer" {
+		sets = append(sets, fmt.Sprintf("%s = %s + 1", d.quote(v.Name), d.quote(v.Name)))
+	}
+	conds := append(tab.keyConditions(key), condition{col: c, op: "null", value: deleted})
+	q := fmt.Sprintf("UPDATE %s SET %s", d.quote(table), strings.Join(sets, ", ")) + d.whereSQL(append(conds, extra...), args)
+
+	before, err := d.auditImage(ex, table, key)
+	if err != nil {
+		return 0, err
+	}
+	res, err := ex.Exec(q, args.args...)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil || affected == 0 {
+		return 0, err
+	}
+	after, err := d.auditImage(ex, table, key)
+	if err != nil {
+		return 0, err
+	}
+	return 1, d.recordChange(ex, op, table, key, before, after)
+}
+
+func (d *DbExplorer) restoreByKey(table string, key []interface{}) (restored int, err error) {
+	tx, err := d.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	restored, err = d.markDeleted(tx, table, key, false)
+	if err != nil {
+		return 0, err
+	}
+	return restored, tx.Commit()
+}
+
+// restoreRecord handles POST /$table/$id/_restore
+func (d *DbExplorer) restoreRecord(w http.ResponseWriter, r *http.Request) (err error) {
+	arr := extractPartsOfPath(r)
+	if len(arr) != 3 {
+		return errorUnknownPath
+	}
+	table := arr[0]
+	tab, ok := d.columns[table]
+	if !ok {
+		return writeUnknownTable(w)
+	}
+	if tab.softDelete == nil {
+		return errorUnknownPath
+	}
+	if !d.policy.canWrite(principalFrom(r), table) {
+		return writeForbidden(w)
+	}
+	key, err := d.parseRecordKey(r, tab, arr[1])
+	if err != nil {
+		return err
+	}
+
+	restored, err := d.restoreByKey(table, key)
+	if err != nil {
+		return d.dbError(err)
+	}
+	writeResponse(w, finalResponse{Response: map[string]interface{}{"restored": restored}})
+	return
+}
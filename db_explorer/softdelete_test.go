@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSoftDelete(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, []string{
+			`CREATE TABLE notes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  body text NOT NULL,
+  deleted_at datetime DEFAULT NULL
+);`,
+			`INSERT INTO notes (body) VALUES ('first'), ('second');`,
+		})
+
+		s.run(t, []Case{
+			Case{
+				Path:   "/notes/1",
+				Method: http.MethodDelete,
+				Result: CR{"response": CR{"deleted": 1}},
+			},
+			Case{
+				Path:   "/notes/1",
+				Method: http.MethodDelete,
+				Result: CR{"response": CR{"deleted": 0}},
+			},
+			Case{
+				Path: "/notes",
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"id": 2, "body": "second", "deleted_at": nil},
+						},
+					},
+				},
+			},
+			Case{
+				Path:   "/notes/1",
+				Status: http.StatusNotFound,
+				Result: CR{"error": "record not found", "code": "not_found"},
+			},
+			// soft deleted records can't be changed, only restored
+			Case{
+				Path:   "/notes/1",
+				Method: http.MethodPost,
+				Body:   CR{"body": "changed"},
+				Status: http.StatusNotFound,
+				Result: CR{"error": "record not found", "code": "not_found"},
+			},
+			Case{
+				Path:   "/notes/1",
+				Method: http.MethodPut,
+				Body:   CR{"body": "replaced"},
+				Status: http.StatusNotFound,
+				Result: CR{"error": "record not found", "code": "not_found"},
+			},
+			Case{
+				Path:   "/notes/1",
+				Method: http.MethodPatch,
+				Body:   CR{"body": "patched"},
+				Status: http.StatusNotFound,
+				Result: CR{"error": "record not found", "code": "not_found"},
+			},
+			Case{
+				Path:   "/notes/1",
+				Status: http.StatusNotFound,
+				Result: CR{"error": "record not found", "code": "not_found"},
+			},
+			// the soft delete column is not written by updates
+			Case{
+				Path:   "/notes/2",
+				Method: http.MethodPost,
+				Body:   CR{"body": "kept", "deleted_at": "2020-01-01 00:00:00"},
+				Result: CR{"response": CR{"updated": 1}},
+			},
+			Case{
+				Path:   "/notes/2",
+				Method: http.MethodPatch,
+				Body:   CR{"deleted_at": "2020-01-01 00:00:00"},
+				Result: CR{"response": CR{"updated": 0}},
+			},
+			Case{
+				Path: "/notes/2",
+				Result: CR{
+					"response": CR{
+						"record": CR{"id": 2, "body": "kept", "deleted_at": nil},
+					},
+				},
+			},
+			Case{
+				Path:  "/notes",
+				Query: "with_deleted=true&fields=id",
+				Result: CR{
+					"response": CR{
+						"records": []CR{CR{"id": 1}, CR{"id": 2}},
+					},
+				},
+			},
+		})
+
+		_, result := doWithHeaders(t, http.MethodGet, s.URL+"/notes/1?with_deleted=true", nil, nil)
+		record, _ := result["response"].(map[string]interface{})["record"].(map[string]interface{})
+		if record == nil || record["deleted_at"] == nil {
+			t.Fatalf("expected the soft deleted record, got %v", result)
+		}
+
+		s.run(t, []Case{
+			Case{
+				Path:   "/notes/1/_restore",
+				Method: http.MethodPost,
+				Result: CR{"response": CR{"restored": 1}},
+			},
+			Case{
+				Path:   "/notes/1/_restore",
+				Method: http.MethodPost,
+				Result: CR{"response": CR{"restored": 0}},
+			},
+			Case{
+				Path: "/notes/1",
+				Result: CR{
+					"response": CR{
+						"record": CR{"id": 1, "body": "first", "deleted_at": nil},
+					},
+				},
+			},
+			Case{
+				Path:   "/items/1/_restore",
+				Method: http.MethodPost,
+				Status: http.StatusNotFound,
+				Result: CR{"error": "unknown path", "code": "not_found"},
+			},
+		})
+
+		// without the soft delete column records are really deleted
+		b.serveDB(t, s.db, WithSoftDeleteColumn("")).run(t, []Case{
+			Case{
+				Path:   "/notes/1",
+				Method: http.MethodDelete,
+				Result: CR{"response": CR{"deleted": 1}},
+			},
+			Case{
+				Path:   "/notes/1",
+				Query:  "with_deleted=true",
+				Status: http.StatusNotFound,
+				Result: CR{"error": "record not found", "code": "not_found"},
+			},
+		})
+	})
+}
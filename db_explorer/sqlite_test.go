@@ -0,0 +1,320 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func PrepareSQLiteTestApis(t testing.TB) *sql.DB {
+	db, err := sql.Open("sqlite3", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an in-memory database lives as long as its only connection
+	db.SetMaxOpenConns(1)
+
+	qs := []string{
+		// MySQL always checks foreign keys
+		`PRAGMA foreign_keys = ON;`,
+		`CREATE TABLE items (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title varchar(255) NOT NULL,
+  description text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`,
+
+		`INSERT INTO items (id, title, description, updated) VALUES
+(1,	'database/sql',	'Рассказать про базы данных',	'rvasily'),
+(2,	'memcache',	'Рассказать про мемкеш с примером использования',	NULL);`,
+
+		`CREATE TABLE users (
+  user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  login varchar(255) NOT NULL,
+  password varchar(255) NOT NULL,
+  email varchar(255) NOT NULL,
+  info text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`,
+
+		`INSERT INTO users (user_id, login, password, email, info, updated) VALUES
+(1,	'rvasily',	'love',	'rvasily@example.com',	'none',	NULL);`,
+	}
+	for _, q := range qs {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestApisSQLite(t *testing.T) {
+	db := PrepareSQLiteTestApis(t)
+	defer db.Close()
+
+	handler, err := NewDbExplorer(db, WithDialect(sqliteDialect{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	runCases(t, ts, db, apiCases())
+}
+
+func TestSQLiteIntrospection(t *testing.T) {
+	db := PrepareSQLiteTestApis(t)
+	defer db.Close()
+
+	tab, err := sqliteDialect{}.Columns(db, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tab.PK, []string{"user_id"}) {
+		t.Errorf("expected pk [user_id], got %v", tab.PK)
+	}
+	if _, ok := tab.AutoIncrement["user_id"]; !ok {
+		t.Errorf("expected user_id to be auto increment")
+	}
+	want := []Col{
+		{Name: "user_id", Type: "int", Def: "integer", PK: true},
+		{Name: "login", Type: "varchar", Def: "varchar(255)"},
+		{Name: "password", Type: "varchar", Def: "varchar(255)"},
+		{Name: "email", Type: "varchar", Def: "varchar(255)"},
+		{Name: "info", Type: "text", Def: "text"},
+		{Name: "updated", Type: "varchar", Def: "varchar(255)", Null: true},
+	}
+	if len(tab.Columns) != len(want) {
+		t.Fatalf("expected %d columns, got %d", len(want), len(tab.Columns))
+	}
+	for i, c := range tab.Columns {
+		if c != want[i] {
+			t.Errorf("column %d: expected %+v, got %+v", i, want[i], c)
+		}
+	}
+}
+
+func TestDialectQueries(t *testing.T) {
+	cases := []struct {
+		driver      string
+		quoted      string
+		placeholder string
+		returning   bool
+	}{
+		{"mysql", "`we``ird`", "?", false},
+		{"postgres", `"we""ird"`, "$2", true},
+		{"sqlite3", `"we""ird"`, "?", false},
+	}
+	for _, c := range cases {
+		d, err := DialectByDriver(c.driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q := d.Quote("we`ird"); c.driver == "mysql" && q != c.quoted {
+			t.Errorf("[%s] expected %s, got %s", c.driver, c.quoted, q)
+		}
+		if q := d.Quote(`we"ird`); c.driver != "mysql" && q != c.quoted {
+			t.Errorf("[%s] expected %s, got %s", c.driver, c.quoted, q)
+		}
+		if p := d.Placeholder(2); p != c.placeholder {
+			t.Errorf("[%s] expected placeholder %s, got %s", c.driver, c.placeholder, p)
+		}
+		if d.Returning() != c.returning {
+			t.Errorf("[%s] unexpected returning support", c.driver)
+		}
+	}
+	if _, err := DialectByDriver("oracle"); err == nil {
+		t.Errorf("expected error for unknown driver")
+	}
+}
+
+func TestListQuery(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		b.serve(t, nil).run(t, []Case{
+			Case{
+				Path:  "/items",
+				Query: "fields=id,title&order=-id",
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"id": 2, "title": "memcache"},
+							CR{"id": 1, "title": "database/sql"},
+						},
+					},
+				},
+			},
+			Case{
+				Path:  "/items",
+				Query: "fields=title&where[id][gte]=2",
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"title": "memcache"},
+						},
+					},
+				},
+			},
+			Case{
+				Path:  "/items",
+				Query: "fields=id&where[title][like]=data%25&where[updated][null]=false",
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"id": 1},
+						},
+					},
+				},
+			},
+			Case{
+				Path:  "/items",
+				Query: "fields=id&where[id][in]=1,2&where[updated]=rvasily",
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"id": 1},
+						},
+					},
+				},
+			},
+			Case{
+				Path:  "/items",
+				Query: "fields=id&where[updated][null]=true&order=title,-id",
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"id": 2},
+						},
+					},
+				},
+			},
+			Case{
+				Path:   "/items",
+				Query:  "where[unknown][eq]=1",
+				Status: http.StatusBadRequest,
+				Result: CR{
+					"error": "unknown column unknown",
+					"code":  "bad_request",
+				},
+			},
+			Case{
+				Path:   "/items",
+				Query:  "where[id][between]=1",
+				Status: http.StatusBadRequest,
+				Result: CR{
+					"error": "unknown operator between",
+					"code":  "bad_request",
+				},
+			},
+			Case{
+				Path:   "/items",
+				Query:  "where[id][gt]=x",
+				Status: http.StatusBadRequest,
+				Result: CR{
+					"error": "field id have invalid type",
+					"code":  "invalid_type",
+					"field": "id",
+				},
+			},
+			Case{
+				Path:   "/items",
+				Query:  "order=-password",
+				Status: http.StatusBadRequest,
+				Result: CR{
+					"error": "unknown column password",
+					"code":  "bad_request",
+				},
+			},
+			Case{
+				Path:   "/items",
+				Query:  "fields=id,login",
+				Status: http.StatusBadRequest,
+				Result: CR{
+					"error": "unknown column login",
+					"code":  "bad_request",
+				},
+			},
+		})
+	})
+}
+
+func TestCursorPagination(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		b.serve(t, nil).run(t, []Case{
+			Case{
+				Path:   "/items/",
+				Method: http.MethodPut,
+				Body:   CR{"title": "cursor", "description": ""},
+				Result: CR{"response": CR{"id": 3}},
+			},
+			Case{
+				Path:  "/items",
+				Query: "cursor=&limit=2&fields=title&total=1",
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"title": "database/sql"},
+							CR{"title": "memcache"},
+						},
+						"next_cursor": encodeCursor(2),
+						"total":       3,
+					},
+				},
+			},
+			Case{
+				Path:  "/items",
+				Query: "limit=2&fields=id&cursor=" + encodeCursor(2),
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"id": 3},
+						},
+						"next_cursor": nil,
+					},
+				},
+			},
+			Case{
+				Path:  "/items",
+				Query: "limit=1&fields=id&where[updated][null]=true&total=true&cursor=" + encodeCursor(1),
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"id": 2},
+						},
+						"next_cursor": encodeCursor(2),
+						"total":       2,
+					},
+				},
+			},
+			// limit/offset stays the default
+			Case{
+				Path:  "/items",
+				Query: "limit=1&offset=2&fields=id&total=1",
+				Result: CR{
+					"response": CR{
+						"records": []CR{
+							CR{"id": 3},
+						},
+						"total": 3,
+					},
+				},
+			},
+			Case{
+				Path:   "/items",
+				Query:  "cursor=!!!",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "invalid cursor", "code": "bad_request"},
+			},
+			Case{
+				Path:   "/items",
+				Query:  "cursor=&order=title",
+				Status: http.StatusBadRequest,
+				Result: CR{"error": "order is not supported with cursor pagination", "code": "bad_request"},
+			},
+		})
+	})
+}
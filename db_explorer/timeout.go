@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+type (
+	// requestContext is the context of a request on a snapshot. Statements
+	// run for the request stop when the client goes away and, with
+	// WithStatementTimeout, get a deadline each.
+	requestContext struct {
+		ctx     context.Context
+		timeout time.Duration
+
+		mu      sync.Mutex
+		stmts   []context.Context
+		cancels []context.CancelFunc
+	}
+
+	// dbQueryer runs queries on db within the context of the request
+	dbQueryer struct {
+		db  *sql.DB
+		ctx *requestContext
+	}
+)
+
+// WithStatementTimeout cancels statements running longer than timeout,
+// reading their rows included, and answers the request with 504 Gateway
+// Timeout. Exports stream their rows, a timeout cuts large ones short.
+func WithStatementTimeout(timeout time.Duration) Option {
+	return func(d *DbExplorer) {
+		d.timeout = timeout
+	}
+}
+
+// request returns the context of the request, background work outside of
+// requests has none
+func (rc *requestContext) request() context.Context {
+	if rc == nil {
+		return context.Background()
+	}
+	return rc.ctx
+}
+
+// statement returns the context to run a statement with
+func (rc *requestContext) statement() context.Context {
+	if rc == nil || rc.timeout <= 0 {
+		return rc.request()
+	}
+	ctx, cancel := context.WithTimeout(rc.ctx, rc.timeout)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.stmts = append(rc.stmts, ctx)
+	rc.cancels = append(rc.cancels, cancel)
+	return ctx
+}
+
+// timedOut tells whether a statement of the request ran out of time
+func (rc *requestContext) timedOut() bool {
+	if rc == nil {
+		return false
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for _, ctx := range rc.stmts {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return true
+		}
+	}
+	return false
+}
+
+// release stops the timers of the statements once the request is served
+func (rc *requestContext) release() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for _, cancel := range rc.cancels {
+		cancel()
+	}
+}
+
+func (q dbQueryer) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return q.db.QueryContext(q.ctx.statement(), query, args...)
+}
+
+// primary returns where reads that must see the latest writes go
+func (d *DbExplorer) primary() queryer {
+	return d.metrics.timeQueries(dbQueryer{db: d.db, ctx: d.ctx})
+}
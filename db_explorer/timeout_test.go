@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func setFakeDelay(t *testing.T, delay time.Duration) {
+	fakeDatabases.Lock()
+	db := fakeDatabases.m[t.Name()]
+	fakeDatabases.Unlock()
+	db.mu.Lock()
+	db.delay = delay
+	db.mu.Unlock()
+}
+
+func TestStatementTimeout(t *testing.T) {
+	db, err := sql.Open("fakedb", t.Name()+"?seed=docker-entrypoint-initdb.d/sample_db.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	handler, err := NewDbExplorer(db, WithStatementTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	if resp, result := doWithHeaders(t, http.MethodGet, ts.URL+"/items/1", nil, nil); resp.StatusCode != http.StatusOK {
+		t.Fatalf("fast statements are not affected, got %d %v", resp.StatusCode, result)
+	}
+
+	setFakeDelay(t, time.Hour)
+	resp, result := doWithHeaders(t, http.MethodGet, ts.URL+"/items/1", nil, nil)
+	if resp.StatusCode != http.StatusGatewayTimeout || result["code"] != CodeTimeout {
+		t.Errorf("expected a timeout, got %d %v", resp.StatusCode, result)
+	}
+	resp, result = doWithHeaders(t, http.MethodPost, ts.URL+"/items/1", nil, CR{"title": "late"})
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("expected writes to time out, got %d %v", resp.StatusCode, result)
+	}
+
+	setFakeDelay(t, 0)
+	_, result = doWithHeaders(t, http.MethodGet, ts.URL+"/items/1", nil, nil)
+	if title := result["response"].(map[string]interface{})["record"].(map[string]interface{})["title"]; title != "database/sql" {
+		t.Errorf("a write that timed out must be rolled back, got %v", title)
+	}
+}
+
+func TestClientGone(t *testing.T) {
+	db, err := sql.Open("fakedb", t.Name()+"?seed=docker-entrypoint-initdb.d/sample_db.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+		close(served)
+	}))
+	defer ts.Close()
+
+	setFakeDelay(t, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/items/1", nil)
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatalf("the request was expected to be cancelled, got %d", resp.StatusCode)
+	}
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Errorf("the query must stop when the client goes away")
+	}
+}
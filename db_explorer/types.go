@@ -0,0 +1,575 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errorInvalidType  = errors.New("invalid type")
+	errorInvalidValue = errors.New("invalid value")
+)
+
+type (
+	// ColumnType maps an SQL column type to its JSON representation. A type
+	// with a Format() string method gives the format of its values in the
+	// OpenAPI description too.
+	ColumnType interface {
+		// Kind is the JSON type of the value: integer, number, string,
+		// boolean or an empty string for arbitrary JSON.
+		Kind() string
+		// Decode converts a value scanned from the database.
+		Decode(c Col, v interface{}) (interface{}, error)
+		// Encode validates a non-null value from a request body and
+		// converts it to a driver argument.
+		Encode(c Col, v interface{}) (interface{}, error)
+		// Parse converts a value from the query string.
+		Parse(c Col, raw string) (interface{}, error)
+		// Zero is used for omitted NOT NULL columns on insert, false
+		// leaves the column to the database default.
+		Zero(c Col) (interface{}, bool)
+	}
+	typeRegistry map[string]ColumnType
+
+	// formatter is implemented by the column types having an OpenAPI format
+	formatter interface {
+		Format() string
+	}
+
+	intType      struct{}
+	floatType    struct{}
+	decimalType  struct{}
+	stringType   struct{}
+	boolType     struct{}
+	dateType     struct{ layout, out, format string }
+	timeType     struct{}
+	yearType     struct{}
+	enumType     struct{ set bool }
+	jsonType     struct{}
+	blobType     struct{}
+	fallbackType struct{}
+)
+
+func defaultTypes() typeRegistry {
+	tr := typeRegistry{}
+	for _, name := range []string{"int", "integer", "tinyint", "smallint", "mediumint", "bigint"} {
+		tr[name] = intType{}
+	}
+	for _, name := range []string{"float", "double", "real"} {
+		tr[name] = floatType{}
+	}
+	for _, name := range []string{"decimal", "numeric"} {
+		tr[name] = decimalType{}
+	}
+	for _, name := range []string{"varchar", "char", "text", "tinytext", "mediumtext", "longtext"} {
+		tr[name] = stringType{}
+	}
+	for _, name := range []string{"blob", "tinyblob", "mediumblob", "longblob", "binary", "varbinary"} {
+		tr[name] = blobType{}
+	}
+	tr["bool"] = boolType{}
+	tr["date"] = dateType{layout: "2006-01-02", out: "2006-01-02", format: "date"}
+	tr["datetime"] = dateType{layout: "2006-01-02 15:04:05.999999", out: time.RFC3339Nano, format: "date-time"}
+	tr["timestamp"] = tr["datetime"]
+	tr["time"] = timeType{}
+	tr["year"] = yearType{}
+	tr["enum"] = enumType{}
+	tr["set"] = enumType{set: true}
+	tr["json"] = jsonType{}
+	return tr
+}
+
+func (tr typeRegistry) lookup(c Col) ColumnType {
+	if t, ok := tr[c.Type]; ok {
+		return t
+	}
+	return fallbackType{}
+}
+
+// parse converts a query string value to the go type of the column
+func (tr typeRegistry) parse(col Col, raw string) (interface{}, error) {
+	v, err := tr.lookup(col).Parse(col, raw)
+	if err != nil {
+		return nil, fieldError(col, err)
+	}
+	return v, nil
+}
+
+func bytesOrString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case []byte:
+		return string(v), true
+	case string:
+		return v, true
+	}
+	return "", false
+}
+
+func (c Col) unsigned() bool {
+	return strings.Contains(c.Def, "unsigned")
+}
+
+// typeArgs returns the values inside the parentheses of the definition,
+// enum('a','b') gives [a b] and decimal(10,2) gives [10 2]
+func (c Col) typeArgs() (args []string) {
+	start := strings.Index(c.Def, "(")
+	end := strings.LastIndex(c.Def, ")")
+	if start < 0 || end < start {
+		return nil
+	}
+	inner := c.Def[start+1 : end]
+	var cur strings.Builder
+	quoted := false
+	for i := 0; i < len(inner); i++ {
+		ch := inner[i]
+		switch {
+		case ch == '\'' && quoted && i+1 < len(inner) && inner[i+1] == '\'':
+			cur.WriteByte('\'')
+			i++
+		case ch == '\'':
+			quoted = !quoted
+		case ch == ',' && !quoted:
+			args = append(args, strings.TrimSpace(cur.String()))
+			cur.Reset()
+		default:
+			cur.WriteByte(ch)
+		}
+	}
+	return append(args, strings.TrimSpace(cur.String()))
+}
+
+func (intType) Kind() string { return "integer" }
+
+func (t intType) Decode(c Col, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case int64, uint64:
+		return v, nil
+	case float64:
+		return int64(v), nil
+	case bool:
+		return int64(boolToInt(v)), nil
+	}
+	if s, ok := bytesOrString(v); ok {
+		return t.Parse(c, s)
+	}
+	return nil, errorInvalidType
+}
+
+func (t intType) Encode(c Col, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return t.Parse(c, v.String())
+	case float64:
+		if v != float64(int64(v)) {
+			return nil, errorInvalidType
+		}
+		if v < 0 && c.unsigned() {
+			return nil, errorInvalidValue
+		}
+		return int64(v), nil
+	}
+	return nil, errorInvalidType
+}
+
+func (intType) Parse(c Col, raw string) (interface{}, error) {
+	if i, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		if i < 0 && c.unsigned() {
+			return nil, errorInvalidValue
+		}
+		return i, nil
+	}
+	if u, err := strconv.ParseUint(raw, 10, 64); err == nil {
+		return u, nil
+	}
+	return nil, errorInvalidType
+}
+
+func (intType) Zero(Col) (interface{}, bool) { return 0, true }
+
+func (floatType) Kind() string { return "number" }
+
+func (t floatType) Decode(c Col, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	}
+	if s, ok := bytesOrString(v); ok {
+		return t.Parse(c, s)
+	}
+	return nil, errorInvalidType
+}
+
+func (t floatType) Encode(c Col, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return t.Parse(c, v.String())
+	case float64:
+		return v, nil
+	}
+	return nil, errorInvalidType
+}
+
+func (floatType) Parse(c Col, raw string) (interface{}, error) {
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil, errorInvalidType
+	}
+	return f, nil
+}
+
+func (floatType) Zero(Col) (interface{}, bool) { return 0.0, true }
+
+// decimals travel as strings so that no precision is lost on the way
+func (decimalType) Kind() string { return "string" }
+
+func (decimalType) Format() string { return "decimal" }
+
+func (decimalType) scale(c Col) int {
+	if args := c.typeArgs(); len(args) == 2 {
+		if s, err := strconv.Atoi(args[1]); err == nil {
+			return s
+		}
+	}
+	return -1
+}
+
+func (t decimalType) Decode(c Col, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', t.scale(c), 64), nil
+	case int64:
+		return strconv.FormatFloat(float64(v), 'f', t.scale(c), 64), nil
+	}
+	if s, ok := bytesOrString(v); ok {
+		return s, nil
+	}
+	return nil, errorInvalidType
+}
+
+func (t decimalType) Encode(c Col, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return t.Parse(c, v.String())
+	case string:
+		return t.Parse(c, v)
+	}
+	return nil, errorInvalidType
+}
+
+func (t decimalType) Parse(c Col, raw string) (interface{}, error) {
+	s := strings.TrimPrefix(strings.TrimPrefix(raw, "-"), "+")
+	intPart, fracPart := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return nil, errorInvalidValue
+	}
+	for _, ch := range intPart + fracPart {
+		if ch < '0' || ch > '9' {
+			return nil, errorInvalidValue
+		}
+	}
+	if args := c.typeArgs(); len(args) == 2 {
+		precision, _ := strconv.Atoi(args[0])
+		scale, _ := strconv.Atoi(args[1])
+		if len(strings.TrimLeft(intPart, "0")) > precision-scale || len(fracPart) > scale {
+			return nil, errorInvalidValue
+		}
+	}
+	return raw, nil
+}
+
+func (decimalType) Zero(Col) (interface{}, bool) { return "0", true }
+
+func (stringType) Kind() string { return "string" }
+
+func (stringType) Decode(c Col, v interface{}) (interface{}, error) {
+	if s, ok := bytesOrString(v); ok {
+		return s, nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+func (stringType) Encode(c Col, v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return nil, errorInvalidType
+}
+
+func (stringType) Parse(c Col, raw string) (interface{}, error) { return raw, nil }
+
+func (stringType) Zero(Col) (interface{}, bool) { return "", true }
+
+func (boolType) Kind() string { return "boolean" }
+
+func (t boolType) Decode(c Col, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case bool:
+		return v, nil
+	case int64:
+		return v != 0, nil
+	case []byte:
+		// bit(1) comes as a single raw byte
+		if len(v) == 1 && v[0] <= 1 {
+			return v[0] == 1, nil
+		}
+	}
+	if s, ok := bytesOrString(v); ok {
+		return t.Parse(c, s)
+	}
+	return nil, errorInvalidType
+}
+
+func (boolType) Encode(c Col, v interface{}) (interface{}, error) {
+	if b, ok := v.(bool); ok {
+		return b, nil
+	}
+	return nil, errorInvalidType
+}
+
+func (boolType) Parse(c Col, raw string) (interface{}, error) {
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, errorInvalidType
+	}
+	return b, nil
+}
+
+func (boolType) Zero(Col) (interface{}, bool) { return false, true }
+
+func (dateType) Kind() string { return "string" }
+
+func (t dateType) Format() string { return t.format }
+
+func (t dateType) Decode(c Col, v interface{}) (interface{}, error) {
+	if tm, ok := v.(time.Time); ok {
+		return tm.UTC().Format(t.out), nil
+	}
+	if s, ok := bytesOrString(v); ok {
+		for _, layout := range []string{t.layout, "2006-01-02 15:04:05.999999999", time.RFC3339Nano} {
+			if tm, err := time.Parse(layout, s); err == nil {
+				return tm.UTC().Format(t.out), nil
+			}
+		}
+		// zero dates like 0000-00-00 are not valid times, give them as is
+		return s, nil
+	}
+	return nil, errorInvalidType
+}
+
+func (t dateType) Encode(c Col, v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		return t.Parse(c, s)
+	}
+	return nil, errorInvalidType
+}
+
+// Parse accepts ISO-8601 dates and date-times
+func (t dateType) Parse(c Col, raw string) (interface{}, error) {
+	layouts := []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999", "2006-01-02"}
+	for _, layout := range layouts {
+		if tm, err := time.Parse(layout, raw); err == nil {
+			return tm.UTC().Format(t.layout), nil
+		}
+	}
+	return nil, errorInvalidValue
+}
+
+func (dateType) Zero(Col) (interface{}, bool) { return nil, false }
+
+func (timeType) Kind() string { return "string" }
+
+func (timeType) Format() string { return "time" }
+
+func (timeType) Decode(c Col, v interface{}) (interface{}, error) {
+	if tm, ok := v.(time.Time); ok {
+		return tm.Format("15:04:05"), nil
+	}
+	if s, ok := bytesOrString(v); ok {
+		return s, nil
+	}
+	return nil, errorInvalidType
+}
+
+func (t timeType) Encode(c Col, v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		return t.Parse(c, s)
+	}
+	return nil, errorInvalidType
+}
+
+func (timeType) Parse(c Col, raw string) (interface{}, error) {
+	if _, err := time.Parse("15:04:05.999999", raw); err != nil {
+		return nil, errorInvalidValue
+	}
+	return raw, nil
+}
+
+func (timeType) Zero(Col) (interface{}, bool) { return "00:00:00", true }
+
+func (yearType) Kind() string { return "integer" }
+
+func (t yearType) Decode(c Col, v interface{}) (interface{}, error) {
+	return intType{}.Decode(c, v)
+}
+
+func (t yearType) Encode(c Col, v interface{}) (interface{}, error) {
+	if n, ok := v.(json.Number); ok {
+		return t.Parse(c, n.String())
+	}
+	return nil, errorInvalidType
+}
+
+func (yearType) Parse(c Col, raw string) (interface{}, error) {
+	y, err := strconv.Atoi(raw)
+	if err != nil {
+		return nil, errorInvalidType
+	}
+	if y != 0 && (y < 1901 || y > 2155) {
+		return nil, errorInvalidValue
+	}
+	return y, nil
+}
+
+func (yearType) Zero(Col) (interface{}, bool) { return nil, false }
+
+func (enumType) Kind() string { return "string" }
+
+func (enumType) Decode(c Col, v interface{}) (interface{}, error) {
+	if s, ok := bytesOrString(v); ok {
+		return s, nil
+	}
+	return nil, errorInvalidType
+}
+
+func (t enumType) Encode(c Col, v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		return t.Parse(c, s)
+	}
+	return nil, errorInvalidType
+}
+
+func (t enumType) Parse(c Col, raw string) (interface{}, error) {
+	values := []string{raw}
+	if t.set {
+		if raw == "" {
+			return raw, nil
+		}
+		values = strings.Split(raw, ",")
+	}
+	members := c.typeArgs()
+	for _, v := range values {
+		found := false
+		for _, m := range members {
+			if v == m {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errorInvalidValue
+		}
+	}
+	return raw, nil
+}
+
+func (t enumType) Zero(c Col) (interface{}, bool) {
+	if t.set {
+		return "", true
+	}
+	if members := c.typeArgs(); len(members) > 0 {
+		return members[0], true
+	}
+	return nil, false
+}
+
+func (jsonType) Kind() string { return "" }
+
+func (jsonType) Decode(c Col, v interface{}) (interface{}, error) {
+	if s, ok := bytesOrString(v); ok {
+		if json.Valid([]byte(s)) {
+			return json.RawMessage(s), nil
+		}
+		return s, nil
+	}
+	return nil, errorInvalidType
+}
+
+func (jsonType) Encode(c Col, v interface{}) (interface{}, error) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil, errorInvalidValue
+	}
+	return string(bs), nil
+}
+
+func (jsonType) Parse(c Col, raw string) (interface{}, error) { return raw, nil }
+
+func (jsonType) Zero(Col) (interface{}, bool) { return "null", true }
+
+// blobs are base64 encoded in both directions
+func (blobType) Kind() string { return "string" }
+
+func (blobType) Format() string { return "byte" }
+
+func (blobType) Decode(c Col, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), nil
+	case string:
+		return base64.StdEncoding.EncodeToString([]byte(v)), nil
+	}
+	return nil, errorInvalidType
+}
+
+func (t blobType) Encode(c Col, v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok {
+		return t.Parse(c, s)
+	}
+	return nil, errorInvalidType
+}
+
+func (blobType) Parse(c Col, raw string) (interface{}, error) {
+	bs, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errorInvalidValue
+	}
+	return bs, nil
+}
+
+func (blobType) Zero(Col) (interface{}, bool) { return []byte{}, true }
+
+// fallbackType passes values of unknown column types through as is
+func (fallbackType) Kind() string { return "" }
+
+func (fallbackType) Decode(c Col, v interface{}) (interface{}, error) {
+	if s, ok := bytesOrString(v); ok {
+		return s, nil
+	}
+	return v, nil
+}
+
+func (fallbackType) Encode(c Col, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string, bool:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	}
+	return nil, errorInvalidType
+}
+
+func (fallbackType) Parse(c Col, raw string) (interface{}, error) { return raw, nil }
+
+func (fallbackType) Zero(Col) (interface{}, bool) { return nil, false }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestTypeRegistryEncode(t *testing.T) {
+	types := defaultTypes()
+	cases := []struct {
+		col  Col
+		in   interface{}
+		want interface{}
+		err  error
+	}{
+		{Col{Type: "bigint", Def: "bigint(20) unsigned"}, json.Number("18446744073709551615"), uint64(18446744073709551615), nil},
+		{Col{Type: "int", Def: "int(10) unsigned"}, json.Number("-1"), nil, errorInvalidValue},
+		{Col{Type: "int", Def: "int(11)"}, json.Number("1.5"), nil, errorInvalidType},
+		{Col{Type: "int", Def: "int(11)"}, "1", nil, errorInvalidType},
+		{Col{Type: "bool", Def: "tinyint(1)"}, true, true, nil},
+		{Col{Type: "float", Def: "float"}, json.Number("1.5"), 1.5, nil},
+		{Col{Type: "decimal", Def: "decimal(5,2)"}, "123.45", "123.45", nil},
+		{Col{Type: "decimal", Def: "decimal(5,2)"}, json.Number("-1.5"), "-1.5", nil},
+		{Col{Type: "decimal", Def: "decimal(5,2)"}, "1234.5", nil, errorInvalidValue},
+		{Col{Type: "decimal", Def: "decimal(5,2)"}, "1.234", nil, errorInvalidValue},
+		{Col{Type: "decimal", Def: "decimal(5,2)"}, "1e3", nil, errorInvalidValue},
+		{Col{Type: "date", Def: "date"}, "2017-11-22", "2017-11-22", nil},
+		{Col{Type: "datetime", Def: "datetime"}, "2017-11-22T23:33:12+03:00", "2017-11-22 20:33:12", nil},
+		{Col{Type: "datetime", Def: "datetime"}, "22.11.2017", nil, errorInvalidValue},
+		{Col{Type: "time", Def: "time"}, "23:33:12", "23:33:12", nil},
+		{Col{Type: "year", Def: "year(4)"}, json.Number("1900"), nil, errorInvalidValue},
+		{Col{Type: "enum", Def: "enum('new','it''s done')"}, "it's done", "it's done", nil},
+		{Col{Type: "enum", Def: "enum('new','done')"}, "old", nil, errorInvalidValue},
+		{Col{Type: "set", Def: "set('a','b','c')"}, "a,c", "a,c", nil},
+		{Col{Type: "set", Def: "set('a','b','c')"}, "a,d", nil, errorInvalidValue},
+		{Col{Type: "json", Def: "json"}, map[string]interface{}{"a": json.Number("1")}, `{"a":1}`, nil},
+		{Col{Type: "blob", Def: "blob"}, "aGVsbG8=", []byte("hello"), nil},
+		{Col{Type: "blob", Def: "blob"}, "not base64", nil, errorInvalidValue},
+	}
+	for i, c := range cases {
+		got, err := types.lookup(c.col).Encode(c.col, c.in)
+		if err != c.err {
+			t.Errorf("case %d [%s]: expected error %v, got %v", i, c.col.Def, c.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("case %d [%s]: expected %#v, got %#v", i, c.col.Def, c.want, got)
+		}
+	}
+}
+
+func TestTypeRegistryDecode(t *testing.T) {
+	types := defaultTypes()
+	cases := []struct {
+		col  Col
+		in   interface{}
+		want interface{}
+	}{
+		{Col{Type: "bigint", Def: "bigint(20) unsigned"}, []byte("18446744073709551615"), uint64(18446744073709551615)},
+		{Col{Type: "int", Def: "int(11)"}, int64(42), int64(42)},
+		{Col{Type: "bool", Def: "tinyint(1)"}, int64(1), true},
+		{Col{Type: "bool", Def: "bit(1)"}, []byte{0}, false},
+		{Col{Type: "decimal", Def: "decimal(10,2)"}, 12.5, "12.50"},
+		{Col{Type: "decimal", Def: "decimal(10,2)"}, []byte("12.50"), "12.50"},
+		{Col{Type: "datetime", Def: "datetime"}, []byte("2017-11-22 23:33:12"), "2017-11-22T23:33:12Z"},
+		{Col{Type: "date", Def: "date"}, []byte("0000-00-00"), "0000-00-00"},
+		{Col{Type: "json", Def: "json"}, []byte(`{"a": 1}`), json.RawMessage(`{"a": 1}`)},
+		{Col{Type: "blob", Def: "blob"}, []byte("hello"), "aGVsbG8="},
+		{Col{Type: "geometry", Def: "geometry"}, []byte("POINT(1 1)"), "POINT(1 1)"},
+	}
+	for i, c := range cases {
+		got, err := types.lookup(c.col).Decode(c.col, c.in)
+		if err != nil {
+			t.Errorf("case %d [%s]: unexpected error %v", i, c.col.Def, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("case %d [%s]: expected %#v, got %#v", i, c.col.Def, c.want, got)
+		}
+	}
+}
+
+func TestColumnTypes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		s := b.serve(t, []string{
+			`CREATE TABLE events (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  happened DATETIME NOT NULL,
+  day DATE DEFAULT NULL,
+  price DECIMAL(10,2) NOT NULL,
+  active BOOLEAN NOT NULL,
+  payload JSON DEFAULT NULL,
+  data BLOB DEFAULT NULL
+);`,
+		})
+
+		s.run(t, []Case{
+			Case{
+				Path:   "/events/",
+				Method: http.MethodPut,
+				Body: CR{
+					"happened": "2017-11-22T23:33:12Z",
+					"day":      "2017-11-22",
+					"price":    "12.50",
+					"active":   true,
+					"payload":  CR{"tags": []string{"a", "b"}},
+					"data":     "aGVsbG8=",
+				},
+				Result: CR{"response": CR{"id": 1}},
+			},
+			Case{
+				Path: "/events/1",
+				Result: CR{
+					"response": CR{
+						"record": CR{
+							"id":       1,
+							"happened": "2017-11-22T23:33:12Z",
+							"day":      "2017-11-22",
+							"price":    "12.50",
+							"active":   true,
+							"payload":  CR{"tags": []string{"a", "b"}},
+							"data":     "aGVsbG8=",
+						},
+					},
+				},
+			},
+			Case{
+				Path:   "/events/1",
+				Method: http.MethodPost,
+				Status: http.StatusUnprocessableEntity,
+				Body:   CR{"happened": "yesterday"},
+				Result: CR{"error": "field happened have invalid value", "code": "invalid_value", "field": "happened"},
+			},
+			Case{
+				Path:   "/events/1",
+				Method: http.MethodPost,
+				Status: http.StatusUnprocessableEntity,
+				Body:   CR{"price": "1.555"},
+				Result: CR{"error": "field price have invalid value", "code": "invalid_value", "field": "price"},
+			},
+			Case{
+				Path:   "/events/1",
+				Method: http.MethodPost,
+				Status: http.StatusBadRequest,
+				Body:   CR{"active": 1},
+				Result: CR{"error": "field active have invalid type", "code": "invalid_type", "field": "active"},
+			},
+			Case{
+				Path:  "/events",
+				Query: "fields=id&where[happened][gte]=2017-11-01&where[active]=true",
+				Result: CR{
+					"response": CR{
+						"records": []CR{CR{"id": 1}},
+					},
+				},
+			},
+		})
+	})
+}
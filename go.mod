@@ -5,7 +5,9 @@ go 1.18
 require (
 	github.com/go-playground/assert/v2 v2.0.1
 	github.com/go-sql-driver/mysql v1.6.0
+	github.com/lib/pq v1.10.7
 	github.com/mailru/easyjson v0.7.7
+	github.com/mattn/go-sqlite3 v1.14.16
 )
 
 require github.com/josharian/intern v1.0.0 // indirect